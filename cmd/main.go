@@ -113,6 +113,10 @@ func main() {
 }
 
 func init() {
+	// the load balancer connects from the private network and forwards
+	// the client address in X-Forwarded-For
+	srv.SetTrustedProxies(service.TrustedProxiesPrivate...)
+
 	srv.RegisterRoute("*/mul/:a/:b", "GET", func(w http.ResponseWriter, r *http.Request) {
 		a, a_ok := service.HttpParameterT[float64](r, "a")
 		b, b_ok := service.HttpParameterT[float64](r, "b")
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 
 	"github.com/Moonlight-Companies/gohttp/service"
@@ -12,6 +13,13 @@ import (
 
 var srv *service.Service = service.NewServiceWithName("project-test-service")
 
+// note is a resource updated with optimistic concurrency: clients must send
+// the ETag they last read in If-Match.
+var note = struct {
+	mu   sync.Mutex
+	Text string `json:"text"`
+}{Text: "hello"}
+
 func main() {
 	srv.Start()
 	defer srv.Close()
@@ -39,16 +47,15 @@ func init() {
 		})
 	})
 
-	srv.RegisterRoute("*/add", "GET", func(w http.ResponseWriter, r *http.Request) {
+	srv.RegisterRouteErr("*/add", "GET", func(w http.ResponseWriter, r *http.Request) error {
 		a, a_ok := service.HttpParameterT[int](r, "a")
 		b, b_ok := service.HttpParameterT[int](r, "b")
 
 		if !a_ok || !b_ok {
-			service.WriteError(w, errors.New("missing parameters"))
-			return
+			return errors.New("missing parameters")
 		}
 
-		service.WriteT(w, map[string]interface{}{
+		return service.WriteT(w, map[string]interface{}{
 			"a":      a,
 			"b":      b,
 			"result": a + b,
@@ -58,4 +65,37 @@ func init() {
 	srv.RegisterRoute("*/foo/bar/test", "GET", func(w http.ResponseWriter, r *http.Request) {
 		service.WriteRaw(w, "text/plain", "Foo, Bar!")
 	})
+
+	srv.RegisterRoute("*/note", "GET", func(w http.ResponseWriter, r *http.Request) {
+		note.mu.Lock()
+		defer note.mu.Unlock()
+
+		current := map[string]string{"text": note.Text}
+		etag, _ := service.ETagT(current)
+		w.Header().Set("ETag", etag)
+		service.WriteT(w, current)
+	})
+
+	srv.RegisterRoute("*/note", "PUT", func(w http.ResponseWriter, r *http.Request) {
+		text, ok := service.HttpParameterT[string](r, "text")
+		if !ok {
+			service.WriteError(w, errors.New("missing parameters"))
+			return
+		}
+
+		note.mu.Lock()
+		defer note.mu.Unlock()
+
+		etag, _ := service.ETagT(map[string]string{"text": note.Text})
+		if !service.HttpIfMatchSatisfied(r, etag) {
+			service.WritePreconditionFailed(w)
+			return
+		}
+
+		note.Text = text
+		updated := map[string]string{"text": note.Text}
+		etag, _ = service.ETagT(updated)
+		w.Header().Set("ETag", etag)
+		service.WriteT(w, updated)
+	})
 }
@@ -0,0 +1,371 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A batch bundles several calls to one service into a single round trip.
+// The envelope posted to the batch endpoint is
+//
+//	{"requests": [{"id": "0", "method": "GET", "path": "/add", "parameters": {"a": 1}}]}
+//
+// and the endpoint answers 200 with one response per request, in any order:
+//
+//	{"responses": [{"id": "0", "status": 200, "body": {"sum": 3}}]}
+//
+// A failing request only fails its own response, which carries its status
+// and an "error" message instead of a body.
+
+// BatchRequest is one call within a batch envelope. Method defaults to POST.
+type BatchRequest struct {
+	ID         string                 `json:"id"`
+	Method     string                 `json:"method,omitempty"`
+	Path       string                 `json:"path"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// BatchResponse is the outcome of one BatchRequest, matched to it by ID.
+type BatchResponse struct {
+	ID     string          `json:"id"`
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+type batchEnvelope struct {
+	Requests  []BatchRequest  `json:"requests,omitempty"`
+	Responses []BatchResponse `json:"responses,omitempty"`
+}
+
+// InvokeRequest is one call made by InvokeBatch. Call is the path handled
+// by the batch endpoint's service, such as "/add".
+type InvokeRequest struct {
+	Method     string
+	Call       string
+	Parameters map[string]interface{}
+}
+
+// InvokeResult is the outcome of one InvokeRequest. Err is an *InvokeError
+// when the call completed with a non-200 status.
+type InvokeResult struct {
+	Call       string
+	StatusCode int
+	Body       []byte
+	Err        error
+}
+
+// ErrBatchResponseMissing is set on results the batch endpoint did not answer.
+var ErrBatchResponseMissing = errors.New("no response in batch")
+
+// InvokeBatch posts calls to the batch endpoint Call in one round trip,
+// with the default 30 second timeout. Results are returned in the order of
+// calls. The error is only set when the batch itself failed; per-call
+// failures are reported in each result's Err.
+func InvokeBatch(Call string, calls []InvokeRequest) ([]InvokeResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return InvokeBatchCtx(ctx, Call, calls)
+}
+
+// InvokeBatchCtx is InvokeBatch bound to ctx.
+func InvokeBatchCtx(ctx context.Context, Call string, calls []InvokeRequest) ([]InvokeResult, error) {
+	return InvokeBatchWith(ctx, DefaultInvoker, Call, calls)
+}
+
+// InvokeBatchWith is InvokeBatch through the given invoker.
+func InvokeBatchWith(ctx context.Context, invoker Invoker, Call string, calls []InvokeRequest) ([]InvokeResult, error) {
+	requests := make([]BatchRequest, len(calls))
+	for i, call := range calls {
+		requests[i] = BatchRequest{
+			ID:         strconv.Itoa(i),
+			Method:     call.Method,
+			Path:       call.Call,
+			Parameters: call.Parameters,
+		}
+	}
+
+	body, err := invoker.Invoke(ctx, Call, map[string]interface{}{"requests": requests})
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope batchEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid batch response from %s: %w", Call, err)
+	}
+
+	results := make([]InvokeResult, len(calls))
+	answered := make([]bool, len(calls))
+	for _, response := range envelope.Responses {
+		i, err := strconv.Atoi(response.ID)
+		if err != nil || i < 0 || i >= len(calls) || answered[i] {
+			continue
+		}
+		answered[i] = true
+
+		result := InvokeResult{
+			Call:       calls[i].Call,
+			StatusCode: response.Status,
+			Body:       []byte(response.Body),
+		}
+		if response.Status != http.StatusOK {
+			errBody := result.Body
+			if response.Error != "" {
+				errBody = []byte(response.Error)
+			}
+			result.Err = &InvokeError{Call: calls[i].Call, StatusCode: response.Status, Body: errBody}
+		}
+		results[i] = result
+	}
+
+	for i := range results {
+		if !answered[i] {
+			results[i] = InvokeResult{Call: calls[i].Call, Err: fmt.Errorf("%w: %s", ErrBatchResponseMissing, calls[i].Call)}
+		}
+	}
+
+	return results, nil
+}
+
+// MaxBatchRequests bounds the number of requests in one batch envelope.
+const MaxBatchRequests = 100
+
+// DefaultBatchRequestTimeout bounds each request of a batch unless changed
+// with SetBatchRequestTimeout.
+const DefaultBatchRequestTimeout = 30 * time.Second
+
+var (
+	ErrBatchTooLarge = fmt.Errorf("batch exceeds %d requests", MaxBatchRequests)
+	ErrBatchNested   = errors.New("batch requests cannot contain batches")
+	// ErrBatchRequestTimeout is the error of a batch request that did not
+	// answer within the batch request timeout.
+	ErrBatchRequestTimeout = errors.New("batch request timed out")
+)
+
+// batchStrippedHeaders are headers of the batch request that do not apply
+// to the requests it carries: each has its own body, and its response is
+// embedded in the batch response rather than sent on its own.
+var batchStrippedHeaders = []string{
+	"Content-Length",
+	"Content-Type",
+	"Content-Encoding",
+	"Accept-Encoding",
+	"Idempotency-Key",
+}
+
+const parameter_batch = parameterKey("batch")
+
+// RegisterBatch registers a POST endpoint at uri that accepts the batch
+// envelope sent by InvokeBatch. Each request is dispatched through the
+// service's own routing, parameters, and handlers as if it had arrived on
+// its own, carrying the batch request's headers except those describing
+// the batch body and its encoding, and Idempotency-Key, which identifies
+// the batch as a whole. GET and DELETE requests receive their parameters in
+// the query string, objects and arrays JSON-encoded, others as a JSON body.
+//
+// Each request runs under its own timeout, see SetBatchRequestTimeout. Once
+// it expires the request's context is done and further writes fail, which
+// ends streaming handlers such as an SSE route; a request that wrote
+// nothing by then is answered 504.
+func (s *Service) RegisterBatch(uri string) *serviceHttpRouteInfo {
+	return s.RegisterRoutePOST(uri, func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value(parameter_batch) != nil {
+			WriteError(w, ErrBatchNested)
+			return
+		}
+
+		var envelope batchEnvelope
+		if err := json.Unmarshal(httpRequestBody(r), &envelope); err != nil {
+			WriteError(w, fmt.Errorf("invalid batch envelope: %w", err))
+			return
+		}
+		if len(envelope.Requests) > MaxBatchRequests {
+			WriteError(w, ErrBatchTooLarge)
+			return
+		}
+
+		s.mu.RLock()
+		timeout := s.batchTimeout
+		s.mu.RUnlock()
+		if timeout <= 0 {
+			timeout = DefaultBatchRequestTimeout
+		}
+
+		ctx := context.WithValue(r.Context(), parameter_batch, true)
+		responses := make([]BatchResponse, 0, len(envelope.Requests))
+		for _, request := range envelope.Requests {
+			responses = append(responses, s.serveBatchRequest(ctx, r, request, timeout))
+		}
+
+		WriteT(w, batchEnvelope{Responses: responses})
+	})
+}
+
+// SetBatchRequestTimeout bounds each request of a batch registered with
+// RegisterBatch. Zero restores DefaultBatchRequestTimeout.
+func (s *Service) SetBatchRequestTimeout(d time.Duration) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batchTimeout = d
+	return s
+}
+
+// httpRequestBody returns the raw body stored by parameters, or nil.
+func httpRequestBody(r *http.Request) []byte {
+	body, _ := r.Context().Value(parameter_request_body).([]byte)
+	return body
+}
+
+// serveBatchRequest runs one request of a batch and records its response.
+func (s *Service) serveBatchRequest(ctx context.Context, outer *http.Request, request BatchRequest, timeout time.Duration) BatchResponse {
+	response := BatchResponse{ID: request.ID}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	sub, err := newBatchSubRequest(ctx, outer, request)
+	if err != nil {
+		response.Status = http.StatusBadRequest
+		response.Error = err.Error()
+		return response
+	}
+
+	rec := &batchResponseWriter{header: make(http.Header), ctx: ctx}
+	s.serve(rec, sub)
+
+	if rec.status == 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		response.Status = http.StatusGatewayTimeout
+		response.Error = ErrBatchRequestTimeout.Error()
+		return response
+	}
+	response.Status = rec.status
+	if response.Status == 0 {
+		response.Status = http.StatusOK
+	}
+	body := rec.body.Bytes()
+
+	if response.Status != http.StatusOK {
+		var errorBody struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &errorBody) == nil && errorBody.Error != "" {
+			response.Error = errorBody.Error
+		} else {
+			response.Error = strings.TrimSpace(string(body))
+		}
+		return response
+	}
+
+	if json.Valid(body) {
+		response.Body = json.RawMessage(body)
+	} else if len(body) > 0 {
+		// non-JSON responses are carried as a JSON string
+		response.Body, _ = json.Marshal(string(body))
+	}
+	return response
+}
+
+// newBatchSubRequest builds the request for one entry of a batch.
+func newBatchSubRequest(ctx context.Context, outer *http.Request, request BatchRequest) (*http.Request, error) {
+	method := strings.ToUpper(request.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+	if !strings.HasPrefix(request.Path, "/") {
+		return nil, fmt.Errorf("invalid batch path: %q", request.Path)
+	}
+
+	target, err := url.Parse(request.Path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid batch path: %w", err)
+	}
+
+	var body []byte
+	if method == http.MethodGet || method == http.MethodDelete || method == http.MethodHead {
+		query := target.Query()
+		for k, v := range request.Parameters {
+			value, err := batchQueryValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid batch parameter %s: %w", k, err)
+			}
+			query.Set(k, value)
+		}
+		target.RawQuery = query.Encode()
+	} else if request.Parameters != nil {
+		if body, err = json.Marshal(request.Parameters); err != nil {
+			return nil, err
+		}
+	}
+
+	sub, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	sub.Header = outer.Header.Clone()
+	for _, key := range batchStrippedHeaders {
+		sub.Header.Del(key)
+	}
+	if body != nil {
+		sub.Header.Set("Content-Type", "application/json")
+	}
+	sub.Host = outer.Host
+	sub.RemoteAddr = outer.RemoteAddr
+	return sub, nil
+}
+
+// batchQueryValue formats a parameter for the query string: strings as
+// they are, other scalars in their JSON form, and objects and arrays
+// JSON-encoded, so a handler can decode them again.
+func batchQueryValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", nil
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// batchResponseWriter records the response of one batch request. Writes
+// fail once ctx is done, so a handler that streams stops when its batch
+// request times out.
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	ctx    context.Context
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+func (w *batchResponseWriter) Write(b []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
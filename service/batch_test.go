@@ -0,0 +1,227 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestInvokeBatchDemultiplexesResponses(t *testing.T) {
+	restoreToken(t)
+	SetToken("test-token")
+	var received batchEnvelope
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/batch" {
+			t.Errorf("posted to %s, want /batch", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		// answered out of order, with a failure, and missing the last call
+		w.Write([]byte(`{"responses": [
+			{"id": "1", "status": 404, "error": "no such user"},
+			{"id": "0", "status": 200, "body": {"sum": 3}},
+			{"id": "7", "status": 200, "body": {}}
+		]}`))
+	}))
+	t.Cleanup(srv.Close)
+	invoker := &HttpInvoker{BaseURL: srv.URL + "/", Client: srv.Client()}
+
+	results, err := InvokeBatchWith(context.Background(), invoker, "batch", []InvokeRequest{
+		{Call: "/add", Parameters: map[string]interface{}{"a": 1, "b": 2}},
+		{Method: "GET", Call: "/users/9"},
+		{Call: "/never"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(received.Requests) != 3 || received.Requests[1].Method != "GET" || received.Requests[1].Path != "/users/9" {
+		t.Errorf("server received %+v", received.Requests)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	if results[0].Err != nil || results[0].StatusCode != 200 || string(results[0].Body) != `{"sum": 3}` {
+		t.Errorf("result 0 = %+v", results[0])
+	}
+	var invokeErr *InvokeError
+	if !errors.As(results[1].Err, &invokeErr) || invokeErr.StatusCode != 404 || string(invokeErr.Body) != "no such user" {
+		t.Errorf("result 1 error = %v", results[1].Err)
+	}
+	if !errors.Is(results[2].Err, ErrBatchResponseMissing) {
+		t.Errorf("result 2 error = %v, want ErrBatchResponseMissing", results[2].Err)
+	}
+}
+
+func TestInvokeBatchFailsOnInvalidEnvelope(t *testing.T) {
+	invoker := replyServer(t, http.StatusOK, "application/json", `[1, 2]`)
+	if _, err := InvokeBatchWith(context.Background(), invoker, "batch", []InvokeRequest{{Call: "/a"}}); err == nil {
+		t.Fatal("expected an error for a response that is not an envelope")
+	}
+}
+
+// postBatch posts requests to the batch endpoint at /batch on s with the
+// extra header, and returns the responses by id.
+func postBatch(t *testing.T, s *Service, header http.Header, requests ...BatchRequest) map[string]BatchResponse {
+	t.Helper()
+	body, _ := json.Marshal(batchEnvelope{Requests: requests})
+	r := httptest.NewRequest("POST", "/batch", bytes.NewReader(body))
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("batch status = %d: %s", rec.Code, rec.Body)
+	}
+
+	var envelope batchEnvelope
+	if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	responses := make(map[string]BatchResponse)
+	for _, response := range envelope.Responses {
+		responses[response.ID] = response
+	}
+	return responses
+}
+
+func TestBatchOfGets(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	s.RegisterBatch("/batch")
+	s.RegisterRouteGET("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := HttpParameterT[string](r, "id")
+		if id == "404" {
+			WriteErrorCode(w, http.StatusNotFound, errors.New("no such user"))
+			return
+		}
+		WriteT(w, map[string]string{"id": id})
+	})
+
+	responses := postBatch(t, s, nil,
+		BatchRequest{ID: "a", Method: "GET", Path: "/users/1"},
+		BatchRequest{ID: "b", Method: "GET", Path: "/users/404"},
+		BatchRequest{ID: "c", Method: "GET", Path: "/nowhere"},
+		BatchRequest{ID: "d", Method: "GET", Path: "relative"},
+	)
+	if got := responses["a"]; got.Status != 200 || string(got.Body) != `{"id":"1"}` {
+		t.Errorf("a = %+v", got)
+	}
+	if got := responses["b"]; got.Status != 404 || got.Error != "no such user" {
+		t.Errorf("b = %+v", got)
+	}
+	if got := responses["c"]; got.Status != 404 {
+		t.Errorf("c = %+v", got)
+	}
+	if got := responses["d"]; got.Status != 400 {
+		t.Errorf("d = %+v", got)
+	}
+}
+
+func TestBatchQueryParametersAreJSONEncoded(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	s.RegisterBatch("/batch")
+	queries := make(chan url.Values, 1)
+	s.RegisterRouteGET("/search", func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.Query()
+	})
+
+	postBatch(t, s, nil, BatchRequest{ID: "0", Method: "GET", Path: "/search", Parameters: map[string]interface{}{
+		"q":      "go",
+		"limit":  10,
+		"exact":  true,
+		"tags":   []string{"a", "b"},
+		"filter": map[string]int{"min": 1},
+	}})
+	query := <-queries
+	want := map[string]string{"q": "go", "limit": "10", "exact": "true", "tags": `["a","b"]`, "filter": `{"min":1}`}
+	for k, v := range want {
+		if got := query.Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+}
+
+func TestBatchStripsBatchOnlyHeaders(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	s.RegisterBatch("/batch")
+	headers := make(chan http.Header, 1)
+	s.RegisterRoutePOST("/echo", func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		WriteT(w, HttpParameters(r))
+	})
+
+	responses := postBatch(t, s, http.Header{
+		"Authorization":   {"Bearer t"},
+		"Idempotency-Key": {"k1"},
+		"Accept-Encoding": {"gzip"},
+	}, BatchRequest{ID: "0", Path: "/echo", Parameters: map[string]interface{}{"a": 1}})
+
+	header := <-headers
+	if header.Get("Authorization") != "Bearer t" {
+		t.Error("Authorization was not forwarded")
+	}
+	for _, key := range []string{"Idempotency-Key", "Accept-Encoding", "Content-Length"} {
+		if header.Get(key) != "" {
+			t.Errorf("%s = %q was forwarded", key, header.Get(key))
+		}
+	}
+	if got := responses["0"]; got.Status != 200 || string(got.Body) != `{"a":1}` {
+		t.Errorf("response = %+v, want an uncompressed JSON body", got)
+	}
+}
+
+func TestBatchRequestTimeout(t *testing.T) {
+	s := NewServiceBuilder().Build().SetBatchRequestTimeout(50 * time.Millisecond)
+	s.RegisterBatch("/batch")
+	s.RegisterRouteGET("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	s.RegisterRouteGET("/stream", func(w http.ResponseWriter, r *http.Request) {
+		WriteStream(w, "text/plain", endlessReader{})
+	})
+	s.RegisterRouteGET("/fast", func(w http.ResponseWriter, r *http.Request) {
+		WriteT(w, "ok")
+	})
+
+	done := make(chan map[string]BatchResponse, 1)
+	go func() {
+		done <- postBatch(t, s, nil,
+			BatchRequest{ID: "slow", Method: "GET", Path: "/slow"},
+			BatchRequest{ID: "stream", Method: "GET", Path: "/stream"},
+			BatchRequest{ID: "fast", Method: "GET", Path: "/fast"},
+		)
+	}()
+	var responses map[string]BatchResponse
+	select {
+	case responses = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch did not finish")
+	}
+
+	if got := responses["slow"]; got.Status != http.StatusGatewayTimeout || got.Error != ErrBatchRequestTimeout.Error() {
+		t.Errorf("slow = %+v", got)
+	}
+	if got := responses["stream"]; got.Status != http.StatusOK {
+		t.Errorf("stream = %d, want the 200 it started with", got.Status)
+	}
+	if got := responses["fast"]; got.Status != http.StatusOK || string(got.Body) != `"ok"` {
+		t.Errorf("fast = %+v", got)
+	}
+}
+
+// endlessReader never runs out of data.
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
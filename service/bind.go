@@ -0,0 +1,111 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// HttpBind fills a struct of type T from the unified parameters, so a GET
+// handler binds its query string the same way a POST handler binds its JSON
+// body. Each exported field reads the parameter named by its param tag,
+// falling back to its json tag and then its field name; `param:"-"` skips
+// it. Parameters missing from the request leave the field at its zero value.
+//
+// When several sources provide a field, the one that won in HttpParameters
+// is used, so with DefaultParameterPrecedence a JSON body field overrides
+// a path parameter, which overrides the query string.
+// Query and form strings are parsed into numeric, bool, and slice fields;
+// a key repeated in the query fills a slice with all its values.
+func HttpBind[T any](r *http.Request) (result T, err error) {
+	target := reflect.ValueOf(&result).Elem()
+	if target.Kind() != reflect.Struct {
+		return result, fmt.Errorf("HttpBind: %T is not a struct", result)
+	}
+
+	params, values := requestParametersOf(r).merged()
+
+	fields := target.Type()
+	for i := 0; i < fields.NumField(); i++ {
+		field := fields.Field(i)
+		name := bindName(field)
+		if name == "" {
+			continue
+		}
+		value, ok := params[name]
+		if !ok {
+			continue
+		}
+
+		dest := target.Field(i).Addr().Interface()
+		kind := field.Type.Kind()
+		if kind == reflect.Slice && len(values[name]) > 0 && field.Type.Elem().Kind() != reflect.Uint8 {
+			err = bindStrings(dest, values[name])
+		} else {
+			err = bindValue(dest, value, field.Type)
+		}
+		if err != nil {
+			return result, fmt.Errorf("parameter %s: %w", name, err)
+		}
+	}
+	return result, nil
+}
+
+// bindName returns the parameter name a struct field binds to, or "" when
+// the field is unexported or skipped.
+func bindName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	for _, key := range []string{"param", "json"} {
+		if tag, ok := field.Tag.Lookup(key); ok {
+			name, _, _ := strings.Cut(tag, ",")
+			if name == "-" {
+				return ""
+			}
+			if name != "" {
+				return name
+			}
+		}
+	}
+	return field.Name
+}
+
+// bindValue stores value into dest. Strings from the query or a form are
+// tried as JSON literals first so "42" and "true" fill int and bool fields;
+// everything else goes through its JSON encoding.
+func bindValue(dest interface{}, value interface{}, t reflect.Type) error {
+	if s, ok := value.(string); ok && !isStringType(t) {
+		if json.Unmarshal([]byte(s), dest) == nil {
+			return nil
+		}
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, dest)
+}
+
+// bindStrings fills the slice dest with every value of a repeated key.
+func bindStrings(dest interface{}, values []string) error {
+	slice := reflect.ValueOf(dest).Elem()
+	elements := reflect.MakeSlice(slice.Type(), len(values), len(values))
+	for i, v := range values {
+		element := elements.Index(i)
+		if err := bindValue(element.Addr().Interface(), v, element.Type()); err != nil {
+			return err
+		}
+	}
+	slice.Set(elements)
+	return nil
+}
+
+func isStringType(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.String
+}
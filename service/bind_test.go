@@ -0,0 +1,78 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type bindQuery struct {
+	ID      int      `param:"id"`
+	Name    string   `json:"name"`
+	Active  bool     `param:"active"`
+	Tags    []string `param:"tag"`
+	Limit   int
+	Skipped string `param:"-"`
+}
+
+// bindService serves HttpBind[bindQuery] for GET and POST on /users/:id.
+func bindService(t *testing.T) (*Service, chan bindQuery) {
+	t.Helper()
+	bound := make(chan bindQuery, 1)
+	s := NewServiceBuilder().Build()
+	s.RegisterRouteMethods("/users/:id", []string{"GET", "POST"}, func(w http.ResponseWriter, r *http.Request) {
+		query, err := HttpBind[bindQuery](r)
+		if err != nil {
+			WriteErrorCode(w, http.StatusBadRequest, err)
+			return
+		}
+		bound <- query
+	})
+	return s, bound
+}
+
+func TestHttpBindQuery(t *testing.T) {
+	s, bound := bindService(t)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/users/7?name=ada&active=true&tag=a&tag=b&Limit=5&Skipped=x", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	want := bindQuery{ID: 7, Name: "ada", Active: true, Tags: []string{"a", "b"}, Limit: 5}
+	if got := <-bound; !reflect.DeepEqual(got, want) {
+		t.Fatalf("bound %+v, want %+v", got, want)
+	}
+}
+
+func TestHttpBindJSONBodyOverridesQuery(t *testing.T) {
+	s, bound := bindService(t)
+
+	r := httptest.NewRequest("POST", "/users/7?name=query&Limit=5", strings.NewReader(`{"name": "body", "active": true, "tag": ["x"]}`))
+	r.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	want := bindQuery{ID: 7, Name: "body", Active: true, Tags: []string{"x"}, Limit: 5}
+	if got := <-bound; !reflect.DeepEqual(got, want) {
+		t.Fatalf("bound %+v, want %+v", got, want)
+	}
+}
+
+func TestHttpBindRejectsBadValues(t *testing.T) {
+	s, _ := bindService(t)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/users/7?Limit=many", nil))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "Limit") {
+		t.Fatalf("got %d %q, want 400 naming Limit", rec.Code, rec.Body)
+	}
+
+	if _, err := HttpBind[int](httptest.NewRequest("GET", "/", nil)); err == nil {
+		t.Error("HttpBind accepted a non-struct type")
+	}
+}
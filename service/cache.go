@@ -0,0 +1,193 @@
+package service
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRouteCacheSize is the number of responses a route cache keeps
+// before evicting the least recently used.
+const DefaultRouteCacheSize = 256
+
+// routeCache holds the cached GET responses of one route.
+type routeCache struct {
+	ttl     time.Duration
+	size    int
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	hits    atomic.Int64
+	misses  atomic.Int64
+}
+
+type routeCacheEntry struct {
+	key     string
+	expires time.Time
+	status  int
+	header  http.Header
+	body    []byte
+	// bodyHash identifies the request body a kept idempotent response
+	// belongs to.
+	bodyHash string
+}
+
+func newRouteCache(ttl time.Duration, size int) *routeCache {
+	return &routeCache{
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// SetCache caches the route's successful GET responses for ttl, keyed by
+// path and query, so identical requests within ttl are answered without
+// running the handler. Requests sending Cache-Control: no-cache bypass the
+// cache and refresh it. The cache is shared by every caller, so do not use
+// it for responses that depend on who is asking. Zero disables caching.
+func (s *serviceHttpRouteInfo) SetCache(ttl time.Duration) *serviceHttpRouteInfo {
+	return s.SetCacheSize(ttl, DefaultRouteCacheSize)
+}
+
+// SetCacheSize is SetCache with the number of cached responses bounded by
+// size instead of DefaultRouteCacheSize.
+func (s *serviceHttpRouteInfo) SetCacheSize(ttl time.Duration, size int) *serviceHttpRouteInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ttl <= 0 || size <= 0 {
+		s.cache = nil
+	} else {
+		s.cache = newRouteCache(ttl, size)
+	}
+	return s
+}
+
+func (c *routeCache) key(r *http.Request) string {
+	key := r.URL.Path + "?" + r.URL.RawQuery
+	if body := httpRequestBody(r); len(body) > 0 {
+		if sum, err := Hash(body); err == nil {
+			key += "#" + strconv.FormatUint(sum, 16)
+		}
+	}
+	return key
+}
+
+func (c *routeCache) get(key string) (*routeCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*routeCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry, true
+}
+
+func (c *routeCache) put(entry *routeCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[entry.key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[entry.key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*routeCacheEntry).key)
+	}
+}
+
+// wrap serves GET requests from the cache and fills it from fn.
+func (c *routeCache) wrap(fn ServiceHandleFunc) ServiceHandleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			fn(w, r)
+			return
+		}
+
+		key := c.key(r)
+		noCache := strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache")
+		if !noCache {
+			if entry, ok := c.get(key); ok {
+				c.hits.Add(1)
+				for k, v := range entry.header {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(entry.status)
+				w.Write(entry.body)
+				return
+			}
+		}
+		c.misses.Add(1)
+
+		rec := &cacheRecorder{ResponseWriter: w}
+		fn(rec, r)
+		if rec.status == http.StatusOK && !rec.failed {
+			c.put(&routeCacheEntry{
+				key:     key,
+				expires: time.Now().Add(c.ttl),
+				status:  rec.status,
+				header:  storedHeader(w.Header()),
+				body:    rec.body.Bytes(),
+			})
+		}
+	}
+}
+
+// storedHeader copies the response header kept with a cached or
+// idempotent response. Set-Cookie is left out, as a cookie issued to one
+// client must not be handed to another.
+func storedHeader(header http.Header) http.Header {
+	stored := header.Clone()
+	stored.Del("Set-Cookie")
+	return stored
+}
+
+// cacheRecorder copies the response it passes through for the cache.
+type cacheRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	failed bool
+}
+
+func (w *cacheRecorder) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *cacheRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.body.Write(b[:n])
+	if err != nil {
+		w.failed = true
+	}
+	return n, err
+}
+
+func (w *cacheRecorder) prettyJSON() bool {
+	return wantsPrettyJSON(w.ResponseWriter)
+}
+
+func (w *cacheRecorder) errorRendering() *errorRendering {
+	return errorRenderingOf(w.ResponseWriter)
+}
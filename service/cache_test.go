@@ -0,0 +1,139 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// countingRoute registers a cached GET route at uri that answers with the
+// number of times it has run.
+func countingRoute(s *Service, uri string) (*serviceHttpRouteInfo, *int) {
+	runs := 0
+	route := s.RegisterRouteGET(uri, func(w http.ResponseWriter, r *http.Request) {
+		runs++
+		WriteT(w, runs)
+	})
+	return route, &runs
+}
+
+func get(s *Service, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", target, nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestCacheRunsHandlerOnce(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	route, runs := countingRoute(s, "/report")
+	route.SetCache(time.Minute)
+
+	first := get(s, "/report?day=1", nil)
+	second := get(s, "/report?day=1", nil)
+	if *runs != 1 {
+		t.Fatalf("handler ran %d times, want 1", *runs)
+	}
+	if first.Body.String() != "1" || second.Body.String() != "1" || second.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("cached response = %q %v", second.Body, second.Header())
+	}
+
+	get(s, "/report?day=2", nil)
+	if *runs != 2 {
+		t.Errorf("a different query was served from the cache")
+	}
+
+	stats := s.Stats()[0]
+	if stats.CacheHits != 1 || stats.CacheMisses != 2 {
+		t.Errorf("hits, misses = %d, %d, want 1, 2", stats.CacheHits, stats.CacheMisses)
+	}
+}
+
+func TestCacheNoCacheBypassesAndRefreshes(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	route, runs := countingRoute(s, "/report")
+	route.SetCache(time.Minute)
+
+	get(s, "/report", nil)
+	if got := get(s, "/report", http.Header{"Cache-Control": {"no-cache"}}).Body.String(); got != "2" {
+		t.Fatalf("no-cache response = %s, want a fresh 2", got)
+	}
+	if got := get(s, "/report", nil).Body.String(); got != "2" || *runs != 2 {
+		t.Fatalf("after refresh = %s with %d runs, want the refreshed 2", got, *runs)
+	}
+}
+
+func TestCacheExpires(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	route, runs := countingRoute(s, "/report")
+	route.SetCache(20 * time.Millisecond)
+
+	get(s, "/report", nil)
+	time.Sleep(30 * time.Millisecond)
+	get(s, "/report", nil)
+	if *runs != 2 {
+		t.Fatalf("handler ran %d times, want 2 after the ttl", *runs)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	route, runs := countingRoute(s, "/report")
+	route.SetCacheSize(time.Minute, 2)
+
+	get(s, "/report?n=1", nil)
+	get(s, "/report?n=2", nil)
+	get(s, "/report?n=1", nil) // n=2 is now the oldest
+	get(s, "/report?n=3", nil)
+	if *runs != 3 {
+		t.Fatalf("handler ran %d times, want 3", *runs)
+	}
+
+	get(s, "/report?n=1", nil)
+	if *runs != 3 {
+		t.Error("recently used n=1 was evicted")
+	}
+	get(s, "/report?n=2", nil)
+	if *runs != 4 {
+		t.Error("least recently used n=2 was not evicted")
+	}
+}
+
+func TestCacheSkipsErrors(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	runs := 0
+	s.RegisterRouteGET("/flaky", func(w http.ResponseWriter, r *http.Request) {
+		runs++
+		WriteErrorCode(w, http.StatusServiceUnavailable, fmt.Errorf("try again"))
+	}).SetCache(time.Minute)
+
+	get(s, "/flaky", nil)
+	get(s, "/flaky", nil)
+	if runs != 2 {
+		t.Fatalf("handler ran %d times, want 2: errors must not be cached", runs)
+	}
+}
+
+func TestCacheDropsSetCookie(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	s.RegisterRouteGET("/page", func(w http.ResponseWriter, r *http.Request) {
+		WriteCookie(w, http.Cookie{Name: "session", Value: "first-caller"})
+		WriteT(w, "page")
+	}).SetCache(time.Minute)
+
+	if first := get(s, "/page", nil); first.Header().Get("Set-Cookie") == "" {
+		t.Fatal("first response lost its cookie")
+	}
+	second := get(s, "/page", nil)
+	if second.Body.String() != `"page"` {
+		t.Fatalf("cached body = %q", second.Body)
+	}
+	if cookie := second.Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("cached response replayed Set-Cookie %q", cookie)
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Moonlight-Companies/goconvert/glob"
+)
+
+// SetCaseInsensitivePaths makes route matching ignore the case of the
+// request path and of the literal parts of registered patterns. Handlers
+// still see the original path, and named parameter values keep the case
+// they had in the request.
+func (s *Service) SetCaseInsensitivePaths(enabled bool) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.caseInsensitive = enabled
+	return s
+}
+
+var namedParameterPattern = regexp.MustCompile(`:[A-Za-z0-9_]+`)
+
+// lowerPattern lowercases a route pattern except for its named parameter
+// names, so ":userId" still yields a "userId" parameter.
+func lowerPattern(uri string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range namedParameterPattern.FindAllStringIndex(uri, -1) {
+		b.WriteString(strings.ToLower(uri[last:loc[0]]))
+		b.WriteString(uri[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(strings.ToLower(uri[last:]))
+	return b.String()
+}
+
+// resolvePathFold is resolvePath for case insensitive matching. The caller
+// holds s.mu.
+func (s *Service) resolvePathFold(method, path string) (*serviceHttpRouteInfo, map[string]string, bool) {
+	lowerPath := strings.ToLower(path)
+
+	// look for exact match first
+	for _, route := range s.foldExactRoutes[lowerPath] {
+		if route.MatchMethod(method) {
+			return route, nil, true
+		}
+	}
+
+	// look for glob match
+	var buf [16]*serviceHttpRouteInfo
+	for _, route := range s.foldRoutes.candidates(lowerPath, buf[:0]) {
+		if !route.MatchMethod(method) {
+			continue
+		}
+
+		matched, named_parameters, err := glob.MatchNamed(route.lowerURI, lowerPath)
+		if err == nil && matched {
+			return route, restoreParameterCase(route.lowerURI, lowerPath, path, named_parameters), true
+		}
+	}
+
+	return nil, nil, false
+}
+
+// restoreParameterCase maps named parameter values matched against the
+// lowercased path back to the original text of path. The matcher reports
+// values but not where they matched, so each place a value occurs is
+// probed by uppercasing it there and matching again: only the place the
+// parameter was taken from still matches the pattern's lowercase literals
+// and yields the uppercased value. When lowercasing changed the path
+// length the lowercased values are returned unchanged.
+func restoreParameterCase(lowerURI, lowerPath, path string, params map[string]string) map[string]string {
+	if len(lowerPath) != len(path) {
+		return params
+	}
+
+	for name, value := range params {
+		upper := strings.ToUpper(value)
+		if upper == value || len(upper) != len(value) {
+			// no letters whose case could differ, or none that can be probed
+			continue
+		}
+		for offset := 0; offset < len(lowerPath); {
+			idx := strings.Index(lowerPath[offset:], value)
+			if idx < 0 {
+				break
+			}
+			start := offset + idx
+			end := start + len(value)
+			probe := lowerPath[:start] + upper + lowerPath[end:]
+			if matched, probed, err := glob.MatchNamed(lowerURI, probe); err == nil && matched && probed[name] == upper {
+				params[name] = path[start:end]
+				break
+			}
+			offset = start + 1
+		}
+	}
+	return params
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCaseInsensitivePathsKeepParameterCase(t *testing.T) {
+	s := NewServiceBuilder().Build().SetCaseInsensitivePaths(true)
+	s.RegisterRouteGET("/users/:id", noopHandler)
+	s.RegisterRouteGET("*/service/name/add", noopHandler)
+	s.RegisterRouteGET("/files/:dir/:name", noopHandler)
+
+	tests := []struct {
+		path   string
+		uri    string
+		params map[string]string
+	}{
+		{"/Service/Name/Add", "*/service/name/add", nil},
+		{"/api/SERVICE/name/ADD", "*/service/name/add", nil},
+		{"/Users/AbC", "/users/:id", map[string]string{"id": "AbC"}},
+		// the value also occurs in the literal part of the pattern
+		{"/Users/users", "/users/:id", map[string]string{"id": "users"}},
+		{"/USERS/Users", "/users/:id", map[string]string{"id": "Users"}},
+		{"/Files/Docs/docs", "/files/:dir/:name", map[string]string{"dir": "Docs", "name": "docs"}},
+		{"/files/A/a", "/files/:dir/:name", map[string]string{"dir": "A", "name": "a"}},
+	}
+	for _, tt := range tests {
+		route, params, found := s.ResolveRoute(httptest.NewRequest("GET", tt.path, nil))
+		if !found || route.URI != tt.uri {
+			t.Errorf("%s: resolved %v, want %s", tt.path, route, tt.uri)
+			continue
+		}
+		for name, want := range tt.params {
+			if params[name] != want {
+				t.Errorf("%s: %s = %q, want %q", tt.path, name, params[name], want)
+			}
+		}
+	}
+}
+
+func TestCaseSensitiveByDefault(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	s.RegisterRouteGET("/users/:id", noopHandler)
+
+	if _, _, found := s.ResolveRoute(httptest.NewRequest("GET", "/Users/1", nil)); found {
+		t.Fatal("mixed-case path matched without SetCaseInsensitivePaths")
+	}
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"net/http"
+	"time"
+)
+
+// ChunkWriter writes one chunk of a WriteChunked response and flushes it to
+// the client.
+type ChunkWriter func(chunk []byte) error
+
+// WriteChunked streams a single long response of unknown length, flushing
+// each chunk as body writes it. The trailers named in trailers are declared
+// up front, and the values body returns for them are sent after the last
+// chunk, for example a checksum or a completion status. Trailers are only
+// delivered over chunked HTTP/1.1 and HTTP/2; clients read them from
+// http.Response.Trailer once the body has been consumed. Like an SSE
+// stream, the response is meant to outlive the server's write timeout, so
+// its write deadline is cleared.
+func WriteChunked(w http.ResponseWriter, contentType string, trailers []string, body func(write ChunkWriter) (http.Header, error)) error {
+	for _, key := range trailers {
+		w.Header().Add("Trailer", key)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Del("Content-Length")
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	write := func(chunk []byte) error {
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	values, err := body(write)
+	for _, key := range trailers {
+		if value := values.Get(key); value != "" {
+			w.Header().Set(key, value)
+		}
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWriteChunkedSendsTrailerAfterBody(t *testing.T) {
+	// the response takes longer than the write timeout
+	s, base := startTestService(t, NewServiceBuilder().SetWriteTimeout(100*time.Millisecond))
+	s.RegisterRouteGET("/compute", func(w http.ResponseWriter, r *http.Request) {
+		WriteChunked(w, "text/plain", []string{"X-Checksum", "X-Status"}, func(write ChunkWriter) (http.Header, error) {
+			for i := range 4 {
+				time.Sleep(50 * time.Millisecond)
+				if err := write([]byte(fmt.Sprintf("part %d\n", i))); err != nil {
+					return nil, err
+				}
+			}
+			return http.Header{"X-Checksum": {"abc123"}, "X-Status": {"done"}}, nil
+		})
+	})
+
+	resp, err := http.Get(base + "/compute")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+		t.Errorf("TransferEncoding = %v, want chunked", resp.TransferEncoding)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	if string(body) != "part 0\npart 1\npart 2\npart 3\n" {
+		t.Errorf("body = %q", body)
+	}
+	// trailers are only known once the body has been read
+	if got := resp.Trailer.Get("X-Checksum"); got != "abc123" {
+		t.Errorf("X-Checksum trailer = %q, want abc123", got)
+	}
+	if got := resp.Trailer.Get("X-Status"); got != "done" {
+		t.Errorf("X-Status trailer = %q, want done", got)
+	}
+}
+
+func TestWriteChunkedReturnsBodyError(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	failure := errors.New("computation failed")
+	errs := make(chan error, 1)
+	s.RegisterRouteGET("/compute", func(w http.ResponseWriter, r *http.Request) {
+		errs <- WriteChunked(w, "text/plain", []string{"X-Status"}, func(write ChunkWriter) (http.Header, error) {
+			write([]byte("partial"))
+			return http.Header{"X-Status": {"failed"}}, failure
+		})
+	})
+
+	resp, err := http.Get(base + "/compute")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err := <-errs; !errors.Is(err, failure) {
+		t.Errorf("WriteChunked = %v, want the body's error", err)
+	}
+	if got := resp.Trailer.Get("X-Status"); got != "failed" {
+		t.Errorf("X-Status trailer = %q, want failed", got)
+	}
+}
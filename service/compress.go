@@ -0,0 +1,64 @@
+package service
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// gzipFlushWriter gzips a streamed response. Flush flushes the compressor
+// before the connection so every message reaches the client as it is sent.
+type gzipFlushWriter struct {
+	http.ResponseWriter
+	gz *gzip.Writer
+}
+
+func newGzipFlushWriter(w http.ResponseWriter) *gzipFlushWriter {
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.Header().Del("Content-Length")
+	return &gzipFlushWriter{ResponseWriter: w, gz: gzip.NewWriter(w)}
+}
+
+func (w *gzipFlushWriter) Write(b []byte) (int, error) {
+	return w.gz.Write(b)
+}
+
+func (w *gzipFlushWriter) Flush() {
+	w.gz.Flush()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close writes the gzip footer. It does not close the connection.
+func (w *gzipFlushWriter) Close() error {
+	return w.gz.Close()
+}
+
+func (w *gzipFlushWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *gzipFlushWriter) streamStarted() {
+	markStreamStarted(w.ResponseWriter)
+}
+
+// acceptsGzip reports whether r's Accept-Encoding allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	return acceptsEncoding(r, "gzip")
+}
+
+// acceptsEncoding reports whether r's Accept-Encoding allows coding.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), coding) {
+			continue
+		}
+		// "gzip;q=0" explicitly refuses it
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrPreconditionFailed is written by WritePreconditionFailed.
+var ErrPreconditionFailed = errors.New("resource has changed, fetch it again and retry")
+
+// ETag returns a strong, quoted entity tag for data, built on Hash.
+func ETag(data []byte) string {
+	hash, _ := Hash(data)
+	return fmt.Sprintf(`"%016x"`, hash)
+}
+
+// ETagT returns the ETag of v's JSON encoding, the same bytes WriteT sends
+// without pretty printing.
+func ETagT[T any](v T) (string, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return ETag(encoded), nil
+}
+
+// HttpIfMatch returns the request's If-Match header, if it sent one.
+func HttpIfMatch(r *http.Request) (string, bool) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	return value, value != ""
+}
+
+// HttpIfMatchSatisfied reports whether a mutation of a resource whose
+// current tag is etag may proceed: the request sent no If-Match, or one
+// listing etag or "*". Weak tags never match, as If-Match requires strong
+// comparison. Pass "" as etag when the resource does not exist.
+func HttpIfMatchSatisfied(r *http.Request, etag string) bool {
+	header, ok := HttpIfMatch(r)
+	if !ok {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if etag != "" && (candidate == "*" || candidate == etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// WritePreconditionFailed answers 412 Precondition Failed, for a request
+// whose If-Match does not match the resource's current ETag.
+func WritePreconditionFailed(w http.ResponseWriter) {
+	WriteErrorCode(w, http.StatusPreconditionFailed, ErrPreconditionFailed)
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// documentStore is the example resource: a single document updated with
+// optimistic concurrency.
+type documentStore struct {
+	mu   sync.Mutex
+	text string
+}
+
+func (d *documentStore) register(s *Service) {
+	s.RegisterRouteGET("/doc", func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		etag, _ := ETagT(d.text)
+		w.Header().Set("ETag", etag)
+		WriteT(w, d.text)
+	})
+	s.RegisterRoute("/doc", "PUT", func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		etag, _ := ETagT(d.text)
+		if !HttpIfMatchSatisfied(r, etag) {
+			WritePreconditionFailed(w)
+			return
+		}
+		d.text, _ = HttpParameterT[string](r, "text")
+		etag, _ = ETagT(d.text)
+		w.Header().Set("ETag", etag)
+		WriteT(w, d.text)
+	})
+}
+
+func putDocument(s *Service, ifMatch, text string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("PUT", "/doc", strings.NewReader(`{"text": "`+text+`"}`))
+	r.Header.Set("Content-Type", "application/json")
+	if ifMatch != "" {
+		r.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestOptimisticConcurrency(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	doc := &documentStore{text: "v1"}
+	doc.register(s)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/doc", nil))
+	etag := rec.Header().Get("ETag")
+	if want, _ := ETagT("v1"); etag != want {
+		t.Fatalf("ETag = %q, want %q", etag, want)
+	}
+
+	// two clients read v1; the first update wins
+	first := putDocument(s, etag, "alice")
+	if first.Code != http.StatusOK || first.Header().Get("ETag") == etag {
+		t.Fatalf("first update: status %d, ETag %q", first.Code, first.Header().Get("ETag"))
+	}
+	conflict := putDocument(s, etag, "bob")
+	if conflict.Code != http.StatusPreconditionFailed {
+		t.Fatalf("conflicting update: status = %d, want 412", conflict.Code)
+	}
+	if doc.text != "alice" {
+		t.Fatalf("document = %q after the conflict, want alice", doc.text)
+	}
+
+	// retrying with the current tag succeeds
+	if retry := putDocument(s, first.Header().Get("ETag"), "bob"); retry.Code != http.StatusOK || doc.text != "bob" {
+		t.Fatalf("retry: status %d, document %q", retry.Code, doc.text)
+	}
+}
+
+func TestHttpIfMatchSatisfied(t *testing.T) {
+	tests := []struct {
+		ifMatch string
+		etag    string
+		want    bool
+	}{
+		{"", `"a"`, true},
+		{`"a"`, `"a"`, true},
+		{`"b", "a"`, `"a"`, true},
+		{`"b"`, `"a"`, false},
+		{`W/"a"`, `"a"`, false},
+		{"*", `"a"`, true},
+		{"*", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("PUT", "/", nil)
+		if tt.ifMatch != "" {
+			r.Header.Set("If-Match", tt.ifMatch)
+		}
+		if got := HttpIfMatchSatisfied(r, tt.etag); got != tt.want {
+			t.Errorf("If-Match %s against %s = %v, want %v", tt.ifMatch, tt.etag, got, tt.want)
+		}
+	}
+}
@@ -1,11 +1,17 @@
 package service
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"strings"
+	"text/template"
+	"time"
 )
 
-const CONSTANT_SSE_JS = `
+// CONSTANT_SSE_JS_TEMPLATE is the source of the embedded SSE client, a
+// text/template rendered with SseClientOptions.
+const CONSTANT_SSE_JS_TEMPLATE = `
 // inlined from project/service/constant.go
 class SSEClient {
   constructor(endpoint) {
@@ -13,24 +19,46 @@ class SSEClient {
       throw new Error('Endpoint must be provided')
     }
     this.endpoint = endpoint
-    this.callbackEndpoint = endpoint
+    this.callbackEndpoint = endpoint + {{json .CallbackPath}}
     this.eventSource = null
     this.connected = false
     this.client_id = null
+    this.csrf_token = null
     this.messageHandlers = []
-    this.reconnectDelay = 3000
+    this.reconnectDelay = {{.ReconnectDelayMs}}
+    this.maxReconnectDelay = {{.MaxReconnectDelayMs}}
+    this.reconnectAttempts = 0
+    this.retryHint = 0
+    this.lastEventId = null
+    this.topics = new Set()
+    this.reconnectTimer = null
+    this.closed = false
     this._connect()
   }
 
   _connect() {
+    if (this.closed) {
+      return
+    }
     if (this.eventSource) {
       this.eventSource.close()
     }
-    this.eventSource = new EventSource(this.endpoint)
-    this.eventSource.onopen = (event) => {
+    // a new EventSource does not send Last-Event-ID, so pass it along
+    let url = this.endpoint
+    if (this.lastEventId) {
+      url += (url.includes('?') ? '&' : '?') + 'last_event_id=' + encodeURIComponent(this.lastEventId)
+    }
+    const source = new EventSource(url)
+    this.eventSource = source
+    source.onopen = (event) => {
       this.connected = true
+      this.reconnectAttempts = 0
+      this.retryHint = 0
     }
-    this.eventSource.onmessage = (event) => {
+    source.onmessage = (event) => {
+      if (event.lastEventId) {
+        this.lastEventId = event.lastEventId
+      }
       let msg = null
       try {
         msg = JSON.parse(event.data)
@@ -42,6 +70,22 @@ class SSEClient {
         switch (msg.event) {
           case 'on_connect':
             this.client_id = msg.client_id
+            if (msg.callback_path) {
+              this.callbackEndpoint = this.endpoint + msg.callback_path
+            }
+            // the server accepts no callbacks, so publish nothing
+            this.callbacksDisabled = msg.callbacks === false
+            this.csrf_token = msg.csrf_token || null
+            // a new session starts without topics, subscribe again
+            this.topics.forEach((topic) => this.publish({ event: '__subscribe', topic: topic }))
+            // the server's retry hint replaces the initial backoff delay
+            if (msg.retry_ms) {
+              this.reconnectDelay = msg.retry_ms
+            }
+            break
+          case 'going_away':
+            // the server is shutting down, wait at least this long
+            this.retryHint = msg.retry_ms || 0
             break
           case 'ping':
             this.publish({ event: 'pong', payload: msg.payload })
@@ -56,36 +100,78 @@ class SSEClient {
           console.error('Error in message handler', err)
         }
       })
+      // Confirm delivery of messages sent with DirectMessageAck
+      if (msg && msg.ack_requested && msg.id) {
+        this.publish({ event: 'ack', id: msg.id })
+      }
     }
-    this.eventSource.onerror = (error) => {
+    source.onerror = (error) => {
+      // ignore errors from a source replaced or closed by disconnect()
+      if (source !== this.eventSource) {
+        return
+      }
       this.connected = false
       console.error('SSE error:', error)
-      this.eventSource.close()
-      // Reconnect after a delay
-      setTimeout(() => {
-        this._connect()
-      }, this.reconnectDelay)
+      source.close()
+      this._scheduleReconnect()
+    }
+  }
+
+  // Reconnect with exponential backoff and jitter so clients don't all
+  // reconnect at once after a server restart
+  _scheduleReconnect() {
+    if (this.closed || this.reconnectTimer) {
+      return
     }
+    const ceiling = Math.min(this.maxReconnectDelay, this.reconnectDelay * Math.pow(2, this.reconnectAttempts))
+    const delay = Math.max(this.retryHint, ceiling / 2 + Math.random() * ceiling / 2)
+    this.reconnectAttempts++
+    this.reconnectTimer = setTimeout(() => {
+      this.reconnectTimer = null
+      this._connect()
+    }, delay)
   }
 
   // Sends data to the server using the callback endpoint
   publish(data) {
+    if (this.callbacksDisabled) {
+      return
+    }
     if (!this.client_id) {
       console.error('Client ID not set, cannot publish')
       return
     }
+    const headers = {
+      'Content-Type': 'application/json',
+      'X-Client-ID': this.client_id
+    }
+    if (this.csrf_token) {
+      headers['X-CSRF-Token'] = this.csrf_token
+    }
     fetch(this.callbackEndpoint, {
       method: 'POST',
-      headers: {
-        'Content-Type': 'application/json',
-        'X-Client-ID': this.client_id
-      },
+      headers: headers,
       body: JSON.stringify(data)
     }).catch((error) => {
       console.error('Publish error:', error)
     })
   }
 
+  // receive messages the server sends with BroadcastTopic
+  subscribe(topic) {
+    this.topics.add(topic)
+    if (this.client_id) {
+      this.publish({ event: '__subscribe', topic: topic })
+    }
+  }
+
+  unsubscribe(topic) {
+    this.topics.delete(topic)
+    if (this.client_id) {
+      this.publish({ event: '__unsubscribe', topic: topic })
+    }
+  }
+
   // register a message handler callback
   onMessage(callback) {
     if (typeof callback === 'function') {
@@ -94,6 +180,11 @@ class SSEClient {
   }
 
   disconnect() {
+    this.closed = true
+    if (this.reconnectTimer) {
+      clearTimeout(this.reconnectTimer)
+      this.reconnectTimer = null
+    }
     if (this.eventSource) {
       this.eventSource.close()
       this.eventSource = null
@@ -109,10 +200,109 @@ export default function createSSE(endpoint) {
 
 `
 
+// SseClientOptions configures the embedded sse.js client and SseClient.
+type SseClientOptions struct {
+	// ReconnectDelay is the base delay before reconnecting after an error.
+	// It doubles with each failed attempt, with jitter, up to
+	// MaxReconnectDelay and resets once a connection opens. Defaults to 3s.
+	ReconnectDelay time.Duration
+	// MaxReconnectDelay caps the reconnect backoff. Defaults to 60s.
+	MaxReconnectDelay time.Duration
+	// CallbackPath is appended to the SSE endpoint to form the URL that
+	// publish posts to. Empty posts to the endpoint itself. A server
+	// registered with DisableInlineCallback sends its own path on connect.
+	CallbackPath string
+	// Logger receives the errors SseClient recovers from, a failed
+	// connection or resubscription. Nil discards them. sse.js ignores it.
+	Logger Logger
+}
+
+// ReconnectDelayMs is ReconnectDelay in milliseconds, as used by the script.
+func (o SseClientOptions) ReconnectDelayMs() int64 {
+	return o.ReconnectDelay.Milliseconds()
+}
+
+// MaxReconnectDelayMs is MaxReconnectDelay in milliseconds.
+func (o SseClientOptions) MaxReconnectDelayMs() int64 {
+	return o.MaxReconnectDelay.Milliseconds()
+}
+
+var sseClientTemplate = template.Must(template.New("sse.js").Funcs(template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	},
+}).Parse(CONSTANT_SSE_JS_TEMPLATE))
+
+// RenderSseClient renders the embedded SSE client script for options.
+func RenderSseClient(options SseClientOptions) ([]byte, error) {
+	if options.ReconnectDelay <= 0 {
+		options.ReconnectDelay = 3 * time.Second
+	}
+	if options.MaxReconnectDelay <= 0 {
+		options.MaxReconnectDelay = 60 * time.Second
+	}
+	if options.MaxReconnectDelay < options.ReconnectDelay {
+		options.MaxReconnectDelay = options.ReconnectDelay
+	}
+
+	var b bytes.Buffer
+	if err := sseClientTemplate.Execute(&b, options); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// CONSTANT_SSE_JS is the embedded SSE client rendered with default options.
+var CONSTANT_SSE_JS = func() string {
+	script, err := RenderSseClient(SseClientOptions{})
+	if err != nil {
+		panic(err)
+	}
+	return string(script)
+}()
+
+// SetSseClientOptions configures the sse.js served by this service. The
+// script is rendered once here and served with an ETag, so browsers can
+// revalidate it instead of downloading it on every page load.
+func (s *Service) SetSseClientOptions(options SseClientOptions) error {
+	script, err := RenderSseClient(options)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sseClientJS = script
+	return nil
+}
+
+// ServeEmbeddedSSEClient controls whether any path ending in /sse.js is
+// answered with the embedded client. It is enabled by default; disable it
+// to serve your own sse.js from the static directory.
+func (s *Service) ServeEmbeddedSSEClient(enabled bool) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.noEmbeddedSseClient = !enabled
+	return s
+}
+
 func (s *Service) static_constant(w http.ResponseWriter, r *http.Request) (bool, error) {
 	if strings.HasSuffix(r.URL.Path, "/sse.js") {
-		w.Header().Set("Content-Type", "application/javascript")
-		w.Write([]byte(CONSTANT_SSE_JS))
+		s.mu.RLock()
+		script := s.sseClientJS
+		disabled := s.noEmbeddedSseClient
+		s.mu.RUnlock()
+		if disabled {
+			return false, nil
+		}
+		if script == nil {
+			script = []byte(CONSTANT_SSE_JS)
+		}
+
+		if err := writeStaticContent(w, r, "application/javascript", "no-cache", script); err != nil {
+			return true, err
+		}
 		return true, nil
 	}
 
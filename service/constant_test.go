@@ -0,0 +1,239 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSseJsHeaders(t *testing.T) {
+	s := NewServiceBuilder().Build()
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/static/sse.js", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/javascript" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cl := w.Header().Get("Content-Length"); cl != strconv.Itoa(len(CONSTANT_SSE_JS)) {
+		t.Errorf("Content-Length = %q, want %d", cl, len(CONSTANT_SSE_JS))
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("Cache-Control = %q, want no-cache", cc)
+	}
+	if w.Body.String() != CONSTANT_SSE_JS {
+		t.Error("body is not the embedded client")
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	r := httptest.NewRequest("GET", "/static/sse.js", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf("conditional GET: status = %d with %d bytes, want 304 and no body", w.Code, w.Body.Len())
+	}
+}
+
+func TestServeEmbeddedSSEClientDisabled(t *testing.T) {
+	s := NewServiceBuilder().Build().ServeEmbeddedSSEClient(false)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/sse.js", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", w.Code)
+	}
+}
+
+func TestOwnSseJsWinsWhenEmbeddedClientDisabled(t *testing.T) {
+	dir := t.TempDir()
+	const own = "// our own client\n"
+	if err := os.WriteFile(filepath.Join(dir, "sse.js"), []byte(own), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	embedded := NewServiceBuilder().Build().SetStaticPath(dir)
+	w := httptest.NewRecorder()
+	embedded.ServeHTTP(w, httptest.NewRequest("GET", "/sse.js", nil))
+	if w.Body.String() == own {
+		t.Fatal("the static file was served while the embedded client is enabled")
+	}
+
+	s := NewServiceBuilder().Build().SetStaticPath(dir).ServeEmbeddedSSEClient(false)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/sse.js", nil))
+	if w.Code != http.StatusOK || w.Body.String() != own {
+		t.Fatalf("status %d, body %q, want the static sse.js", w.Code, w.Body)
+	}
+}
+
+func TestSseClientOptionsAreRendered(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	if err := s.SetSseClientOptions(SseClientOptions{
+		ReconnectDelay: 1500 * time.Millisecond,
+		CallbackPath:   "/hooks",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/sse.js", nil))
+	script := w.Body.String()
+	if !strings.Contains(script, "this.reconnectDelay = 1500") {
+		t.Error("configured reconnect delay is not in the script")
+	}
+	if !strings.Contains(script, `this.callbackEndpoint = endpoint + "/hooks"`) {
+		t.Error("configured callback path is not in the script")
+	}
+
+	defaults := httptest.NewRecorder()
+	NewServiceBuilder().Build().ServeHTTP(defaults, httptest.NewRequest("GET", "/sse.js", nil))
+	if w.Header().Get("ETag") == defaults.Header().Get("ETag") {
+		t.Error("configured script has the same ETag as the default one")
+	}
+	if !strings.Contains(defaults.Body.String(), "this.reconnectDelay = 3000") {
+		t.Error("default reconnect delay is not 3000ms")
+	}
+}
+
+// sseClientHarness drives the rendered client with a fake EventSource and
+// timers it fires by hand, and prints what the client did as JSON.
+const sseClientHarness = `
+import createSSE from './sse.mjs'
+
+const sources = []
+globalThis.EventSource = class {
+  constructor(url) {
+    this.url = url
+    this.closed = false
+    sources.push(this)
+  }
+  close() {
+    this.closed = true
+  }
+}
+const timers = []
+globalThis.setTimeout = (fn, delay) => timers.push({ fn, delay, cleared: false })
+globalThis.clearTimeout = (id) => { timers[id - 1].cleared = true }
+const fetched = []
+globalThis.fetch = (url, init) => {
+  fetched.push({ url, client: init.headers['X-Client-ID'], body: init.body })
+  return Promise.resolve()
+}
+let random = 1
+Math.random = () => random
+console.error = () => {}
+
+const current = () => sources[sources.length - 1]
+const fire = () => timers[timers.length - 1].fn()
+
+const client = createSSE('/events')
+const handled = []
+client.onMessage((msg) => handled.push(msg.event))
+current().onopen()
+current().onmessage({ data: JSON.stringify({ event: 'on_connect', client_id: 'c1' }) })
+client.publish({ event: 'hello' })
+
+// a second error before the reconnect fires schedules nothing more
+current().onerror()
+current().onerror()
+for (let i = 0; i < 3; i++) {
+  fire()
+  current().onerror()
+}
+random = 0
+fire()
+current().onerror()
+random = 1
+fire()
+current().onopen()
+current().onerror()
+
+const connects = sources.length
+client.disconnect()
+const cleared = timers[timers.length - 1].cleared
+// a timer already running and a late error from the closed source
+fire()
+sources[sources.length - 1].onerror()
+
+console.log(JSON.stringify({
+  delays: timers.map((timer) => timer.delay),
+  connects,
+  connectsAfterDisconnect: sources.length,
+  cleared,
+  fetched,
+  handled,
+}))
+`
+
+func TestSseClientReconnectBackoff(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+	script, err := RenderSseClient(SseClientOptions{
+		ReconnectDelay:    100 * time.Millisecond,
+		MaxReconnectDelay: 400 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sse.mjs"), script, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	harness := filepath.Join(dir, "harness.mjs")
+	if err := os.WriteFile(harness, []byte(sseClientHarness), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(node, harness).Output()
+	if err != nil {
+		t.Fatalf("node: %v %s", err, out)
+	}
+	var got struct {
+		Delays                  []float64
+		Connects                int
+		ConnectsAfterDisconnect int
+		Cleared                 bool
+		Fetched                 []struct{ URL, Client, Body string }
+		Handled                 []string
+	}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	// doubling up to the cap, half the ceiling with no jitter, and back to
+	// the base delay after a connection opened
+	if want := []float64{100, 200, 400, 400, 200, 100}; !slices.Equal(got.Delays, want) {
+		t.Errorf("reconnect delays = %v, want %v", got.Delays, want)
+	}
+	if got.Connects != 6 {
+		t.Errorf("%d connections, want 6", got.Connects)
+	}
+	if !got.Cleared {
+		t.Error("disconnect left the pending reconnect scheduled")
+	}
+	if got.ConnectsAfterDisconnect != got.Connects {
+		t.Errorf("%d connections after disconnect, want %d", got.ConnectsAfterDisconnect, got.Connects)
+	}
+	if len(got.Fetched) != 1 || got.Fetched[0].URL != "/events" || got.Fetched[0].Client != "c1" || got.Fetched[0].Body != `{"event":"hello"}` {
+		t.Errorf("publish fetched %+v, want one POST to /events from c1", got.Fetched)
+	}
+	if !slices.Equal(got.Handled, []string{"on_connect"}) {
+		t.Errorf("onMessage handlers saw %v, want [on_connect]", got.Handled)
+	}
+}
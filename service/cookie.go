@@ -0,0 +1,70 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// HttpCookie returns the value of the named cookie.
+func HttpCookie(r *http.Request, name string) (string, bool) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// WriteCookie adds a Set-Cookie header for cookie. It must be called before
+// the response is written. Cookies without a Path default to "/".
+func WriteCookie(w http.ResponseWriter, cookie http.Cookie) {
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	http.SetCookie(w, &cookie)
+}
+
+// signCookie returns an HMAC-SHA256 signature of the cookie's name and
+// value, so a value cannot be moved to another cookie name either.
+func signCookie(secret []byte, name, value string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(name))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// WriteSignedCookie writes cookie with its value signed with secret, for
+// lightweight tokens the client can read but not alter. The value is not
+// encrypted. Read it back with HttpSignedCookie.
+func WriteSignedCookie(w http.ResponseWriter, secret []byte, cookie http.Cookie) {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(cookie.Value))
+	cookie.Value = encoded + "." + signCookie(secret, cookie.Name, encoded)
+	WriteCookie(w, cookie)
+}
+
+// HttpSignedCookie returns the value of a cookie written by
+// WriteSignedCookie, or false if it is missing or was tampered with.
+func HttpSignedCookie(r *http.Request, secret []byte, name string) (string, bool) {
+	raw, ok := HttpCookie(r, name)
+	if !ok {
+		return "", false
+	}
+
+	encoded, signature, ok := strings.Cut(raw, ".")
+	if !ok {
+		return "", false
+	}
+	expected := signCookie(secret, name, encoded)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return "", false
+	}
+
+	value, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	return string(value), true
+}
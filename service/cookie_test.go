@@ -0,0 +1,76 @@
+package service
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// signedCookieRequest writes a signed cookie and returns a request that
+// sends it back with its value passed through tamper.
+func signedCookieRequest(secret []byte, value string, tamper func(string) string) *http.Request {
+	rec := httptest.NewRecorder()
+	WriteSignedCookie(rec, secret, http.Cookie{Name: "session", Value: value})
+	cookie := rec.Result().Cookies()[0]
+	cookie.Value = tamper(cookie.Value)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(cookie)
+	return r
+}
+
+func TestSignedCookieRoundTrip(t *testing.T) {
+	secret := []byte("secret")
+	r := signedCookieRequest(secret, "user=ada; role=admin", func(v string) string { return v })
+
+	value, ok := HttpSignedCookie(r, secret, "session")
+	if !ok || value != "user=ada; role=admin" {
+		t.Fatalf("HttpSignedCookie = %q, %v", value, ok)
+	}
+	if _, ok := HttpSignedCookie(r, []byte("other"), "session"); ok {
+		t.Error("cookie verified with the wrong secret")
+	}
+	if _, ok := HttpSignedCookie(r, secret, "missing"); ok {
+		t.Error("missing cookie verified")
+	}
+}
+
+func TestTamperedSignedCookieIsRejected(t *testing.T) {
+	secret := []byte("secret")
+	for name, tamper := range map[string]func(string) string{
+		"value": func(v string) string {
+			_, signature, _ := strings.Cut(v, ".")
+			return base64.RawURLEncoding.EncodeToString([]byte("admin")) + "." + signature
+		},
+		"signature": func(v string) string {
+			encoded, _, _ := strings.Cut(v, ".")
+			return encoded + "." + signCookie([]byte("guess"), "session", encoded)
+		},
+		"unsigned": func(v string) string {
+			encoded, _, _ := strings.Cut(v, ".")
+			return encoded
+		},
+		"plain": func(string) string { return "admin" },
+	} {
+		r := signedCookieRequest(secret, "user", tamper)
+		if value, ok := HttpSignedCookie(r, secret, "session"); ok {
+			t.Errorf("%s: tampered cookie verified as %q", name, value)
+		}
+	}
+}
+
+func TestWriteCookieDefaultsPath(t *testing.T) {
+	rec := httptest.NewRecorder()
+	WriteCookie(rec, http.Cookie{Name: "theme", Value: "dark"})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(rec.Result().Cookies()[0])
+	if value, ok := HttpCookie(r, "theme"); !ok || value != "dark" {
+		t.Errorf("HttpCookie = %q, %v", value, ok)
+	}
+	if got := rec.Header().Get("Set-Cookie"); !strings.Contains(got, "Path=/") {
+		t.Errorf("Set-Cookie = %q, want Path=/", got)
+	}
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Drain starts the first phase of a two-phase shutdown: the readiness
+// route registered with RegisterReadiness answers 503 and the service
+// stops renewing its load balancer registration, so new traffic moves to
+// other instances while requests already routed here keep being served.
+// With goAway set, SSE clients are also sent going_away and their streams
+// ended, see SseServer.Shutdown. Call Shutdown to finish.
+func (s *Service) Drain(goAway bool) {
+	if s.draining.Swap(true) || !goAway {
+		return
+	}
+
+	s.mu.RLock()
+	sseServers := append([]*SseServer(nil), s.sseServers...)
+	s.mu.RUnlock()
+	for _, sse := range sseServers {
+		sse.Shutdown()
+	}
+}
+
+// Draining reports whether Drain has been called.
+func (s *Service) Draining() bool {
+	return s.draining.Load()
+}
+
+// RegisterReadiness serves a readiness check at uri: 200 while the service
+// takes traffic and 503 once Drain has been called.
+func (s *Service) RegisterReadiness(uri string) *serviceHttpRouteInfo {
+	return s.RegisterRouteGET(uri, func(w http.ResponseWriter, r *http.Request) {
+		if s.Draining() {
+			WriteErrorCode(w, http.StatusServiceUnavailable, errors.New("draining"))
+			return
+		}
+		WriteT(w, map[string]string{"status": "ready"})
+	})
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDrainFailsReadinessButKeepsServing(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	s.RegisterReadiness("/ready")
+	s.RegisterRouteGET("/work", noopHandler)
+	sse := s.RegisterSSE("/events", newTestSseHandler)
+
+	status := func(path string) int {
+		t.Helper()
+		resp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if got := status("/ready"); got != http.StatusOK {
+		t.Fatalf("readiness before drain = %d, want 200", got)
+	}
+
+	events, _ := connectSse(t, base+"/events")
+	s.Drain(false)
+	if !s.Draining() {
+		t.Fatal("Draining() = false after Drain")
+	}
+	if got := status("/ready"); got != http.StatusServiceUnavailable {
+		t.Errorf("readiness while draining = %d, want 503", got)
+	}
+	if got := status("/work"); got != http.StatusOK {
+		t.Errorf("route while draining = %d, want 200", got)
+	}
+	sse.Broadcast(SseMessage{"event": "still_open"})
+	if msg := readSseMessage(t, events); msg.Event() != "still_open" {
+		t.Errorf("event while draining = %q, want still_open", msg.Event())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// a connection the client dialed but never used counts as active
+	// for its first five seconds, so close it before shutting down
+	http.DefaultClient.CloseIdleConnections()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDrainWithGoAwayEndsStreams(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	s.RegisterRouteGET("/work", noopHandler)
+	s.RegisterSSE("/events", newTestSseHandler)
+
+	events, _ := connectSse(t, base+"/events")
+	s.Drain(true)
+	if msg := readSseMessage(t, events); msg.Event() != "going_away" {
+		t.Fatalf("event = %q, want going_away", msg.Event())
+	}
+
+	resp, err := http.Get(base + "/work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("route after going_away = %d, want 200", resp.StatusCode)
+	}
+}
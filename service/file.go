@@ -0,0 +1,57 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RegisterFile serves the file at filePath for GET and HEAD requests to
+// uri, for example /favicon.ico or /robots.txt. The file is read on each
+// request, so changes are picked up without a restart; it is meant for
+// small files.
+func (s *Service) RegisterFile(uri, filePath string) *serviceHttpRouteInfo {
+	return s.RegisterFileFS(uri, os.DirFS(filepath.Dir(filePath)), filepath.Base(filePath))
+}
+
+// RegisterFileFS is RegisterFile for the file name in fsys, such as an
+// embed.FS. Responses carry a content type from the name's extension, an
+// ETag, and Cache-Control: no-cache, and conditional and range requests
+// are handled by http.ServeContent.
+func (s *Service) RegisterFileFS(uri string, fsys fs.FS, name string) *serviceHttpRouteInfo {
+	return s.RegisterRouteMethods(uri, []string{http.MethodGet, http.MethodHead}, func(w http.ResponseWriter, r *http.Request) {
+		file, err := fsys.Open(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			WriteErrorCode(w, http.StatusInternalServerError, err)
+			return
+		}
+		defer file.Close()
+
+		var modTime time.Time
+		if info, err := file.Stat(); err == nil {
+			if info.IsDir() {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			modTime = info.ModTime()
+		}
+
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			WriteErrorCode(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set("ETag", ETag(contents))
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeContent(w, r, name, modTime, bytes.NewReader(contents))
+	})
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRegisterFileServesFavicon(t *testing.T) {
+	icon := []byte("\x00\x00\x01\x00favicon")
+	path := filepath.Join(t.TempDir(), "favicon.ico")
+	if err := os.WriteFile(path, icon, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServiceBuilder().Build()
+	s.RegisterFile("/favicon.ico", path)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/favicon.ico", nil))
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), icon) {
+		t.Fatalf("got %d %q, want the icon", rec.Code, rec.Body.Bytes())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/vnd.microsoft.icon" && ct != "image/x-icon" {
+		t.Errorf("Content-Type = %q, want an icon type", ct)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag != ETag(icon) || rec.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("ETag %q, Cache-Control %q", etag, rec.Header().Get("Cache-Control"))
+	}
+
+	revalidate := httptest.NewRequest("GET", "/favicon.ico", nil)
+	revalidate.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, revalidate)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("revalidation status = %d, want 304", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("POST", "/favicon.ico", nil))
+	if rec.Code == http.StatusOK {
+		t.Error("POST was served")
+	}
+}
+
+func TestRegisterFileFSServesNamedFile(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	s.RegisterFileFS("/robots.txt", fstest.MapFS{
+		"public/robots.txt": {Data: []byte("User-agent: *\n")},
+	}, "public/robots.txt")
+	s.RegisterFileFS("/missing.txt", fstest.MapFS{}, "missing.txt")
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/robots.txt", nil))
+	if rec.Body.String() != "User-agent: *\n" || rec.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("got %q as %q", rec.Body.String(), rec.Header().Get("Content-Type"))
+	}
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/missing.txt", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing file status = %d, want 404", rec.Code)
+	}
+}
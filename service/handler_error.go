@@ -0,0 +1,232 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// ServiceHandleErrFunc is a handler that returns its error instead of
+// writing it, leaving the response to the service's error renderer.
+type ServiceHandleErrFunc func(http.ResponseWriter, *http.Request) error
+
+// ErrorRenderer writes the response for an error: one returned by a
+// handler registered with RegisterRouteErr, written with WriteError or
+// WriteErrorCode, or a recovered panic, see SetPanicRecovery. statusCode is
+// the status the error is to be sent with.
+type ErrorRenderer func(w http.ResponseWriter, r *http.Request, statusCode int, err error)
+
+// HttpError is an error carrying the status code it is rendered with.
+type HttpError struct {
+	StatusCode int
+	Err        error
+}
+
+// NewHttpError returns err rendered with statusCode when returned from a
+// handler, for example NewHttpError(http.StatusNotFound, err).
+func NewHttpError(statusCode int, err error) error {
+	return &HttpError{StatusCode: statusCode, Err: err}
+}
+
+func (e *HttpError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HttpError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	// ErrNotFound is rendered as 404 Not Found.
+	ErrNotFound = errors.New("not found")
+	// ErrValidation is rendered as 422 Unprocessable Entity. Wrap it to
+	// describe what failed: fmt.Errorf("%w: name is required", ErrValidation).
+	ErrValidation = errors.New("validation failed")
+)
+
+type errorStatus struct {
+	target error
+	code   int
+}
+
+var (
+	errorStatusMu sync.RWMutex
+	// errorStatuses is checked newest first, so registrations override the
+	// built-in mappings.
+	errorStatuses = []errorStatus{
+		{ErrNotFound, http.StatusNotFound},
+		{ErrValidation, http.StatusUnprocessableEntity},
+		{ErrPreconditionFailed, http.StatusPreconditionFailed},
+		{ErrInvalidJSONBody, http.StatusBadRequest},
+		{ErrBodyTooLarge, http.StatusRequestEntityTooLarge},
+		{ErrUnsupportedContentEncoding, http.StatusUnsupportedMediaType},
+		{ErrJWTMissing, http.StatusUnauthorized},
+		{ErrJWTMalformed, http.StatusUnauthorized},
+		{ErrJWTSignature, http.StatusUnauthorized},
+		{ErrJWTExpired, http.StatusUnauthorized},
+		{ErrJWTNotYet, http.StatusUnauthorized},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+	}
+)
+
+// RegisterErrorStatus maps errors matching target with errors.Is to code
+// in RenderError. Later registrations take precedence, including over the
+// built-in mappings for ErrNotFound, ErrValidation, the request and token
+// errors of this package, and context.DeadlineExceeded.
+func RegisterErrorStatus(target error, code int) {
+	errorStatusMu.Lock()
+	defer errorStatusMu.Unlock()
+	errorStatuses = append(errorStatuses, errorStatus{target, code})
+}
+
+// ErrorStatus returns the status code RenderError uses for err: that of
+// an HttpError it wraps, else the newest RegisterErrorStatus match, else
+// 400 Bad Request.
+func ErrorStatus(err error) int {
+	var httpErr *HttpError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode
+	}
+
+	errorStatusMu.RLock()
+	defer errorStatusMu.RUnlock()
+	for i := len(errorStatuses) - 1; i >= 0; i-- {
+		if errors.Is(err, errorStatuses[i].target) {
+			return errorStatuses[i].code
+		}
+	}
+	return http.StatusBadRequest
+}
+
+// RenderError writes err in the shape of WriteError with the status code
+// from ErrorStatus.
+func RenderError(w http.ResponseWriter, err error) {
+	WriteErrorCode(w, ErrorStatus(err), err)
+}
+
+// DefaultErrorRenderer writes a minimal HTML error page to clients that
+// accept text/html, such as browsers navigating to the page, and the
+// {"error": ...} JSON of WriteError to everyone else.
+func DefaultErrorRenderer(w http.ResponseWriter, r *http.Request, statusCode int, err error) {
+	if !containsAcceptType(r.Header.Get("Accept"), "text/html") {
+		writeErrorJSON(w, statusCode, err)
+		return
+	}
+	title := fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode))
+	WriteHTML(w, fmt.Sprintf("<!DOCTYPE html>\n<html><head><title>%s</title></head>"+
+		"<body><h1>%s</h1><p>%s</p></body></html>\n",
+		title, title, html.EscapeString(err.Error())), statusCode)
+}
+
+// SetErrorRenderer sets how errors are presented, for example as a branded
+// HTML page. It is used for errors returned by handlers registered with
+// RegisterRouteErr, for WriteError and WriteErrorCode called with the
+// handler's ResponseWriter, and for recovered panics. The default is
+// DefaultErrorRenderer. A renderer calling WriteErrorCode itself gets the
+// plain JSON response rather than recursing.
+func (s *Service) SetErrorRenderer(fn ErrorRenderer) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errorRenderer = fn
+	return s
+}
+
+// SetPanicRecovery recovers panics in handlers, logging them with their
+// stack and answering 500 Internal Server Error through the error renderer
+// if the response has not started. Off by default, a panic is left to
+// net/http, which logs it and drops the connection.
+func (s *Service) SetPanicRecovery(enabled bool) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.panicRecovery = enabled
+	return s
+}
+
+// recoverPanic is deferred by ServeHTTP when panic recovery is enabled.
+// http.ErrAbortHandler is passed on, as it is a deliberate abort.
+func (s *Service) recoverPanic(w *responseWriter, r *http.Request) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	if p == http.ErrAbortHandler {
+		panic(p)
+	}
+	s.Logger.Errorln("handler panic", r.Method, r.URL.Path, p, string(debug.Stack()))
+	if w.Status() == 0 {
+		WriteErrorCode(w, http.StatusInternalServerError, errors.New(http.StatusText(http.StatusInternalServerError)))
+	}
+}
+
+// RegisterRouteErr registers a handler that returns an error. A nil error
+// means the handler wrote its response; anything else is written by the
+// error renderer, see SetErrorRenderer. Errors from WriteT and WriteRaw are
+// only logged, as the response has already been started. It panics like
+// RegisterRoute when the pattern and method are already registered.
+func (s *Service) RegisterRouteErr(uri, method string, fn ServiceHandleErrFunc) *serviceHttpRouteInfo {
+	return s.RegisterRoute(uri, method, func(w http.ResponseWriter, r *http.Request) {
+		err := fn(w, r)
+		if err == nil {
+			return
+		}
+		if errors.Is(err, ErrResponseWrite) || errors.Is(err, ErrResponseMarshal) {
+			logDebugw(s.Logger, "handler response failed", "uri", r.URL.Path, "error", err)
+			return
+		}
+
+		if errorRenderingOf(w) != nil {
+			RenderError(w, err)
+			return
+		}
+		// a middleware replaced the service's writer
+		s.mu.RLock()
+		render := s.errorRenderer
+		s.mu.RUnlock()
+		if render == nil {
+			render = DefaultErrorRenderer
+		}
+		render(w, r, ErrorStatus(err), err)
+	})
+}
+
+// errorRendering carries the error renderer of one request to
+// WriteErrorCode.
+type errorRendering struct {
+	render ErrorRenderer
+	r      *http.Request
+	active atomic.Bool
+}
+
+// errorRenderingWriter is implemented by the service's response writers
+// so WriteErrorCode can reach the error renderer.
+type errorRenderingWriter interface {
+	errorRendering() *errorRendering
+}
+
+func errorRenderingOf(w http.ResponseWriter) *errorRendering {
+	if ew, ok := w.(errorRenderingWriter); ok {
+		return ew.errorRendering()
+	}
+	return nil
+}
+
+// renderError writes err with the request's error renderer and reports
+// whether it did. It does not while a render is already under way, so a
+// renderer may fall back to WriteErrorCode.
+func (e *errorRendering) renderError(w http.ResponseWriter, statusCode int, err error) bool {
+	if e == nil || !e.active.CompareAndSwap(false, true) {
+		return false
+	}
+	defer e.active.Store(false)
+	render := e.render
+	if render == nil {
+		render = DefaultErrorRenderer
+	}
+	render(w, e.r, statusCode, err)
+	return true
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// errorRoute serves a RegisterRouteErr handler returning err at /item.
+func errorRoute(err error) *Service {
+	s := NewServiceBuilder().Build()
+	s.RegisterRouteErr("/item", "GET", func(w http.ResponseWriter, r *http.Request) error {
+		return err
+	})
+	return s
+}
+
+func TestRouteErrNotFoundIs404(t *testing.T) {
+	s := errorRoute(fmt.Errorf("item 7: %w", ErrNotFound))
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/item", nil))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "item 7: not found") {
+		t.Fatalf("got %d %q, want 404 with the error", rec.Code, rec.Body)
+	}
+}
+
+func TestRouteErrNilWritesHandlerResponse(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	s.RegisterRouteErr("/item", "GET", func(w http.ResponseWriter, r *http.Request) error {
+		return WriteT(w, map[string]int{"id": 7})
+	})
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/item", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"id":7`) {
+		t.Fatalf("got %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestRouteErrUsesErrorRenderer(t *testing.T) {
+	s := errorRoute(NewHttpError(http.StatusTeapot, errors.New("short and stout")))
+	s.SetErrorRenderer(func(w http.ResponseWriter, r *http.Request, statusCode int, err error) {
+		WriteRaw(w, "text/plain", fmt.Sprintf("%d: %v", statusCode, err), statusCode)
+	})
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/item", nil))
+	if rec.Code != http.StatusTeapot || rec.Body.String() != "418: short and stout" {
+		t.Fatalf("got %d %q, want the custom rendering", rec.Code, rec.Body)
+	}
+}
+
+// restoreErrorStatuses undoes RegisterErrorStatus calls when the test ends.
+func restoreErrorStatuses(t *testing.T) {
+	errorStatusMu.RLock()
+	saved := errorStatuses
+	errorStatusMu.RUnlock()
+	t.Cleanup(func() {
+		errorStatusMu.Lock()
+		errorStatuses = saved
+		errorStatusMu.Unlock()
+	})
+}
+
+var errQuota = errors.New("quota exceeded")
+
+func TestErrorStatusMappings(t *testing.T) {
+	restoreErrorStatuses(t)
+	RegisterErrorStatus(errQuota, http.StatusTooManyRequests)
+	RegisterErrorStatus(ErrValidation, http.StatusBadRequest)
+
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{ErrNotFound, http.StatusNotFound},
+		{fmt.Errorf("lookup: %w", ErrNotFound), http.StatusNotFound},
+		{fmt.Errorf("%w: name is required", ErrValidation), http.StatusBadRequest},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{fmt.Errorf("upstream: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{ErrBodyTooLarge, http.StatusRequestEntityTooLarge},
+		{ErrJWTExpired, http.StatusUnauthorized},
+		{fmt.Errorf("tenant 3: %w", errQuota), http.StatusTooManyRequests},
+		{NewHttpError(http.StatusConflict, ErrNotFound), http.StatusConflict},
+		{errors.New("anything else"), http.StatusBadRequest},
+	} {
+		if got := ErrorStatus(tc.err); got != tc.want {
+			t.Errorf("ErrorStatus(%v) = %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestRenderErrorWritesMappedStatus(t *testing.T) {
+	rec := httptest.NewRecorder()
+	RenderError(rec, fmt.Errorf("%w: age must be positive", ErrValidation))
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "age must be positive") {
+		t.Fatalf("got %d %q, want 422 with the error", rec.Code, rec.Body)
+	}
+}
+
+func TestDefaultErrorRendererNegotiatesOnAccept(t *testing.T) {
+	captureLog(t)
+	s := NewServiceBuilder().Build().SetPanicRecovery(true)
+	s.RegisterRouteGET("/write", func(w http.ResponseWriter, r *http.Request) {
+		WriteErrorCode(w, http.StatusConflict, errors.New("<b>taken</b>"))
+	})
+	s.RegisterRouteGET("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	for _, tt := range []struct {
+		path   string
+		status int
+	}{
+		{"/write", http.StatusConflict},
+		{"/panic", http.StatusInternalServerError},
+	} {
+		page := get(s, tt.path, http.Header{"Accept": {"text/html,application/xhtml+xml,*/*;q=0.8"}})
+		if page.Code != tt.status || !strings.HasPrefix(page.Header().Get("Content-Type"), "text/html") ||
+			!strings.Contains(page.Body.String(), "<h1>"+fmt.Sprint(tt.status)) {
+			t.Errorf("%s as HTML: %d %s %q", tt.path, page.Code, page.Header().Get("Content-Type"), page.Body)
+		}
+		if strings.Contains(page.Body.String(), "<b>") {
+			t.Errorf("%s as HTML: error text not escaped: %q", tt.path, page.Body)
+		}
+
+		api := get(s, tt.path, http.Header{"Accept": {"application/json"}})
+		if api.Code != tt.status || api.Header().Get("Content-Type") != "application/json" ||
+			!strings.HasPrefix(api.Body.String(), `{"error": `) {
+			t.Errorf("%s as JSON: %d %s %q", tt.path, api.Code, api.Header().Get("Content-Type"), api.Body)
+		}
+	}
+}
+
+func TestErrorRendererHandlesWriteErrorAndPanics(t *testing.T) {
+	captureLog(t)
+	s := NewServiceBuilder().Build().SetPanicRecovery(true)
+	s.SetErrorRenderer(func(w http.ResponseWriter, r *http.Request, statusCode int, err error) {
+		WriteRaw(w, "text/plain", fmt.Sprintf("branded %d: %v", statusCode, err), statusCode)
+	})
+	s.RegisterRouteGET("/write", func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, errors.New("bad input"))
+	})
+	s.RegisterRouteGET("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	if rec := get(s, "/write", nil); rec.Code != http.StatusBadRequest || rec.Body.String() != "branded 400: bad input" {
+		t.Errorf("WriteError: %d %q", rec.Code, rec.Body)
+	}
+	if rec := get(s, "/panic", nil); rec.Code != http.StatusInternalServerError || rec.Body.String() != "branded 500: Internal Server Error" {
+		t.Errorf("panic: %d %q", rec.Code, rec.Body)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"crypto/rand"
 	"fmt"
 	"hash/fnv"
+
+	"github.com/google/uuid"
 )
 
 func Hash(data []byte) (uint64, error) {
@@ -15,16 +17,28 @@ func Hash(data []byte) (uint64, error) {
 	return hasher.Sum64(), nil
 }
 
+// CreateFastUniqueIdentifier returns a random RFC 4122 version 4 UUID in its
+// canonical 36 character form. It panics if the system random source fails,
+// matching uuid.New.
 func CreateFastUniqueIdentifier() string {
 	b := make([]byte, 16)
-	rand.Read(b)
+	if _, err := rand.Read(b); err != nil {
+		panic("service: crypto/rand failed: " + err.Error())
+	}
 
-	// 13th character should be '4'
+	// version 4: high nibble of byte 6 (the 13th hex digit) is 0100
 	b[6] = (b[6] & 0x0F) | 0x40
 
-	// 17th character should be '8', '9', 'A', or 'B'
+	// variant 10xx: high bits of byte 8 (the 17th hex digit is 8, 9, a, or b)
 	b[8] = (b[8] & 0x3F) | 0x80
 
 	return fmt.Sprintf("%02x%02x%02x%02x-%02x%02x-%02x%02x-%02x%02x-%02x%02x%02x%02x%02x%02x",
 		b[0], b[1], b[2], b[3], b[4], b[5], b[6], b[7], b[8], b[9], b[10], b[11], b[12], b[13], b[14], b[15])
 }
+
+// CreateUUIDv7 returns a time-ordered RFC 9562 version 7 UUID. Identifiers
+// created later sort after earlier ones, which makes them suitable for
+// ordering sessions by creation time.
+func CreateUUIDv7() string {
+	return uuid.Must(uuid.NewV7()).String()
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+)
+
+var uuidV4Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestCreateFastUniqueIdentifierIsUUIDv4(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		id := CreateFastUniqueIdentifier()
+		if !uuidV4Pattern.MatchString(id) {
+			t.Fatalf("%q is not a canonical version 4 UUID", id)
+		}
+	}
+}
+
+func TestCreateFastUniqueIdentifierDoesNotCollide(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100000; i++ {
+		id := CreateFastUniqueIdentifier()
+		if seen[id] {
+			t.Fatalf("duplicate id %q after %d ids", id, i)
+		}
+		seen[id] = true
+	}
+}
+
+func TestCreateUUIDv7IsTimeOrdered(t *testing.T) {
+	ids := make([]string, 1000)
+	for i := range ids {
+		ids[i] = CreateUUIDv7()
+		if ids[i][14] != '7' {
+			t.Fatalf("%q is not a version 7 UUID", ids[i])
+		}
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Fatal("version 7 ids created in sequence do not sort in creation order")
+	}
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"net/http"
+	"time"
+)
+
+// serviceHooks are optional callbacks around the request lifecycle, for
+// example to start and end tracing spans without this package depending on
+// a tracing library.
+type serviceHooks struct {
+	requestStart  func(r *http.Request)
+	requestEnd    func(r *http.Request, status int, duration time.Duration)
+	routeResolved func(r *http.Request, route *serviceHttpRouteInfo)
+	writeError    func(r *http.Request, err error)
+}
+
+// OnRequestStart sets a callback run when a request arrives, before it is
+// routed.
+func (s *Service) OnRequestStart(fn func(r *http.Request)) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks.requestStart = fn
+	return s
+}
+
+// OnRequestEnd sets a callback run when a request has been answered, with
+// the status code written and the time taken. For SSE streams it runs once
+// the stream is established rather than when the client disconnects, so the
+// duration reflects the setup and not the lifetime of the connection.
+func (s *Service) OnRequestEnd(fn func(r *http.Request, status int, duration time.Duration)) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks.requestEnd = fn
+	return s
+}
+
+// OnRouteResolved sets a callback run when a request matched a registered
+// route, before its handler runs.
+func (s *Service) OnRouteResolved(fn func(r *http.Request, route *serviceHttpRouteInfo)) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks.routeResolved = fn
+	return s
+}
+
+// OnWriteError sets a callback run when writing a response body fails
+// after the status was sent, such as on a broken pipe. Such failures are
+// logged either way, since handlers often ignore the error.
+func (s *Service) OnWriteError(fn func(r *http.Request, err error)) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks.writeError = fn
+	return s
+}
+
+// streamWriter is implemented by the service's response writers so a
+// streaming handler can report that its response is established.
+type streamWriter interface {
+	streamStarted()
+}
+
+// markStreamStarted ends the request for the OnRequestEnd hook while the
+// handler keeps streaming.
+func markStreamStarted(w http.ResponseWriter) {
+	if sw, ok := w.(streamWriter); ok {
+		sw.streamStarted()
+	}
+}
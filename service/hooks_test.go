@@ -0,0 +1,73 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// hookRecord is what the lifecycle hooks saw for one request.
+type hookRecord struct {
+	started  string
+	resolved string
+	status   int
+	duration time.Duration
+}
+
+// recordHooks installs hooks on s that send what they saw for each request
+// once it has ended.
+func recordHooks(s *Service) chan hookRecord {
+	records := make(chan hookRecord, 4)
+	var current hookRecord
+	s.OnRequestStart(func(r *http.Request) {
+		current = hookRecord{started: r.URL.Path}
+	}).OnRouteResolved(func(r *http.Request, route *serviceHttpRouteInfo) {
+		current.resolved = route.URI
+	}).OnRequestEnd(func(r *http.Request, status int, duration time.Duration) {
+		current.status, current.duration = status, duration
+		records <- current
+	})
+	return records
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	s.RegisterRoutePOST("/items/:id", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+	})
+	records := recordHooks(s)
+
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/items/7", nil))
+	got := <-records
+	if got.started != "/items/7" || got.resolved != "/items/:id" || got.status != http.StatusCreated {
+		t.Errorf("hooks saw %+v", got)
+	}
+	if got.duration < 10*time.Millisecond {
+		t.Errorf("duration = %v, want at least the handler's 10ms", got.duration)
+	}
+
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+	got = <-records
+	if got.started != "/missing" || got.resolved != "" || got.status != http.StatusNotFound {
+		t.Errorf("unmatched request: hooks saw %+v", got)
+	}
+}
+
+func TestRequestEndFiresWhenSseStreamStarts(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	s.RegisterSSE("/events", newTestSseHandler)
+	records := recordHooks(s)
+
+	// the stream stays open until the test ends
+	connectSse(t, base+"/events")
+	select {
+	case got := <-records:
+		if got.resolved != "/events" || got.status != http.StatusOK {
+			t.Errorf("hooks saw %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnRequestEnd did not fire while the stream was open")
+	}
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultIdempotencySize is the number of responses a route keeps for
+// replay before evicting the least recently used.
+const DefaultIdempotencySize = 1024
+
+var (
+	// ErrIdempotencyKeyReused is answered with 422 when a key is sent again
+	// with a different body.
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused with a different request body")
+	// ErrIdempotencyInProgress is answered with 409 when a key is sent
+	// again while its first request is still being handled.
+	ErrIdempotencyInProgress = errors.New("request with this idempotency key is in progress")
+)
+
+// idempotencyStore remembers the responses of one route by Idempotency-Key.
+type idempotencyStore struct {
+	responses *routeCache
+	mu        sync.Mutex
+	inFlight  map[string]bool
+}
+
+// SetIdempotency makes the route honour the Idempotency-Key header on
+// requests other than GET and HEAD. The first response for a key is kept
+// for ttl and replayed, with Idempotent-Replayed: true, to any retry with
+// the same key, so the handler runs once. A retry whose JSON or form body
+// differs from the first is refused with 422, and one arriving while the
+// first is still running with 409. Server errors are not kept, so a retry
+// after a 5xx runs the handler again. Keys are scoped to the request's
+// Authorization header, so one caller cannot replay another's response;
+// callers without one share keys and must make them unique, for example
+// with a UUID. Set-Cookie is not replayed. Zero disables it.
+func (s *serviceHttpRouteInfo) SetIdempotency(ttl time.Duration) *serviceHttpRouteInfo {
+	return s.SetIdempotencySize(ttl, DefaultIdempotencySize)
+}
+
+// SetIdempotencySize is SetIdempotency with the number of kept responses
+// bounded by size instead of DefaultIdempotencySize.
+func (s *serviceHttpRouteInfo) SetIdempotencySize(ttl time.Duration, size int) *serviceHttpRouteInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ttl <= 0 || size <= 0 {
+		s.idempotency = nil
+	} else {
+		s.idempotency = &idempotencyStore{
+			responses: newRouteCache(ttl, size),
+			inFlight:  make(map[string]bool),
+		}
+	}
+	return s
+}
+
+// idempotencyBodyHash identifies the body of r: the raw JSON body, or the
+// encoded form. Other bodies are not read and hash as empty.
+func idempotencyBodyHash(r *http.Request) string {
+	body := httpRequestBody(r)
+	if body == nil && r.PostForm != nil {
+		body = []byte(r.PostForm.Encode())
+	}
+	sum, err := Hash(body)
+	if err != nil {
+		return ""
+	}
+	return strconv.FormatUint(sum, 16)
+}
+
+// idempotencyScope returns a hash of the Authorization header, or "" when
+// there is none, to prefix the key with.
+func idempotencyScope(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(auth))
+	return hex.EncodeToString(sum[:]) + ":"
+}
+
+// begin marks key as in flight unless it already is.
+func (st *idempotencyStore) begin(key string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.inFlight[key] {
+		return false
+	}
+	st.inFlight[key] = true
+	return true
+}
+
+func (st *idempotencyStore) end(key string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.inFlight, key)
+}
+
+// wrap replays the kept response for a repeated Idempotency-Key and keeps
+// the response of a new one.
+func (st *idempotencyStore) wrap(fn ServiceHandleFunc) ServiceHandleFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead {
+			fn(w, r)
+			return
+		}
+
+		key = idempotencyScope(r) + key
+		bodyHash := idempotencyBodyHash(r)
+		if entry, ok := st.responses.get(key); ok {
+			if entry.bodyHash != bodyHash {
+				WriteErrorCode(w, http.StatusUnprocessableEntity, ErrIdempotencyKeyReused)
+				return
+			}
+			for k, v := range entry.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(entry.status)
+			w.Write(entry.body)
+			return
+		}
+
+		if !st.begin(key) {
+			WriteErrorCode(w, http.StatusConflict, ErrIdempotencyInProgress)
+			return
+		}
+		defer st.end(key)
+
+		rec := &cacheRecorder{ResponseWriter: w}
+		fn(rec, r)
+		if rec.status == 0 {
+			// the handler wrote nothing, net/http answers 200
+			rec.status = http.StatusOK
+		}
+		if rec.status < http.StatusInternalServerError && !rec.failed {
+			st.responses.put(&routeCacheEntry{
+				key:      key,
+				expires:  time.Now().Add(st.responses.ttl),
+				status:   rec.status,
+				header:   storedHeader(w.Header()),
+				body:     rec.body.Bytes(),
+				bodyHash: bodyHash,
+			})
+		}
+	}
+}
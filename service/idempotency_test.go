@@ -0,0 +1,82 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// idempotentRoute registers a POST route that counts its runs and sets a
+// cookie, kept for replay for a minute.
+func idempotentRoute(s *Service) *int {
+	runs := 0
+	s.RegisterRoutePOST("/orders", func(w http.ResponseWriter, r *http.Request) {
+		runs++
+		WriteCookie(w, http.Cookie{Name: "session", Value: "issued"})
+		WriteRaw(w, "application/json", fmt.Sprintf(`{"order":%d}`, runs), http.StatusCreated)
+	}).SetIdempotency(time.Minute)
+	return &runs
+}
+
+func postIdempotent(s *Service, key, auth, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Idempotency-Key", key)
+	if auth != "" {
+		r.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestIdempotencyRunsHandlerOnce(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	runs := idempotentRoute(s)
+
+	first := postIdempotent(s, "k1", "Bearer alice", `{"item": 1}`)
+	retry := postIdempotent(s, "k1", "Bearer alice", `{"item": 1}`)
+	if *runs != 1 {
+		t.Fatalf("handler ran %d times, want 1", *runs)
+	}
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Fatalf("replay = %d %q, want %d %q", retry.Code, retry.Body, first.Code, first.Body)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("replay lacks Idempotent-Replayed")
+	}
+	if first.Header().Get("Set-Cookie") == "" {
+		t.Error("first response lost its cookie")
+	}
+	if cookie := retry.Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("replay carried Set-Cookie %q", cookie)
+	}
+
+	if rec := postIdempotent(s, "k1", "Bearer alice", `{"item": 2}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key with another body = %d, want 422", rec.Code)
+	}
+}
+
+func TestIdempotencyKeysAreScopedToAuthorization(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	runs := idempotentRoute(s)
+
+	alice := postIdempotent(s, "shared", "Bearer alice", `{"item": 1}`)
+	bob := postIdempotent(s, "shared", "Bearer bob", `{"item": 1}`)
+	anonymous := postIdempotent(s, "shared", "", `{"item": 1}`)
+	if *runs != 3 {
+		t.Fatalf("handler ran %d times, want once per caller", *runs)
+	}
+	for i, rec := range []*httptest.ResponseRecorder{alice, bob, anonymous} {
+		if want := fmt.Sprintf(`{"order":%d}`, i+1); rec.Body.String() != want || rec.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("caller %d got %q replayed %q, want a fresh %s", i, rec.Body, rec.Header().Get("Idempotent-Replayed"), want)
+		}
+	}
+
+	if rec := postIdempotent(s, "shared", "Bearer bob", `{"item": 1}`); rec.Body.String() != `{"order":2}` {
+		t.Errorf("bob's retry = %q, want his own response", rec.Body)
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 )
 
@@ -17,16 +19,176 @@ func getEnv(key string) string {
 	return value
 }
 
+// ErrMissingToken is returned by Invoke when no token was configured with
+// SetToken and MOONLIGHT_TOKEN is not set.
+var ErrMissingToken = errors.New("MOONLIGHT_TOKEN is not set")
+
+// Token is the token sent with every Invoke call. It starts out as
+// MOONLIGHT_TOKEN, empty when that is unset, and follows SetToken.
+//
+// Deprecated: use SetToken. A value assigned to Token is still used by the
+// next call.
 var Token = getEnv("MOONLIGHT_TOKEN")
 
+var (
+	tokenMu sync.Mutex
+	token   string
+)
+
+// SetToken sets the token sent with every Invoke call, taking precedence
+// over the MOONLIGHT_TOKEN environment variable.
+func SetToken(t string) {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+	token = t
+	Token = t
+}
+
+// resolveToken returns the configured token, reading MOONLIGHT_TOKEN the
+// first time a call needs it.
+func resolveToken() (string, error) {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+	if Token != token {
+		// assigned directly, or taken from the environment at init
+		token = Token
+	}
+	if token == "" {
+		token = getEnv("MOONLIGHT_TOKEN")
+		Token = token
+	}
+	if token == "" {
+		return "", ErrMissingToken
+	}
+	return token, nil
+}
+
+// Invoker performs an RPC call and returns the raw response body. It lets
+// code that makes calls, such as service registration, be tested with a fake.
+type Invoker interface {
+	Invoke(ctx context.Context, call string, parameters map[string]interface{}) ([]byte, error)
+}
+
+// HttpInvoker is the default Invoker, posting JSON to BaseURL + call.
+// When Logger is set every call is logged with its duration and status.
+// When HeaderInjector is set its headers are added to every request.
+type HttpInvoker struct {
+	BaseURL        string
+	Client         *http.Client
+	Logger         Logger
+	HeaderInjector HeaderInjector
+}
+
+// DefaultInvoker is used by Invoke, InvokeTimeout, and InvokeCtx.
+var DefaultInvoker Invoker = &HttpInvoker{
+	BaseURL: "https://io.moonlightcompanies.com/",
+	Client:  &http.Client{},
+}
+
 func Invoke[T any](Call string, Parameters map[string]interface{}) (results T, body []byte, err error) {
 	return InvokeTimeout[T](Call, Parameters, 30*time.Second)
 }
 
 func InvokeTimeout[T any](Call string, Parameters map[string]interface{}, timeout time.Duration) (results T, body []byte, err error) {
-	if Token != "" {
-		Parameters["Token"] = Token
+	// Create a context with a timeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return InvokeCtx[T](ctx, Call, Parameters)
+}
+
+// InvokeCtx is like Invoke but the call is bound to ctx, so it is aborted
+// when ctx is cancelled or its deadline passes.
+func InvokeCtx[T any](ctx context.Context, Call string, Parameters map[string]interface{}) (results T, body []byte, err error) {
+	return InvokeWith[T](ctx, DefaultInvoker, Call, Parameters)
+}
+
+// InvokeError is returned when a call completes with a non-200 status. Body
+// holds the response, which usually carries the server's error message.
+type InvokeError struct {
+	Call       string
+	StatusCode int
+	Body       []byte
+}
+
+// maxInvokeErrorBody bounds how much of the body is included in Error.
+const maxInvokeErrorBody = 512
+
+func (e *InvokeError) Error() string {
+	body := e.Body
+	suffix := ""
+	if len(body) > maxInvokeErrorBody {
+		body = body[:maxInvokeErrorBody]
+		suffix = "..."
+	}
+	return fmt.Sprintf("received non-200 status code: %d from: %s: %s%s", e.StatusCode, e.Call, bytes.TrimSpace(body), suffix)
+}
+
+// RawInvoker is implemented by invokers that can return the undecoded
+// response, including its status and headers. HttpInvoker implements it.
+type RawInvoker interface {
+	InvokeRaw(ctx context.Context, call string, parameters map[string]interface{}) ([]byte, int, http.Header, error)
+}
+
+// InvokeRaw performs the call with the default 30 second timeout and returns
+// the raw body, status code, and headers. Unlike Invoke it does not treat a
+// non-200 status as an error or decode the body, so callers can handle any
+// content type or inspect error envelopes.
+func InvokeRaw(Call string, Parameters map[string]interface{}) ([]byte, int, http.Header, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return InvokeRawCtx(ctx, Call, Parameters)
+}
+
+// InvokeRawCtx is InvokeRaw bound to ctx. If DefaultInvoker does not
+// implement RawInvoker, a successful call reports status 200 and no headers.
+func InvokeRawCtx(ctx context.Context, Call string, Parameters map[string]interface{}) ([]byte, int, http.Header, error) {
+	if raw, ok := DefaultInvoker.(RawInvoker); ok {
+		return raw.InvokeRaw(ctx, Call, Parameters)
+	}
+
+	body, err := DefaultInvoker.Invoke(ctx, Call, Parameters)
+	if err != nil {
+		return nil, 0, nil, err
 	}
+	return body, http.StatusOK, nil, nil
+}
+
+// InvokeWith performs the call through the given invoker and decodes the JSON
+// response into T.
+func InvokeWith[T any](ctx context.Context, invoker Invoker, Call string, Parameters map[string]interface{}) (results T, body []byte, err error) {
+	body, err = invoker.Invoke(ctx, Call, Parameters)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(body, &results)
+
+	return
+}
+
+func (i *HttpInvoker) Invoke(ctx context.Context, Call string, Parameters map[string]interface{}) (body []byte, err error) {
+	body, statusCode, _, err := i.InvokeRaw(ctx, Call, Parameters)
+	if err != nil {
+		return
+	}
+
+	if statusCode != 200 {
+		return nil, &InvokeError{Call: Call, StatusCode: statusCode, Body: body}
+	}
+
+	return body, nil
+}
+
+// InvokeRaw performs the call and returns the response body, status code,
+// and headers as received, without checking the status or decoding.
+func (i *HttpInvoker) InvokeRaw(ctx context.Context, Call string, Parameters map[string]interface{}) (body []byte, statusCode int, header http.Header, err error) {
+	token, err := resolveToken()
+	if err != nil {
+		return
+	}
+	Parameters["Token"] = token
 
 	j, err := json.Marshal(Parameters)
 	if err != nil {
@@ -35,43 +197,37 @@ func InvokeTimeout[T any](Call string, Parameters map[string]interface{}, timeou
 	u := bytes.NewReader(j)
 
 	method := "POST"
-	request, err := http.NewRequest(method, "https://io.moonlightcompanies.com/"+Call, u)
+	request, err := http.NewRequestWithContext(ctx, method, i.BaseURL+Call, u)
 	if err != nil {
 		return
 	}
 
+	if i.HeaderInjector != nil {
+		for k, values := range i.HeaderInjector(ctx) {
+			for _, v := range values {
+				request.Header.Add(k, v)
+			}
+		}
+	}
 	request.Header.Set("Content-Type", "application/json; charset=UTF-8")
 
-	// Create a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-
-	// Assign the context to the HTTP request
-	request = request.WithContext(ctx)
-
 	//log.Println("Waiting INVOKE", Call, Parameters)
 	ta := time.Now()
-	client := &http.Client{}
+	client := i.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
 	response, err := client.Do(request)
 	if err != nil {
 		return
 	}
 	defer response.Body.Close()
-	if time.Since(ta) > 1*time.Second {
+	if i.Logger != nil {
+		logDebugw(i.Logger, "invoke", "call", Call, "status", response.StatusCode, "duration", time.Since(ta))
+	} else if time.Since(ta) > 1*time.Second {
 		log.Println("Waiting INVOKE DONE", Call, time.Since(ta))
 	}
 
-	if response.StatusCode != 200 {
-		err = fmt.Errorf("received non-200 status code: %d from: %s", response.StatusCode, Call)
-		return
-	}
-
 	body, err = io.ReadAll(response.Body)
-	if err != nil {
-		return
-	}
-
-	err = json.Unmarshal(body, &results)
-
-	return
+	return body, response.StatusCode, response.Header, err
 }
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// restoreToken puts the package token back when the test ends.
+func restoreToken(t *testing.T) {
+	saved := Token
+	t.Cleanup(func() { SetToken(saved) })
+}
+
+// tokenServer answers every call with {} and reports the token it was sent.
+func tokenServer(t *testing.T) (*HttpInvoker, <-chan string) {
+	tokens := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]any
+		json.NewDecoder(r.Body).Decode(&params)
+		token, _ := params["Token"].(string)
+		tokens <- token
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(srv.Close)
+	return &HttpInvoker{BaseURL: srv.URL + "/", Client: srv.Client()}, tokens
+}
+
+func TestSetTokenIsSent(t *testing.T) {
+	restoreToken(t)
+	invoker, tokens := tokenServer(t)
+
+	SetToken("set-token")
+	if Token != "set-token" {
+		t.Fatalf("Token = %q after SetToken", Token)
+	}
+	if _, err := invoker.Invoke(context.Background(), "call", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-tokens; got != "set-token" {
+		t.Fatalf("sent token %q, want set-token", got)
+	}
+}
+
+func TestAssignedTokenIsSent(t *testing.T) {
+	restoreToken(t)
+	invoker, tokens := tokenServer(t)
+
+	SetToken("old")
+	Token = "assigned"
+	if _, err := invoker.Invoke(context.Background(), "call", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-tokens; got != "assigned" {
+		t.Fatalf("sent token %q, want assigned", got)
+	}
+}
+
+func TestTokenFallsBackToEnvironment(t *testing.T) {
+	restoreToken(t)
+	invoker, tokens := tokenServer(t)
+
+	SetToken("")
+	t.Setenv("MOONLIGHT_TOKEN", "from-env")
+	if _, err := invoker.Invoke(context.Background(), "call", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-tokens; got != "from-env" {
+		t.Fatalf("sent token %q, want from-env", got)
+	}
+}
+
+func TestMissingTokenIsAnError(t *testing.T) {
+	restoreToken(t)
+	invoker, _ := tokenServer(t)
+
+	SetToken("")
+	t.Setenv("MOONLIGHT_TOKEN", "")
+	_, err := invoker.Invoke(context.Background(), "call", map[string]interface{}{})
+	if !errors.Is(err, ErrMissingToken) {
+		t.Fatalf("err = %v, want ErrMissingToken", err)
+	}
+}
+
+func TestInvokeUsesDefaultInvoker(t *testing.T) {
+	saved := DefaultInvoker
+	t.Cleanup(func() { DefaultInvoker = saved })
+	invoker := &fakeInvoker{calls: make(chan string, 1)}
+	DefaultInvoker = invoker
+
+	results, _, err := Invoke[map[string]any]("some/call", map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results == nil {
+		t.Fatal("results were not decoded")
+	}
+	if call := <-invoker.calls; call != "some/call" {
+		t.Fatalf("invoked %q, want some/call", call)
+	}
+}
+
+// replyServer answers every call with status, contentType, and body.
+func replyServer(t *testing.T, status int, contentType, body string) *HttpInvoker {
+	restoreToken(t)
+	SetToken("test-token")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return &HttpInvoker{BaseURL: srv.URL + "/", Client: srv.Client()}
+}
+
+func TestInvokeRawReturnsNonJSONBody(t *testing.T) {
+	invoker := replyServer(t, http.StatusAccepted, "text/plain", "plain text result")
+
+	body, status, header, err := invoker.InvokeRaw(context.Background(), "call", map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "plain text result" || status != http.StatusAccepted || header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("got %q %d %v", body, status, header)
+	}
+}
+
+func TestInvokeRawCtxDoesNotTreatErrorsAsFailures(t *testing.T) {
+	saved := DefaultInvoker
+	t.Cleanup(func() { DefaultInvoker = saved })
+	DefaultInvoker = replyServer(t, http.StatusInternalServerError, "application/json", `{"error":"boom"}`)
+
+	body, status, _, err := InvokeRawCtx(context.Background(), "call", map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusInternalServerError || string(body) != `{"error":"boom"}` {
+		t.Fatalf("got %d %q", status, body)
+	}
+}
+
+func TestInvokeErrorCarriesBody(t *testing.T) {
+	invoker := replyServer(t, http.StatusBadRequest, "application/json", `{"error":"bad input"}`)
+
+	_, err := invoker.Invoke(context.Background(), "some/call", map[string]interface{}{})
+	var invokeErr *InvokeError
+	if !errors.As(err, &invokeErr) {
+		t.Fatalf("err = %v, want an *InvokeError", err)
+	}
+	if invokeErr.StatusCode != http.StatusBadRequest || invokeErr.Call != "some/call" || string(invokeErr.Body) != `{"error":"bad input"}` {
+		t.Fatalf("InvokeError = %+v", invokeErr)
+	}
+	if !strings.Contains(err.Error(), "bad input") {
+		t.Fatalf("Error() = %q, want the body", err.Error())
+	}
+}
+
+func TestInvokeErrorTruncatesLongBody(t *testing.T) {
+	err := &InvokeError{Call: "call", StatusCode: 500, Body: []byte(strings.Repeat("x", 2*maxInvokeErrorBody))}
+
+	msg := err.Error()
+	if !strings.HasSuffix(msg, "...") || strings.Count(msg, "x") != maxInvokeErrorBody {
+		t.Fatalf("Error() = %q, want the body truncated to %d bytes", msg, maxInvokeErrorBody)
+	}
+}
+
+func TestSetTokenTakesPrecedenceOverEnvironment(t *testing.T) {
+	restoreToken(t)
+	invoker, tokens := tokenServer(t)
+
+	t.Setenv("MOONLIGHT_TOKEN", "from-env")
+	SetToken("explicit")
+	if _, err := invoker.Invoke(context.Background(), "call", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-tokens; got != "explicit" {
+		t.Fatalf("sent token %q, want explicit", got)
+	}
+}
+
+func TestMissingTokenMakesNoRequest(t *testing.T) {
+	restoreToken(t)
+	invoker, tokens := tokenServer(t)
+
+	// the package loaded without MOONLIGHT_TOKEN; only the call fails
+	SetToken("")
+	t.Setenv("MOONLIGHT_TOKEN", "")
+	if _, err := invoker.Invoke(context.Background(), "call", map[string]interface{}{}); !errors.Is(err, ErrMissingToken) {
+		t.Fatalf("err = %v, want ErrMissingToken", err)
+	}
+	select {
+	case token := <-tokens:
+		t.Fatalf("request sent with token %q", token)
+	default:
+	}
+
+	// setting the environment later is picked up by the next call
+	t.Setenv("MOONLIGHT_TOKEN", "late")
+	if _, err := invoker.Invoke(context.Background(), "call", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-tokens; got != "late" {
+		t.Fatalf("sent token %q, want late", got)
+	}
+}
@@ -0,0 +1,366 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const parameter_jwt_claims = parameterKey("jwt_claims")
+
+// jwtLeeway tolerates clock skew between the issuer and this service when
+// checking exp and nbf.
+const jwtLeeway = 30 * time.Second
+
+var (
+	ErrJWTMissing   = errors.New("missing bearer token")
+	ErrJWTMalformed = errors.New("malformed token")
+	ErrJWTSignature = errors.New("invalid token signature")
+	ErrJWTExpired   = errors.New("token expired")
+	ErrJWTNotYet    = errors.New("token not valid yet")
+	ErrJWTNoExpiry  = errors.New("token has no expiry")
+)
+
+// JWTOptions tighten the checks of VerifyJWTWithOptions and
+// JWTMiddlewareWithOptions. The zero value matches VerifyJWT.
+type JWTOptions struct {
+	// RequireExp rejects tokens without an exp claim, which would
+	// otherwise be valid forever.
+	RequireExp bool
+}
+
+// JWTHeader is the decoded header of a token, used to pick its key.
+type JWTHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// JWTKeyFunc returns the key that verifies a token with the given header:
+// a []byte secret for HS256/384/512 or an *rsa.PublicKey for RS256/384/512.
+type JWTKeyFunc func(header JWTHeader) (interface{}, error)
+
+// JWTClaims are the claims of a verified token.
+type JWTClaims map[string]interface{}
+
+// Subject returns the sub claim.
+func (c JWTClaims) Subject() string {
+	sub, _ := c["sub"].(string)
+	return sub
+}
+
+// Scopes returns the space separated scope claim, or the scp array used by
+// some issuers.
+func (c JWTClaims) Scopes() []string {
+	if scope, ok := c["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+	var scopes []string
+	if scp, ok := c["scp"].([]interface{}); ok {
+		for _, v := range scp {
+			if s, ok := v.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+	return scopes
+}
+
+// HasScope reports whether the token grants scope.
+func (c JWTClaims) HasScope(scope string) bool {
+	for _, s := range c.Scopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpiresAt returns the exp claim, if present.
+func (c JWTClaims) ExpiresAt() (time.Time, bool) {
+	return c.time("exp")
+}
+
+func (c JWTClaims) time(name string) (time.Time, bool) {
+	seconds, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// HttpClaims returns the claims stored by JWTMiddleware.
+func HttpClaims(r *http.Request) (JWTClaims, bool) {
+	claims, ok := r.Context().Value(parameter_jwt_claims).(JWTClaims)
+	return claims, ok
+}
+
+// JWTMiddleware verifies the bearer token of each request with keyfunc,
+// checks exp and nbf, and stores the claims for HttpClaims. Requests with a
+// missing, invalid, or expired token receive 401. Add it with Use on the
+// routes that require authentication.
+func JWTMiddleware(keyfunc JWTKeyFunc) Middleware {
+	return JWTMiddlewareWithOptions(keyfunc, JWTOptions{})
+}
+
+// JWTMiddlewareWithOptions is JWTMiddleware with the checks of options.
+func JWTMiddlewareWithOptions(keyfunc JWTKeyFunc, options JWTOptions) Middleware {
+	return func(next ServiceHandleFunc) ServiceHandleFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" {
+				writeUnauthorized(w, ErrJWTMissing)
+				return
+			}
+
+			claims, err := VerifyJWTWithOptions(token, keyfunc, time.Now(), options)
+			if err != nil {
+				writeUnauthorized(w, err)
+				return
+			}
+
+			next(w, r.WithContext(context.WithValue(r.Context(), parameter_jwt_claims, claims)))
+		}
+	}
+}
+
+func writeUnauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	WriteErrorCode(w, http.StatusUnauthorized, err)
+}
+
+// VerifyJWT checks token's signature with the key from keyfunc and its
+// exp and nbf claims against now, and returns its claims. A token without
+// exp does not expire; use VerifyJWTWithOptions to require one.
+func VerifyJWT(token string, keyfunc JWTKeyFunc, now time.Time) (JWTClaims, error) {
+	return VerifyJWTWithOptions(token, keyfunc, now, JWTOptions{})
+}
+
+// VerifyJWTWithOptions is VerifyJWT with the checks of options.
+func VerifyJWTWithOptions(token string, keyfunc JWTKeyFunc, now time.Time, options JWTOptions) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTMalformed
+	}
+
+	var header JWTHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+
+	key, err := keyfunc(header)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims JWTClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	exp, hasExp := claims.time("exp")
+	if !hasExp && options.RequireExp {
+		return nil, ErrJWTNoExpiry
+	}
+	if hasExp && now.After(exp.Add(jwtLeeway)) {
+		return nil, ErrJWTExpired
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(jwtLeeway).Before(nbf) {
+		return nil, ErrJWTNotYet
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return ErrJWTMalformed
+	}
+	if err := json.Unmarshal(decoded, v); err != nil {
+		return ErrJWTMalformed
+	}
+	return nil
+}
+
+// verifyJWTSignature checks signature over signed for the HS and RS
+// algorithm families. Anything else, including "none", is rejected.
+func verifyJWTSignature(alg string, key interface{}, signed string, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported token algorithm: %q", alg)
+	}
+
+	var newHash func() hash.Hash
+	var cryptoHash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		newHash, cryptoHash = sha256.New, crypto.SHA256
+	case "384":
+		newHash, cryptoHash = sha512.New384, crypto.SHA384
+	case "512":
+		newHash, cryptoHash = sha512.New, crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported token algorithm: %q", alg)
+	}
+
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return fmt.Errorf("key for %s must be []byte", alg)
+		}
+		mac := hmac.New(newHash, secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrJWTSignature
+		}
+		return nil
+	case "RS":
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key for %s must be *rsa.PublicKey", alg)
+		}
+		h := newHash()
+		h.Write([]byte(signed))
+		if rsa.VerifyPKCS1v15(publicKey, cryptoHash, h.Sum(nil), signature) != nil {
+			return ErrJWTSignature
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported token algorithm: %q", alg)
+}
+
+// HMACKeyFunc verifies HS256/384/512 tokens with a shared secret.
+func HMACKeyFunc(secret []byte) JWTKeyFunc {
+	return func(header JWTHeader) (interface{}, error) {
+		if !strings.HasPrefix(header.Alg, "HS") {
+			return nil, fmt.Errorf("unexpected token algorithm: %q", header.Alg)
+		}
+		return secret, nil
+	}
+}
+
+// jwksMinRefresh limits how often an unknown kid triggers a refetch.
+const jwksMinRefresh = time.Minute
+
+// JWKSKeyFunc verifies RS256/384/512 tokens with the RSA keys published at
+// a JWKS url, matched by kid. Keys are fetched on first use and refetched
+// when a token names a kid that is not known yet, such as after rotation,
+// at most once per jwksMinRefresh after a successful fetch. Concurrent
+// requests share one fetch, and tokens with known kids are verified while
+// it is in flight.
+func JWKSKeyFunc(url string, client *http.Client) JWTKeyFunc {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	var (
+		mu       sync.Mutex
+		keys     map[string]*rsa.PublicKey
+		fetched  time.Time
+		fetching chan struct{}
+		fetchErr error
+	)
+	return func(header JWTHeader) (interface{}, error) {
+		if !strings.HasPrefix(header.Alg, "RS") {
+			return nil, fmt.Errorf("unexpected token algorithm: %q", header.Alg)
+		}
+
+		mu.Lock()
+		if key, ok := keys[header.Kid]; ok {
+			mu.Unlock()
+			return key, nil
+		}
+		if fetching == nil {
+			if time.Since(fetched) < jwksMinRefresh {
+				mu.Unlock()
+				return nil, fmt.Errorf("unknown key id: %q", header.Kid)
+			}
+
+			done := make(chan struct{})
+			fetching = done
+			go func() {
+				refreshed, err := fetchJWKS(client, url)
+				mu.Lock()
+				defer mu.Unlock()
+				fetchErr = err
+				if err == nil {
+					keys = refreshed
+					fetched = time.Now()
+				}
+				fetching = nil
+				close(done)
+			}()
+		}
+		wait := fetching
+		mu.Unlock()
+
+		<-wait
+		mu.Lock()
+		defer mu.Unlock()
+		if key, ok := keys[header.Kid]; ok {
+			return key, nil
+		}
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+		return nil, fmt.Errorf("unknown key id: %q", header.Kid)
+	}
+}
+
+func fetchJWKS(client *http.Client, url string) (map[string]*rsa.PublicKey, error) {
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching jwks: status %d", response.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("fetching jwks: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
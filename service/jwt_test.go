@@ -0,0 +1,193 @@
+package service
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// signJWT returns a token for claims signed with key, a []byte secret for
+// HS256 or an *rsa.PrivateKey for RS256.
+func signJWT(t *testing.T, header JWTHeader, claims JWTClaims, key interface{}) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyJWTRequireExp(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	noExp := signJWT(t, JWTHeader{Alg: "HS256"}, JWTClaims{"sub": "u1"}, secret)
+	expired := signJWT(t, JWTHeader{Alg: "HS256"}, JWTClaims{"exp": now.Add(-time.Hour).Unix()}, secret)
+	valid := signJWT(t, JWTHeader{Alg: "HS256"}, JWTClaims{"exp": now.Add(time.Hour).Unix()}, secret)
+
+	if _, err := VerifyJWT(noExp, HMACKeyFunc(secret), now); err != nil {
+		t.Errorf("VerifyJWT without exp = %v, want it accepted by default", err)
+	}
+	strict := JWTOptions{RequireExp: true}
+	if _, err := VerifyJWTWithOptions(noExp, HMACKeyFunc(secret), now, strict); !errors.Is(err, ErrJWTNoExpiry) {
+		t.Errorf("RequireExp without exp = %v, want ErrJWTNoExpiry", err)
+	}
+	if _, err := VerifyJWTWithOptions(expired, HMACKeyFunc(secret), now, strict); !errors.Is(err, ErrJWTExpired) {
+		t.Errorf("RequireExp expired = %v, want ErrJWTExpired", err)
+	}
+	if _, err := VerifyJWTWithOptions(valid, HMACKeyFunc(secret), now, strict); err != nil {
+		t.Errorf("RequireExp valid = %v", err)
+	}
+}
+
+func TestJWTMiddlewareWithOptions(t *testing.T) {
+	secret := []byte("secret")
+	s := NewServiceBuilder().Build()
+	s.RegisterRouteGET("/me", func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := HttpClaims(r)
+		WriteT(w, claims.Subject())
+	}).Use(JWTMiddlewareWithOptions(HMACKeyFunc(secret), JWTOptions{RequireExp: true}))
+
+	for _, tt := range []struct {
+		claims JWTClaims
+		want   int
+	}{
+		{JWTClaims{"sub": "u1"}, http.StatusUnauthorized},
+		{JWTClaims{"sub": "u1", "exp": time.Now().Add(time.Hour).Unix()}, http.StatusOK},
+	} {
+		r := httptest.NewRequest("GET", "/me", nil)
+		r.Header.Set("Authorization", "Bearer "+signJWT(t, JWTHeader{Alg: "HS256"}, tt.claims, secret))
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, r)
+		if rec.Code != tt.want {
+			t.Errorf("claims %v: status = %d, want %d", tt.claims, rec.Code, tt.want)
+		}
+	}
+}
+
+// jwksServer publishes key under kid, failing while fail is set, and
+// counts its requests. Each request waits for release when it is non-nil.
+type jwksServer struct {
+	*httptest.Server
+	fail     atomic.Bool
+	requests atomic.Int32
+	release  chan struct{}
+}
+
+func newJWKSServer(t *testing.T, kid string, key *rsa.PublicKey) *jwksServer {
+	js := &jwksServer{}
+	js.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		js.requests.Add(1)
+		if js.release != nil {
+			<-js.release
+		}
+		if js.fail.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(js.Close)
+	return js
+}
+
+func TestJWKSKeyFuncRetriesAfterFailedFetch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := newJWKSServer(t, "k1", &key.PublicKey)
+	keyfunc := JWKSKeyFunc(js.URL, js.Client())
+	token := signJWT(t, JWTHeader{Alg: "RS256", Kid: "k1"}, JWTClaims{"sub": "u1"}, key)
+
+	js.fail.Store(true)
+	if _, err := VerifyJWT(token, keyfunc, time.Now()); err == nil {
+		t.Fatal("verified with the JWKS endpoint down")
+	}
+	js.fail.Store(false)
+	claims, err := VerifyJWT(token, keyfunc, time.Now())
+	if err != nil {
+		t.Fatalf("after the endpoint recovered: %v", err)
+	}
+	if claims.Subject() != "u1" {
+		t.Errorf("sub = %q", claims.Subject())
+	}
+
+	// an unknown kid right after a successful fetch does not refetch
+	other := signJWT(t, JWTHeader{Alg: "RS256", Kid: "k2"}, JWTClaims{}, key)
+	if _, err := VerifyJWT(other, keyfunc, time.Now()); err == nil {
+		t.Error("verified a token with an unknown kid")
+	}
+	if got := js.requests.Load(); got != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", got)
+	}
+}
+
+func TestJWKSKeyFuncSharesOneFetch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := newJWKSServer(t, "k1", &key.PublicKey)
+	js.release = make(chan struct{})
+	keyfunc := JWKSKeyFunc(js.URL, js.Client())
+	token := signJWT(t, JWTHeader{Alg: "RS256", Kid: "k1"}, JWTClaims{}, key)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := VerifyJWT(token, keyfunc, time.Now())
+			errs <- err
+		}()
+	}
+	waitFor(t, "the JWKS fetch", func() bool { return js.requests.Load() > 0 })
+	close(js.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if got := js.requests.Load(); got != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", got)
+	}
+}
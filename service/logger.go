@@ -0,0 +1,70 @@
+package service
+
+import "github.com/Moonlight-Companies/gologger/logger"
+
+// Logger is the logging interface used by Service, its routes, and SseServer.
+// The default implementation is backed by gologger.
+type Logger interface {
+	Debugln(args ...interface{})
+	Infoln(args ...interface{})
+	Errorln(args ...interface{})
+	SetLevel(level logger.LogLevel)
+}
+
+// LoggerFactory creates a named logger, e.g. one per route or SSE endpoint.
+type LoggerFactory func(name string) Logger
+
+// gologger adapts *logger.Logger to Logger.
+type gologger struct {
+	*logger.Logger
+}
+
+func (l gologger) SetLevel(level logger.LogLevel) {
+	l.Logger.SetLevel(level)
+}
+
+// NewGologger returns the default gologger-backed Logger with the given name.
+func NewGologger(name string) Logger {
+	return gologger{logger.NewLogger(name)}
+}
+
+func defaultLoggerFactory(name string) Logger {
+	return NewGologger(name)
+}
+
+// StructuredLogger is implemented by loggers that accept key/value pairs in
+// addition to the line-oriented Logger methods.
+type StructuredLogger interface {
+	Logger
+	Debugw(msg string, kv ...interface{})
+	Infow(msg string, kv ...interface{})
+	Errorw(msg string, kv ...interface{})
+}
+
+// logDebugw logs msg with key/value pairs, falling back to Debugln for
+// loggers that are not structured.
+func logDebugw(l Logger, msg string, kv ...interface{}) {
+	if sl, ok := l.(StructuredLogger); ok {
+		sl.Debugw(msg, kv...)
+		return
+	}
+	l.Debugln(append([]interface{}{msg}, kv...)...)
+}
+
+// logInfow is the Info counterpart of logDebugw.
+func logInfow(l Logger, msg string, kv ...interface{}) {
+	if sl, ok := l.(StructuredLogger); ok {
+		sl.Infow(msg, kv...)
+		return
+	}
+	l.Infoln(append([]interface{}{msg}, kv...)...)
+}
+
+// logErrorw is the Error counterpart of logDebugw.
+func logErrorw(l Logger, msg string, kv ...interface{}) {
+	if sl, ok := l.(StructuredLogger); ok {
+		sl.Errorw(msg, kv...)
+		return
+	}
+	l.Errorln(append([]interface{}{msg}, kv...)...)
+}
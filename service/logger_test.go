@@ -0,0 +1,78 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Moonlight-Companies/gologger/logger"
+)
+
+// recordingLogger keeps every line logged through it.
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) record(args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+}
+
+func (l *recordingLogger) Debugln(args ...interface{})    { l.record(args...) }
+func (l *recordingLogger) Infoln(args ...interface{})     { l.record(args...) }
+func (l *recordingLogger) Errorln(args ...interface{})    { l.record(args...) }
+func (l *recordingLogger) SetLevel(level logger.LogLevel) {}
+
+func (l *recordingLogger) contains(s string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.Contains(line, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSetLoggerIsShared(t *testing.T) {
+	rec := &recordingLogger{}
+	s, base := startTestService(t, NewServiceBuilder().SetLogger(rec))
+	route := s.RegisterRouteGET("/hello", func(w http.ResponseWriter, r *http.Request) {
+		WriteRaw(w, "text/plain", "hi")
+	})
+	sse := s.RegisterSSE("/events", newTestSseHandler)
+
+	if s.Logger != Logger(rec) || route.Logger != Logger(rec) || sse.Logging != Logger(rec) {
+		t.Fatal("service, route, and SSE server do not share the configured logger")
+	}
+
+	resp, err := http.Get(base + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !rec.contains("Request method GET path /hello status 200") {
+		t.Fatalf("request was not logged, got %q", rec.lines)
+	}
+}
+
+func TestLoggerFactoryNamesLoggers(t *testing.T) {
+	names := make(chan string, 4)
+	s := NewServiceBuilder().SetLoggerFactory(func(name string) Logger {
+		names <- name
+		return &recordingLogger{}
+	}).Build()
+
+	s.RegisterRouteGET("/hello", func(w http.ResponseWriter, r *http.Request) {})
+	if name := <-names; name != "/hello" {
+		t.Fatalf("route logger named %q, want /hello", name)
+	}
+	s.RegisterSSE("/events", newTestSseHandler)
+	if name := <-names; name != "sse::/events" {
+		t.Fatalf("SSE logger named %q, want sse::/events", name)
+	}
+}
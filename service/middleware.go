@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"net/http"
+)
+
+// Middleware wraps a handler, for example to authenticate the request or
+// add values to its context before calling next.
+type Middleware func(next ServiceHandleFunc) ServiceHandleFunc
+
+// chainMiddleware wraps fn so the first middleware runs first.
+func chainMiddleware(fn ServiceHandleFunc, middleware []Middleware) ServiceHandleFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		fn = middleware[i](fn)
+	}
+	return fn
+}
+
+// Use adds middleware run around every registered route, ahead of the
+// route's own middleware. Requests that match no route are not affected.
+func (s *Service) Use(middleware ...Middleware) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.middleware = append(s.middleware, middleware...)
+	return s
+}
+
+// Use adds middleware run around this route's handler only, which lets
+// some routes require authentication while others stay public.
+func (s *serviceHttpRouteInfo) Use(middleware ...Middleware) *serviceHttpRouteInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.middleware = append(s.middleware, middleware...)
+	return s
+}
+
+// contextValueKey is the context key for values of type T stored with
+// WithValue. Each T gets its own key type, so values cannot collide with
+// each other or with keys defined by other packages.
+type contextValueKey[T any] struct{}
+
+// WithValue returns a copy of r carrying value, for middleware to hand a
+// tenant, database handle, or similar to the handler. One value is kept per
+// type; define a named type to store several values of the same kind.
+func WithValue[T any](r *http.Request, value T) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), contextValueKey[T]{}, value))
+}
+
+// Value returns the value of type T stored with WithValue.
+func Value[T any](r *http.Request) (T, bool) {
+	value, ok := r.Context().Value(contextValueKey[T]{}).(T)
+	return value, ok
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testTenant struct {
+	ID   string
+	Plan string
+}
+
+// tenantID is a second string-based value, kept apart from plain strings.
+type tenantID string
+
+func TestWithValuePassesStructToHandler(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	s.Use(func(next ServiceHandleFunc) ServiceHandleFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			r = WithValue(r, testTenant{ID: r.Header.Get("X-Tenant"), Plan: "pro"})
+			r = WithValue(r, tenantID("from-id-type"))
+			next(w, r)
+		}
+	})
+
+	var tenant testTenant
+	var found, stringFound bool
+	var id tenantID
+	s.RegisterRouteGET("/tenant", func(w http.ResponseWriter, r *http.Request) {
+		tenant, found = Value[testTenant](r)
+		id, _ = Value[tenantID](r)
+		_, stringFound = Value[string](r)
+	})
+
+	r := httptest.NewRequest("GET", "/tenant", nil)
+	r.Header.Set("X-Tenant", "acme")
+	s.ServeHTTP(httptest.NewRecorder(), r)
+
+	if !found || tenant != (testTenant{ID: "acme", Plan: "pro"}) {
+		t.Errorf("Value[testTenant] = %+v, %v", tenant, found)
+	}
+	if id != "from-id-type" {
+		t.Errorf("Value[tenantID] = %q", id)
+	}
+	if stringFound {
+		t.Error("Value[string] found a tenantID")
+	}
+	if _, ok := Value[testTenant](httptest.NewRequest("GET", "/", nil)); ok {
+		t.Error("Value found a tenant on a bare request")
+	}
+}
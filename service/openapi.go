@@ -0,0 +1,134 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// openAPIMethods are the operations an OpenAPI path item can hold. A route
+// registered for "*" is listed under all of them.
+var openAPIMethods = []string{"GET", "PUT", "POST", "DELETE", "OPTIONS", "HEAD", "PATCH", "TRACE"}
+
+// Describe documents the route in the OpenAPI spec with a one line summary
+// and optional tags used to group operations.
+func (s *serviceHttpRouteInfo) Describe(summary string, tags ...string) *serviceHttpRouteInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summary = summary
+	s.tags = tags
+	return s
+}
+
+// openAPIPath converts a route pattern to an OpenAPI path and its
+// parameter names. A leading "*", which matches any prefix such as the load
+// balancer's /service/name, is dropped and ":name" segments become
+// "{name}".
+func openAPIPath(pattern string) (string, []string) {
+	path := strings.TrimPrefix(pattern, "*")
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	var parameters []string
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok && name != "" {
+			segments[i] = "{" + name + "}"
+			parameters = append(parameters, name)
+		}
+	}
+	return strings.Join(segments, "/"), parameters
+}
+
+// OpenAPISpec returns a minimal OpenAPI 3 document describing the
+// registered routes: their paths, path parameters, methods, and any
+// summary and tags set with Describe. Request and response schemas are not
+// known and are left out.
+func (s *Service) OpenAPISpec() ([]byte, error) {
+	title := s.serviceName
+	if title == "" {
+		title = "service"
+	}
+
+	paths := make(map[string]map[string]interface{})
+	for _, route := range s.Routes() {
+		path, names := openAPIPath(route.Pattern)
+
+		var parameters []interface{}
+		for _, name := range names {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]string{"type": "string"},
+			})
+		}
+
+		methods := route.Methods
+		if len(methods) == 1 && methods[0] == "*" {
+			methods = openAPIMethods
+		}
+
+		for _, method := range methods {
+			if !slices.Contains(openAPIMethods, method) {
+				continue
+			}
+			item, ok := paths[path]
+			if !ok {
+				item = make(map[string]interface{})
+				paths[path] = item
+			}
+			key := strings.ToLower(method)
+			if _, exists := item[key]; exists {
+				// an earlier registration takes precedence, as in routing
+				continue
+			}
+
+			operation := map[string]interface{}{
+				"responses": map[string]interface{}{
+					"default": map[string]string{"description": "response"},
+				},
+			}
+			if route.Summary != "" {
+				operation["summary"] = route.Summary
+			}
+			if len(route.Tags) > 0 {
+				operation["tags"] = route.Tags
+			}
+			if len(parameters) > 0 {
+				operation["parameters"] = parameters
+			}
+			item[key] = operation
+		}
+	}
+
+	spec := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   title,
+			"version": "1.0.0",
+		},
+		"paths": paths,
+	}
+	if s.serviceName != "" {
+		spec["servers"] = []map[string]string{{"url": "/service/" + s.serviceName}}
+	}
+
+	return json.MarshalIndent(spec, "", "  ")
+}
+
+// RegisterOpenAPISpec serves OpenAPISpec at uri, for Swagger UI and other
+// tooling. The spec is generated per request, so it includes routes
+// registered later.
+func (s *Service) RegisterOpenAPISpec(uri string) *serviceHttpRouteInfo {
+	return s.RegisterRouteGET(uri, func(w http.ResponseWriter, r *http.Request) {
+		spec, err := s.OpenAPISpec()
+		if err != nil {
+			WriteErrorCode(w, http.StatusInternalServerError, err)
+			return
+		}
+		WriteRaw(w, "application/json", spec)
+	})
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// openAPIDocument is the part of the spec the tests check.
+type openAPIDocument struct {
+	OpenAPI string `json:"openapi"`
+	Info    struct {
+		Title string `json:"title"`
+	} `json:"info"`
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	Paths map[string]map[string]struct {
+		Summary    string   `json:"summary"`
+		Tags       []string `json:"tags"`
+		Parameters []struct {
+			Name     string `json:"name"`
+			In       string `json:"in"`
+			Required bool   `json:"required"`
+		} `json:"parameters"`
+	} `json:"paths"`
+}
+
+// openAPIMethodKeys are the operation keys of a path item, sorted.
+var openAPIMethodKeys = []string{"delete", "get", "head", "options", "patch", "post", "put", "trace"}
+
+func TestOpenAPISpecPaths(t *testing.T) {
+	s := NewServiceBuilder().SetServiceName("accounts").Build()
+	s.RegisterRouteGET("*/users/:id", noopHandler).Describe("Get a user", "users")
+	s.RegisterRouteMethods("*/users/:id", []string{"PUT", "PATCH"}, noopHandler)
+	s.RegisterRoutePOST("/orgs/:org/members/:member", noopHandler)
+	s.RegisterRoute("/custom", "PURGE", noopHandler)
+	s.RegisterRoute("/health", "*", noopHandler)
+	s.RegisterOpenAPISpec("/openapi.json")
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/openapi.json", nil))
+	var doc openAPIDocument
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("spec is not JSON: %v", err)
+	}
+
+	if doc.OpenAPI != "3.0.3" || doc.Info.Title != "accounts" {
+		t.Errorf("openapi %q, title %q", doc.OpenAPI, doc.Info.Title)
+	}
+	if len(doc.Servers) != 1 || doc.Servers[0].URL != "/service/accounts" {
+		t.Errorf("servers = %+v", doc.Servers)
+	}
+
+	if _, ok := doc.Paths["/custom"]; ok {
+		t.Error("a route with no OpenAPI methods is listed")
+	}
+
+	var paths []string
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	want := map[string][]string{
+		"/users/{id}":                  {"get", "patch", "put"},
+		"/orgs/{org}/members/{member}": {"post"},
+		"/openapi.json":                {"get"},
+		"/health":                      {"delete", "get", "head", "options", "patch", "post", "put", "trace"},
+	}
+	if len(doc.Paths) != len(want) {
+		t.Fatalf("paths = %v, want %v", paths, want)
+	}
+	for path, methods := range want {
+		var got []string
+		for _, method := range openAPIMethodKeys {
+			if _, ok := doc.Paths[path][method]; ok {
+				got = append(got, method)
+			}
+		}
+		if !reflect.DeepEqual(got, methods) {
+			t.Errorf("%s methods = %v, want %v", path, got, methods)
+		}
+	}
+
+	get := doc.Paths["/users/{id}"]["get"]
+	if get.Summary != "Get a user" || !reflect.DeepEqual(get.Tags, []string{"users"}) {
+		t.Errorf("get user summary %q tags %v", get.Summary, get.Tags)
+	}
+	if len(get.Parameters) != 1 || get.Parameters[0].Name != "id" || get.Parameters[0].In != "path" || !get.Parameters[0].Required {
+		t.Errorf("get user parameters = %+v", get.Parameters)
+	}
+	if members := doc.Paths["/orgs/{org}/members/{member}"]["post"]; len(members.Parameters) != 2 {
+		t.Errorf("member parameters = %+v", members.Parameters)
+	}
+}
@@ -3,13 +3,19 @@ package service
 import (
 	"bytes"
 	"context"
+	"encoding"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math"
 	"net/http"
-	"strings"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/Moonlight-Companies/goconvert/convert"
 	"github.com/Moonlight-Companies/goconvert/validate"
@@ -21,98 +27,506 @@ type parameterKey string
 
 const parameter_request_params = parameterKey("request_params")
 const parameter_request_body = parameterKey("request_body")
+const parameter_matched_route = parameterKey("matched_route")
+const parameter_trace_headers = parameterKey("trace_headers")
+const parameter_path_params = parameterKey("path_params")
+const parameter_request_start = parameterKey("request_start")
 
-func (s *Service) parameters(r *http.Request, params_uri map[string]string) (context.Context, error) {
-	contentType := r.Header.Get("Content-Type")
-	params := make(map[string]interface{})
-	ctx := r.Context()
+// ParameterSource identifies where a unified parameter came from.
+type ParameterSource int
 
-	// start with query parameters, these get clobbered by anything else
-	for k, v := range r.URL.Query() {
-		if len(v) > 0 {
-			params[k] = v[0]
+const (
+	// ParameterSourceQuery is the URL query string, first value per key.
+	// Every value of a repeated key is available to HttpParameterSlice.
+	ParameterSourceQuery ParameterSource = iota
+	// ParameterSourcePath is the named parameters matched by the route.
+	ParameterSourcePath
+	// ParameterSourceBody is the fields of a JSON object body, or "data"
+	// for a JSON array body.
+	ParameterSourceBody
+	// ParameterSourceForm is a url-encoded form body.
+	ParameterSourceForm
+	// ParameterSourceHeader is the request headers, keyed by canonical
+	// header name (e.g. "X-Tenant-Id"). It is not merged by default.
+	ParameterSourceHeader
+)
+
+// DefaultParameterPrecedence is the merge order used unless changed with
+// SetParameterPrecedence. Later sources overwrite earlier ones, so a JSON
+// body field replaces a query parameter of the same name.
+var DefaultParameterPrecedence = []ParameterSource{
+	ParameterSourceQuery,
+	ParameterSourcePath,
+	ParameterSourceBody,
+	ParameterSourceForm,
+}
+
+// SetParameterPrecedence sets which sources are merged into the unified
+// parameters and in what order, lowest precedence first. Sources that are
+// left out are not merged, though the JSON body is still available to
+// HttpParameterInto.
+func (s *Service) SetParameterPrecedence(sources ...ParameterSource) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.parameterPrecedence = append([]ParameterSource(nil), sources...)
+	return s
+}
+
+// SetJSONFloatNumbers controls how numbers in a JSON body are stored in
+// the unified parameters. By default they are kept as json.Number, so
+// HttpParameterT[int64] reads ids above 2^53 exactly. Enable it to store
+// float64 instead, as encoding/json does by default, for handlers that
+// type-assert HttpParameters values to float64.
+func (s *Service) SetJSONFloatNumbers(enabled bool) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.floatJSONNumbers = enabled
+	return s
+}
+
+// unmarshalJSONBody is json.Unmarshal, decoding numbers as json.Number
+// when useNumber is set.
+func unmarshalJSONBody(body []byte, v interface{}, useNumber bool) error {
+	if !useNumber {
+		return json.Unmarshal(body, v)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid data after top-level value")
+	}
+	return nil
+}
+
+// convertParameter converts a unified parameter value into T. Types
+// implementing encoding.TextUnmarshaler, such as a validated Email, are
+// built from the string form with UnmarshalText, and fail to convert when
+// it rejects the value. json.Number values are parsed directly into
+// integer types so large ids stay exact, see parseJSONInt.
+func convertParameter[T any](value interface{}) (result T, ok bool) {
+	if unmarshaler, isText := any(&result).(encoding.TextUnmarshaler); isText {
+		var text string
+		switch v := value.(type) {
+		case string:
+			text = v
+		case json.Number:
+			text = v.String()
+		default:
+			return convert.ConvertInto[T](value)
+		}
+		if err := unmarshaler.UnmarshalText([]byte(text)); err != nil {
+			return result, false
+		}
+		return result, true
+	}
+
+	number, isNumber := value.(json.Number)
+	if !isNumber {
+		return convert.ConvertInto[T](value)
+	}
+
+	var err error
+	switch target := any(&result).(type) {
+	case *json.Number:
+		*target = number
+	case *string:
+		*target = number.String()
+	case *int64:
+		*target, err = parseJSONInt(number, 64)
+	case *int:
+		var n int64
+		n, err = parseJSONInt(number, strconv.IntSize)
+		*target = int(n)
+	case *int32:
+		var n int64
+		n, err = parseJSONInt(number, 32)
+		*target = int32(n)
+	case *uint64:
+		*target, err = parseJSONUint(number, 64)
+	case *uint:
+		var n uint64
+		n, err = parseJSONUint(number, strconv.IntSize)
+		*target = uint(n)
+	default:
+		f, floatErr := number.Float64()
+		if floatErr != nil {
+			return result, false
+		}
+		return convert.ConvertInto[T](f)
+	}
+	return result, err == nil
+}
+
+// parseJSONInt parses number as a signed integer of bitSize bits. Numbers
+// written in float form, such as 1.0 or 1e3, are accepted when they are
+// integral and in range.
+func parseJSONInt(number json.Number, bitSize int) (int64, error) {
+	n, err := strconv.ParseInt(number.String(), 10, bitSize)
+	if err == nil {
+		return n, nil
+	}
+	f, floatErr := number.Float64()
+	limit := math.Ldexp(1, bitSize-1)
+	if floatErr != nil || f != math.Trunc(f) || f < -limit || f >= limit {
+		return 0, err
+	}
+	return int64(f), nil
+}
+
+// parseJSONUint is parseJSONInt for unsigned integers.
+func parseJSONUint(number json.Number, bitSize int) (uint64, error) {
+	n, err := strconv.ParseUint(number.String(), 10, bitSize)
+	if err == nil {
+		return n, nil
+	}
+	f, floatErr := number.Float64()
+	if floatErr != nil || f != math.Trunc(f) || f < 0 || f >= math.Ldexp(1, bitSize) {
+		return 0, err
+	}
+	return uint64(f), nil
+}
+
+// ErrInvalidJSONBody is returned when a request declared a JSON body that
+// does not parse. The service answers such requests with 400 unless the
+// route allows it with AllowInvalidJSON.
+var ErrInvalidJSONBody = errors.New("invalid JSON body")
+
+// requestParameters holds the sources of a request's unified parameters.
+// The body is read up front, but the sources are only merged the first
+// time a handler asks for them, so handlers that never call an
+// HttpParameter accessor skip that work. A JSON body is unmarshalled once:
+// up front when an invalid body must be answered with 400 before the
+// handler runs, otherwise on the first merge. The merged maps belong to
+// the request and may be kept by the handler, so they are not pooled.
+type requestParameters struct {
+	precedence []ParameterSource
+	query      url.Values
+	path       map[string]string
+	body       []byte
+	form       url.Values
+	header     http.Header
+	useNumber  bool
+	// invalidBody is called with the error of a body that does not
+	// unmarshal when it is merged, see AllowInvalidJSON.
+	invalidBody func(err error)
+
+	once   sync.Once
+	params map[string]interface{}
+	values map[string][]string
+
+	bodyOnce sync.Once
+	bodyData interface{}
+	bodyErr  error
+}
+
+// merged returns the unified parameters and every value of repeated keys,
+// merging the sources on first use.
+func (p *requestParameters) merged() (map[string]interface{}, map[string][]string) {
+	p.once.Do(func() {
+		if err := p.merge(); err != nil && p.invalidBody != nil {
+			p.invalidBody(err)
+		}
+	})
+	return p.params, p.values
+}
+
+// decodedBody unmarshals the JSON body on first use and returns the
+// result, or the error if it does not parse.
+func (p *requestParameters) decodedBody() (interface{}, error) {
+	p.bodyOnce.Do(func() {
+		if len(p.body) > 0 {
+			p.bodyErr = unmarshalJSONBody(p.body, &p.bodyData, p.useNumber)
+		}
+	})
+	return p.bodyData, p.bodyErr
+}
+
+// merge fills params and values from the sources in precedence order. A
+// body that does not unmarshal contributes nothing and its error is
+// returned.
+func (p *requestParameters) merge() error {
+	var bodyErr error
+	p.params = make(map[string]interface{})
+	p.values = make(map[string][]string)
+	set := func(k string, v interface{}) {
+		p.params[k] = v
+		delete(p.values, k)
+	}
+	setValues := func(values url.Values) {
+		for k, v := range values {
+			if len(v) > 0 {
+				set(k, v[0])
+			}
+		}
+		for k, v := range values {
+			if len(v) > 0 {
+				p.values[k] = v
+			}
+		}
+	}
+
+	for _, source := range p.precedence {
+		switch source {
+		case ParameterSourceQuery:
+			setValues(p.query)
+		case ParameterSourcePath:
+			for k, v := range p.path {
+				set(k, v)
+			}
+		case ParameterSourceBody:
+			// Only objects are merged into the parameter map. Arrays are
+			// exposed as "data" and scalars are left for HttpParameterInto.
+			data, err := p.decodedBody()
+			if err != nil {
+				bodyErr = err
+				break
+			}
+			switch data := data.(type) {
+			case map[string]interface{}:
+				for k, v := range data {
+					set(k, v)
+				}
+			case []interface{}:
+				set("data", data)
+			}
+		case ParameterSourceForm:
+			setValues(p.form)
+		case ParameterSourceHeader:
+			for k, v := range p.header {
+				if len(v) > 0 {
+					set(k, v[0])
+				}
+			}
+		}
+	}
+	return bodyErr
+}
+
+// requestParametersOf returns the parameters stored by parameters, or an
+// empty set for requests that did not come through the service.
+func requestParametersOf(r *http.Request) *requestParameters {
+	if p, ok := r.Context().Value(parameter_request_params).(*requestParameters); ok {
+		return p
+	}
+	return &requestParameters{}
+}
+
+// parseOptions adjusts how parameters treats the request body of a route.
+type parseOptions struct {
+	// tolerateInvalidJSON passes a malformed JSON body on instead of
+	// failing, see AllowInvalidJSON.
+	tolerateInvalidJSON bool
+	// skipBody leaves the body unread, see SkipBodyParsing.
+	skipBody bool
+}
+
+// parameters builds the request context holding the unified parameters.
+// A malformed JSON body is an error unless opts.tolerateInvalidJSON is set.
+// w bounds the body read with a read deadline and may be nil.
+func (s *Service) parameters(w http.ResponseWriter, r *http.Request, params_uri map[string]string, opts parseOptions) (context.Context, error) {
+	if !opts.skipBody {
+		if err := s.decodeRequestBody(r); err != nil {
+			return r.Context(), err
 		}
 	}
 
+	s.mu.RLock()
+	p := &requestParameters{
+		precedence: s.parameterPrecedence,
+		useNumber:  !s.floatJSONNumbers,
+	}
+	maxBodySize, bodyReadTimeout := s.maxBodySize, s.bodyReadTimeout
+	s.mu.RUnlock()
+	if p.precedence == nil {
+		p.precedence = DefaultParameterPrecedence
+	}
+
+	// without a content type neither a JSON nor a form body is read
+	contentType := ""
+	if !opts.skipBody {
+		contentType = mediaType(r)
+	}
+	ctx := r.Context()
+
+	p.query = r.URL.Query()
+
+	pathParams := make(map[string]string, len(params_uri))
 	for k, v := range params_uri {
 		if len(v) > 0 {
-			params[k] = v
+			pathParams[k] = v
 		}
 	}
+	p.path = pathParams
 
-	if strings.HasPrefix(contentType, "application/json") {
+	if isJSONMediaType(contentType) {
 		// Read and store raw body
-		body, err := io.ReadAll(r.Body)
+		body, err := readBody(w, r.Body, maxBodySize, bodyReadTimeout)
 		if err != nil {
 			return ctx, err
 		}
 		r.Body = io.NopCloser(bytes.NewReader(body))
 		ctx = context.WithValue(ctx, parameter_request_body, body)
 
-		if len(body) > 0 {
-			var jsonData interface{}
-			if err := json.Unmarshal(body, &jsonData); err != nil {
-				if san, err := validate.ValidateBasicText(string(body)); err != nil {
-					log.Println("Service::parameters: failed to unmarshal json", err, san)
-				}
-			} else {
-				switch data := jsonData.(type) {
-				case map[string]interface{}:
-					for k, v := range data {
-						params[k] = v
-					}
-				case []interface{}:
-					params["data"] = data
-				default:
+		if len(bytes.TrimSpace(body)) > 0 {
+			p.body = body
+			if opts.tolerateInvalidJSON {
+				p.invalidBody = func(unmarshalErr error) {
 					if san, err := validate.ValidateBasicText(string(body)); err != nil {
-						log.Println("Service::parameters: failed to unmarshal json type", err, san)
+						logInfow(s.Logger, "failed to unmarshal json body", "error", unmarshalErr, "validation", err, "sanitized", san)
 					}
 				}
+			} else if _, err := p.decodedBody(); err != nil {
+				return ctx, fmt.Errorf("%w: %v", ErrInvalidJSONBody, err)
 			}
 		}
 	}
 
-	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") ||
-		strings.HasPrefix(contentType, "multipart/form-data") {
+	if contentType == "application/x-www-form-urlencoded" ||
+		contentType == "multipart/form-data" {
 		// Parse form parameters
 		if err := r.ParseForm(); err != nil {
 			return ctx, err
 		}
-		for k, v := range r.Form {
-			if len(v) > 0 {
-				params[k] = v[0]
-			}
-		}
+		p.form = r.PostForm
+	}
+
+	if slices.Contains(p.precedence, ParameterSourceHeader) {
+		p.header = r.Header
 	}
 
 	// Always store the unified parameters
-	ctx = context.WithValue(ctx, parameter_request_params, params)
+	ctx = context.WithValue(ctx, parameter_request_params, p)
+	ctx = context.WithValue(ctx, parameter_path_params, pathParams)
+	ctx = withTraceHeaders(ctx, r)
 	return ctx, nil
 }
 
-func HttpRemoteIP(r *http.Request) string {
-	ip := r.Header.Get("X-Real-IP")
-	if ip == "" {
-		ip = r.Header.Get("X-Forwarded-For")
+// HttpParameterHeader returns the first value of the named request header.
+func HttpParameterHeader(r *http.Request, name string) (string, bool) {
+	values := r.Header.Values(name)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// BuildContext returns r's context populated with the unified parameters,
+// exactly as ServeHTTP prepares it before calling a handler. pathParams
+// stands in for the named parameters a route pattern would have matched.
+// It lets handlers be exercised directly in tests.
+func (s *Service) BuildContext(r *http.Request, pathParams map[string]string) (context.Context, error) {
+	return s.parameters(nil, r, pathParams, parseOptions{})
+}
+
+// DefaultMaxBodySize bounds the JSON body parameters reads into memory.
+const DefaultMaxBodySize = 32 << 20
+
+// ErrBodyReadAborted is returned by parameters when the body read times out
+// or the connection fails before the body has been read. A timeout also
+// wraps context.DeadlineExceeded, which the service answers with 408.
+var ErrBodyReadAborted = errors.New("request body read aborted")
+
+// SetMaxBodySize sets the largest JSON body the service reads for the
+// unified parameters. Larger bodies are answered with 413. The default is
+// DefaultMaxBodySize.
+func (s *Service) SetMaxBodySize(n int64) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxBodySize = n
+	return s
+}
+
+// SetBodyReadTimeout sets how long the service waits for a JSON body to
+// arrive before answering 408, so a client trickling bytes cannot hold a
+// handler. Zero, the default, leaves the server's read timeout in charge.
+func (s *Service) SetBodyReadTimeout(d time.Duration) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bodyReadTimeout = d
+	return s
+}
+
+// readBody reads body to completion, at most limit bytes and, when w is
+// set, within timeout through the connection's read deadline.
+func readBody(w http.ResponseWriter, body io.ReadCloser, limit int64, timeout time.Duration) ([]byte, error) {
+	if body == nil {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+
+	if w != nil && timeout > 0 {
+		rc := http.NewResponseController(w)
+		if rc.SetReadDeadline(time.Now().Add(timeout)) == nil {
+			defer rc.SetReadDeadline(time.Time{})
+		}
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, body, limit))
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == nil:
+		return data, nil
+	case errors.As(err, &tooLarge):
+		return nil, ErrBodyTooLarge
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return nil, fmt.Errorf("%w: %w", ErrBodyReadAborted, context.DeadlineExceeded)
+	default:
+		return nil, fmt.Errorf("%w: %w", ErrBodyReadAborted, err)
+	}
+}
+
+// HttpRequestStart returns when the service started handling r.
+func HttpRequestStart(r *http.Request) (time.Time, bool) {
+	start, ok := r.Context().Value(parameter_request_start).(time.Time)
+	return start, ok
+}
+
+// HttpElapsed returns how long the service has been handling r, for
+// example to include "took_ms" in a response. It is zero for requests that
+// did not come through ServeHTTP.
+func HttpElapsed(r *http.Request) time.Duration {
+	start, ok := HttpRequestStart(r)
+	if !ok {
+		return 0
 	}
-	if ip == "" {
-		ip = r.RemoteAddr
+	return time.Since(start)
+}
+
+// HttpMatchedRoute returns the registered pattern (e.g. "*/users/:id") of
+// the route serving r, or "" when no route matched. Unlike r.URL.Path it has
+// low cardinality, which suits metric labels.
+func HttpMatchedRoute(r *http.Request) string {
+	if route, ok := r.Context().Value(parameter_matched_route).(string); ok {
+		return route
 	}
-	return ip
+	return ""
 }
 
 // HttpParameters retrieves the unified parameters from context
 func HttpParameters(r *http.Request) map[string]interface{} {
-	if params, ok := r.Context().Value(parameter_request_params).(map[string]interface{}); ok {
+	params, _ := requestParametersOf(r).merged()
+	return params
+}
+
+// HttpPathParams returns only the named parameters matched from the path,
+// such as "id" for "*/users/:id", even when a query or body field of the
+// same name replaced it in HttpParameters.
+func HttpPathParams(r *http.Request) map[string]string {
+	if params, ok := r.Context().Value(parameter_path_params).(map[string]string); ok {
 		return params
 	}
-	return map[string]interface{}{}
+	return map[string]string{}
 }
 
 // HttpParameterInto decodes JSON from the raw request body into a given type T.
 // Only works when the middleware stored a JSON body.
 func HttpParameterInto[T any](r *http.Request) (result T, err error) {
-	rawBody, ok := r.Context().Value(parameterKey(parameter_request_body)).([]byte)
+	rawBody, ok := r.Context().Value(parameter_request_body).([]byte)
 	if !ok {
 		return result, errors.New("no data found in request context")
 	}
@@ -149,12 +563,69 @@ func HttpParameterGeneric(r *http.Request, name string) (interface{}, error) {
 }
 
 // HttpParameterT retrieves a parameter by name and converts it into type T.
+// Slices of strings, ints, floats, and bools are read with
+// HttpParameterSlice, so ?id=1&id=2 converts to []int{1, 2}. Types
+// implementing encoding.TextUnmarshaler are parsed with UnmarshalText.
 func HttpParameterT[T any](r *http.Request, name string) (result T, ok bool) {
+	switch any(result).(type) {
+	case []string:
+		return httpParameterSliceT[T, string](r, name)
+	case []int:
+		return httpParameterSliceT[T, int](r, name)
+	case []int64:
+		return httpParameterSliceT[T, int64](r, name)
+	case []float64:
+		return httpParameterSliceT[T, float64](r, name)
+	case []bool:
+		return httpParameterSliceT[T, bool](r, name)
+	}
+
 	value, err := HttpParameterGeneric(r, name)
 	if err != nil {
 		return result, false
 	}
-	return convert.ConvertInto[T](value)
+	return convertParameter[T](value)
+}
+
+func httpParameterSliceT[T any, E any](r *http.Request, name string) (result T, ok bool) {
+	values, ok := HttpParameterSlice[E](r, name)
+	if !ok {
+		return result, false
+	}
+	return any(values).(T), true
+}
+
+// HttpParameterSlice retrieves every value of a parameter and converts each
+// into E. A key repeated in the query string or form yields all its values.
+// When the winning source is a JSON body, an array field yields its
+// elements and any other value is treated as a single element. It fails if
+// any element does not convert.
+func HttpParameterSlice[E any](r *http.Request, name string) ([]E, bool) {
+	value, err := HttpParameterGeneric(r, name)
+	if err != nil {
+		return nil, false
+	}
+
+	var elements []interface{}
+	if _, values := requestParametersOf(r).merged(); len(values[name]) > 0 {
+		for _, v := range values[name] {
+			elements = append(elements, v)
+		}
+	} else if array, ok := value.([]interface{}); ok {
+		elements = array
+	} else {
+		elements = []interface{}{value}
+	}
+
+	result := make([]E, 0, len(elements))
+	for _, element := range elements {
+		converted, ok := convertParameter[E](element)
+		if !ok {
+			return nil, false
+		}
+		result = append(result, converted)
+	}
+	return result, true
 }
 
 // HttpParameterArray the []map[string]interface{} when the json body was a array of objects.
@@ -0,0 +1,629 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rawRequest writes head to a new connection to s, then body in chunks
+// pause apart, and returns the response.
+func rawRequest(t *testing.T, s *Service, head string, body []string, pause time.Duration) *http.Response {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	io.WriteString(conn, head)
+	go func() {
+		for _, chunk := range body {
+			time.Sleep(pause)
+			if _, err := io.WriteString(conn, chunk); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestTricklingBodyTimesOut(t *testing.T) {
+	s, _ := startTestService(t, NewServiceBuilder())
+	s.SetBodyReadTimeout(100 * time.Millisecond)
+	var called atomic.Bool
+	s.RegisterRoutePOST("/echo", func(w http.ResponseWriter, r *http.Request) {
+		called.Store(true)
+	})
+
+	body := strings.Split(`{"name":"trickle"}`, "")
+	head := fmt.Sprintf("POST /echo HTTP/1.1\r\nHost: test\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n", len(body))
+	start := time.Now()
+	resp := rawRequest(t, s, head, body, 50*time.Millisecond)
+
+	if resp.StatusCode != http.StatusRequestTimeout {
+		t.Fatalf("status = %d, want 408", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("response took %v", elapsed)
+	}
+	if called.Load() {
+		t.Fatal("handler ran for a body that never arrived")
+	}
+}
+
+func TestBodyWithinReadTimeoutIsParsed(t *testing.T) {
+	s, _ := startTestService(t, NewServiceBuilder())
+	s.SetBodyReadTimeout(time.Second)
+	s.RegisterRoutePOST("/echo", func(w http.ResponseWriter, r *http.Request) {
+		name, _ := HttpParameterT[string](r, "name")
+		WriteRaw(w, "text/plain", name)
+	})
+
+	body := []string{`{"name":`, `"slow"}`}
+	head := "POST /echo HTTP/1.1\r\nHost: test\r\nContent-Type: application/json\r\nContent-Length: 15\r\n\r\n"
+	resp := rawRequest(t, s, head, body, 20*time.Millisecond)
+
+	got, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(got) != "slow" {
+		t.Fatalf("got %d %q, want 200 slow", resp.StatusCode, got)
+	}
+}
+
+func TestBodyOverMaxSizeIsRejected(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	s.SetMaxBodySize(16)
+	s.RegisterRoutePOST("/echo", func(w http.ResponseWriter, r *http.Request) {})
+
+	resp, err := http.Post(base+"/echo", "application/json", strings.NewReader(`{"name":"far too long for the limit"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", resp.StatusCode)
+	}
+}
+
+// jsonRequest returns a request carrying body as JSON with the unified
+// parameters built by s.
+func jsonRequest(t *testing.T, s *Service, body string) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	ctx, err := s.BuildContext(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.WithContext(ctx)
+}
+
+// captureLog collects the standard logger's output until the test ends.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestArrayBodyIsKeptRaw(t *testing.T) {
+	logged := captureLog(t)
+	r := jsonRequest(t, NewServiceBuilder().Build(), `[1, 2, 3]`)
+
+	got, err := HttpParameterInto[[]int](r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Fatalf("got %v, want [1 2 3]", got)
+	}
+	if _, ok := HttpParameters(r)["data"]; !ok {
+		t.Fatal(`array body is not exposed as "data"`)
+	}
+	if logged.Len() > 0 {
+		t.Fatalf("logged %q for a valid array body", logged)
+	}
+}
+
+func TestScalarBodyIsKeptRaw(t *testing.T) {
+	logged := captureLog(t)
+	s := NewServiceBuilder().Build()
+
+	number, err := HttpParameterInto[int](jsonRequest(t, s, `42`))
+	if err != nil || number != 42 {
+		t.Fatalf("got %v, %v, want 42", number, err)
+	}
+	text, err := HttpParameterInto[string](jsonRequest(t, s, `"hello"`))
+	if err != nil || text != "hello" {
+		t.Fatalf("got %q, %v, want hello", text, err)
+	}
+	if params := HttpParameters(jsonRequest(t, s, `true`)); len(params) != 0 {
+		t.Fatalf("HttpParameters = %v, want none from a scalar body", params)
+	}
+	if logged.Len() > 0 {
+		t.Fatalf("logged %q for valid scalar bodies", logged)
+	}
+}
+
+// precedenceRequest builds a request supplying "name" from the query, the
+// path, and a JSON body, and returns the merged value.
+func precedenceRequest(t *testing.T, s *Service) string {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/?name=query", strings.NewReader(`{"name":"body"}`))
+	r.Header.Set("Content-Type", "application/json")
+	ctx, err := s.BuildContext(r, map[string]string{"name": "path"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, _ := HttpParameters(r.WithContext(ctx))["name"].(string)
+	return name
+}
+
+func TestParameterPrecedence(t *testing.T) {
+	tests := []struct {
+		sources []ParameterSource
+		want    string
+	}{
+		{nil, "body"},
+		{[]ParameterSource{ParameterSourceBody, ParameterSourcePath}, "path"},
+		{[]ParameterSource{ParameterSourcePath, ParameterSourceQuery}, "query"},
+		{[]ParameterSource{ParameterSourceQuery}, "query"},
+	}
+	for _, tt := range tests {
+		s := NewServiceBuilder().Build()
+		if tt.sources != nil {
+			s.SetParameterPrecedence(tt.sources...)
+		}
+		if got := precedenceRequest(t, s); got != tt.want {
+			t.Errorf("precedence %v: name = %q, want %q", tt.sources, got, tt.want)
+		}
+	}
+}
+
+func TestHeaderSourceIsOptIn(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Tenant-Id", "acme")
+
+	s := NewServiceBuilder().Build()
+	ctx, err := s.BuildContext(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := HttpParameters(r.WithContext(ctx))["X-Tenant-Id"]; ok {
+		t.Fatal("headers were merged by default")
+	}
+
+	s.SetParameterPrecedence(ParameterSourceQuery, ParameterSourceHeader)
+	ctx, err = s.BuildContext(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tenant, ok := HttpParameterT[string](r.WithContext(ctx), "X-Tenant-Id"); !ok || tenant != "acme" {
+		t.Fatalf("X-Tenant-Id = %q, %v, want acme", tenant, ok)
+	}
+}
+
+func TestFormOverridesQueryByDefault(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/?name=query&page=2", strings.NewReader("name=form"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx, err := NewServiceBuilder().Build().BuildContext(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := HttpParameters(r.WithContext(ctx))
+	if params["name"] != "form" || params["page"] != "2" {
+		t.Fatalf("params = %v, want name from the form and page from the query", params)
+	}
+}
+
+func TestHttpParameterHeader(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Add("X-Tenant-Id", "acme")
+	r.Header.Add("X-Tenant-Id", "other")
+
+	if tenant, ok := HttpParameterHeader(r, "x-tenant-id"); !ok || tenant != "acme" {
+		t.Fatalf("got %q, %v, want acme", tenant, ok)
+	}
+	if _, ok := HttpParameterHeader(r, "X-Missing"); ok {
+		t.Fatal("missing header reported ok")
+	}
+}
+
+func TestHttpParameterTSlices(t *testing.T) {
+	s := NewServiceBuilder().Build()
+
+	query := httptest.NewRequest(http.MethodGet, "/?id=1&id=2&id=3&name=a&name=b", nil)
+	ctx, err := s.BuildContext(query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query = query.WithContext(ctx)
+	if ids, ok := HttpParameterT[[]int](query, "id"); !ok || !reflect.DeepEqual(ids, []int{1, 2, 3}) {
+		t.Errorf("query ids = %v, %v", ids, ok)
+	}
+	if names, ok := HttpParameterT[[]string](query, "name"); !ok || !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("query names = %v, %v", names, ok)
+	}
+	if _, ok := HttpParameterT[[]bool](query, "name"); ok {
+		t.Error("converting names to []bool succeeded")
+	}
+
+	form := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("id=4&id=5"))
+	form.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx, err = s.BuildContext(form, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form = form.WithContext(ctx)
+	if ids, ok := HttpParameterT[[]int64](form, "id"); !ok || !reflect.DeepEqual(ids, []int64{4, 5}) {
+		t.Errorf("form ids = %v, %v", ids, ok)
+	}
+
+	body := jsonRequest(t, s, `{"id": [6, 7], "one": 8}`)
+	if ids, ok := HttpParameterT[[]int](body, "id"); !ok || !reflect.DeepEqual(ids, []int{6, 7}) {
+		t.Errorf("body ids = %v, %v", ids, ok)
+	}
+	if one, ok := HttpParameterT[[]float64](body, "one"); !ok || !reflect.DeepEqual(one, []float64{8}) {
+		t.Errorf("body scalar = %v, %v", one, ok)
+	}
+}
+
+func TestJSONArrayReplacesRepeatedQueryKey(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	r := httptest.NewRequest(http.MethodPost, "/?id=1&id=2", strings.NewReader(`{"id": [3]}`))
+	r.Header.Set("Content-Type", "application/json")
+	ctx, err := s.BuildContext(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = r.WithContext(ctx)
+
+	// the body comes later in DefaultParameterPrecedence and wins whole
+	if ids, ok := HttpParameterT[[]int](r, "id"); !ok || !reflect.DeepEqual(ids, []int{3}) {
+		t.Fatalf("ids = %v, %v, want [3]", ids, ok)
+	}
+}
+
+func TestHttpPathParamsKeepsPathValue(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	type seen struct {
+		path   map[string]string
+		merged interface{}
+	}
+	got := make(chan seen, 1)
+	s.RegisterRoutePOST("*/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		got <- seen{HttpPathParams(r), HttpParameters(r)["id"]}
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/api/users/42", strings.NewReader(`{"id": "from-body"}`))
+	r.Header.Set("Content-Type", "application/json")
+	s.ServeHTTP(httptest.NewRecorder(), r)
+
+	params := <-got
+	if !reflect.DeepEqual(params.path, map[string]string{"id": "42"}) {
+		t.Errorf("HttpPathParams = %v, want id 42", params.path)
+	}
+	if params.merged != "from-body" {
+		t.Errorf("HttpParameters id = %v, want the body's from-body", params.merged)
+	}
+
+	s.SetNotFoundHandler(func(w http.ResponseWriter, r *http.Request) {
+		got <- seen{path: HttpPathParams(r)}
+	})
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+	if params := <-got; len(params.path) != 0 {
+		t.Errorf("HttpPathParams = %v for an unmatched path, want empty", params.path)
+	}
+}
+
+func TestBrokenJSONBodyIs400(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	ran := false
+	s.RegisterRoutePOST("/strict", func(w http.ResponseWriter, r *http.Request) {
+		ran = true
+	})
+	var raw []byte
+	s.RegisterRoutePOST("/tolerant", func(w http.ResponseWriter, r *http.Request) {
+		raw, _ = io.ReadAll(r.Body)
+		if len(HttpParameters(r)) != 0 {
+			t.Errorf("parameters from a broken body: %v", HttpParameters(r))
+		}
+	}).AllowInvalidJSON()
+
+	post := func(target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"a": 1,`))
+		r.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, r)
+		return rec
+	}
+
+	rec := post("/strict")
+	if rec.Code != http.StatusBadRequest || ran {
+		t.Fatalf("status = %d, handler ran = %v, want 400 without running it", rec.Code, ran)
+	}
+	if !strings.Contains(rec.Body.String(), "invalid JSON body") {
+		t.Errorf("body = %q, want it to say invalid JSON body", rec.Body)
+	}
+
+	logged := captureLog(t)
+	if rec := post("/tolerant"); rec.Code != http.StatusOK {
+		t.Fatalf("tolerant route status = %d, want 200", rec.Code)
+	}
+	if string(raw) != `{"a": 1,` {
+		t.Errorf("tolerant route read %q, want the raw body", raw)
+	}
+	// failures are reported through the service's Logger only
+	if logged.Len() != 0 {
+		t.Errorf("standard logger got %q", logged)
+	}
+}
+
+func TestHttpElapsedIsMonotonic(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	var first, second time.Duration
+	var started bool
+	s.RegisterRouteGET("/timed", func(w http.ResponseWriter, r *http.Request) {
+		_, started = HttpRequestStart(r)
+		first = HttpElapsed(r)
+		time.Sleep(5 * time.Millisecond)
+		second = HttpElapsed(r)
+	})
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/timed", nil))
+
+	if !started {
+		t.Fatal("HttpRequestStart not set by ServeHTTP")
+	}
+	if first < 0 || second < first+5*time.Millisecond {
+		t.Fatalf("elapsed %v then %v, want non-negative and growing by the 5ms slept", first, second)
+	}
+
+	if got := HttpElapsed(httptest.NewRequest(http.MethodGet, "/", nil)); got != 0 {
+		t.Fatalf("HttpElapsed outside the service = %v, want 0", got)
+	}
+}
+
+func TestHttpParameterIntoReadsStoredBody(t *testing.T) {
+	r := jsonRequest(t, NewServiceBuilder().Build(), `{"name": "ada", "age": 36}`)
+
+	got, err := HttpParameterInto[struct {
+		Name string
+		Age  int
+	}](r)
+	if err != nil || got.Name != "ada" || got.Age != 36 {
+		t.Fatalf("HttpParameterInto = %+v, %v", got, err)
+	}
+	_, ck, err := HttpParameterIntoHash[map[string]any](r)
+	if want, _ := Hash([]byte(`{"name": "ada", "age": 36}`)); err != nil || ck != want {
+		t.Fatalf("HttpParameterIntoHash checksum %d, %v, want %d", ck, err, want)
+	}
+}
+
+func TestParameterKeysDoNotCollideWithStrings(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), "request_body", []byte(`{"forged": true}`)))
+
+	if got, err := HttpParameterInto[map[string]any](r); err == nil {
+		t.Fatalf("HttpParameterInto read a plain string key: %v", got)
+	}
+}
+
+func TestLargeJSONIDIsExact(t *testing.T) {
+	const id = int64(1)<<53 + 1
+	s := NewServiceBuilder().Build()
+	body := fmt.Sprintf(`{"id": %d, "ids": [%d, 3], "ratio": 0.25, "count": 7}`, id, id)
+
+	r := jsonRequest(t, s, body)
+	if got, ok := HttpParameterT[int64](r, "id"); !ok || got != id {
+		t.Errorf("HttpParameterT[int64] = %d, %v, want %d", got, ok, id)
+	}
+	if got, ok := HttpParameterT[uint64](r, "id"); !ok || got != uint64(id) {
+		t.Errorf("HttpParameterT[uint64] = %d, %v, want %d", got, ok, id)
+	}
+	if got, ok := HttpParameterT[string](r, "id"); !ok || got != strconv.FormatInt(id, 10) {
+		t.Errorf("HttpParameterT[string] = %q, %v", got, ok)
+	}
+	if got, ok := HttpParameterT[float64](r, "ratio"); !ok || got != 0.25 {
+		t.Errorf("HttpParameterT[float64] = %v, %v, want 0.25", got, ok)
+	}
+	if got, ok := HttpParameterT[int](r, "count"); !ok || got != 7 {
+		t.Errorf("HttpParameterT[int] = %v, %v, want 7", got, ok)
+	}
+	if _, ok := HttpParameterT[int32](r, "id"); ok {
+		t.Error("HttpParameterT[int32] accepted a value out of range")
+	}
+
+	s.SetJSONFloatNumbers(true)
+	r = jsonRequest(t, s, body)
+	if _, isFloat := HttpParameters(r)["id"].(float64); !isFloat {
+		t.Errorf("with SetJSONFloatNumbers id is %T, want float64", HttpParameters(r)["id"])
+	}
+}
+
+func TestJSONFloatFormIntegersConvert(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	r := jsonRequest(t, s, `{"one": 1.0, "thousand": 1e3, "half": 1.5, "huge": 1e40, "negative": -2.0}`)
+
+	if got, ok := HttpParameterT[int](r, "one"); !ok || got != 1 {
+		t.Errorf("HttpParameterT[int](1.0) = %d, %v, want 1", got, ok)
+	}
+	if got, ok := HttpParameterT[int](r, "thousand"); !ok || got != 1000 {
+		t.Errorf("HttpParameterT[int](1e3) = %d, %v, want 1000", got, ok)
+	}
+	if got, ok := HttpParameterT[int64](r, "thousand"); !ok || got != 1000 {
+		t.Errorf("HttpParameterT[int64](1e3) = %d, %v, want 1000", got, ok)
+	}
+	if got, ok := HttpParameterT[uint](r, "one"); !ok || got != 1 {
+		t.Errorf("HttpParameterT[uint](1.0) = %d, %v, want 1", got, ok)
+	}
+	if got, ok := HttpParameterT[int32](r, "negative"); !ok || got != -2 {
+		t.Errorf("HttpParameterT[int32](-2.0) = %d, %v, want -2", got, ok)
+	}
+	if got, ok := HttpParameterT[int](r, "half"); ok {
+		t.Errorf("HttpParameterT[int](1.5) = %d, want no conversion", got)
+	}
+	if got, ok := HttpParameterT[int64](r, "huge"); ok {
+		t.Errorf("HttpParameterT[int64](1e40) = %d, want no conversion", got)
+	}
+	if got, ok := HttpParameterT[uint](r, "negative"); ok {
+		t.Errorf("HttpParameterT[uint](-2.0) = %d, want no conversion", got)
+	}
+}
+
+func TestParametersAreMergedOnceOnFirstAccess(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	var body string
+	s.RegisterRoutePOST("/raw", func(w http.ResponseWriter, r *http.Request) {
+		// never asks for parameters, the body is still there to read
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+	})
+	var first, second map[string]interface{}
+	s.RegisterRoutePOST("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		first = HttpParameters(r)
+		second = HttpParameters(r)
+	})
+
+	post := func(target, payload string) {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(payload))
+		r.Header.Set("Content-Type", "application/json")
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	post("/raw", `{"a":1}`)
+	if body != `{"a":1}` {
+		t.Errorf("body = %q", body)
+	}
+
+	post("/users/7?page=2&id=query", `{"name":"ann"}`)
+	want := map[string]interface{}{"page": "2", "id": "7", "name": "ann"}
+	if !reflect.DeepEqual(first, want) {
+		t.Errorf("parameters = %v, want %v", first, want)
+	}
+	if reflect.ValueOf(first).Pointer() != reflect.ValueOf(second).Pointer() {
+		t.Error("second HttpParameters call merged again")
+	}
+}
+
+func BenchmarkNoParameterHandler(b *testing.B) {
+	s := NewServiceBuilder().Build()
+	s.RegisterRoutePOST("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	payload := `{"id":1,"name":"ann","tags":["a","b","c"],"nested":{"x":1,"y":2}}`
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/ping", strings.NewReader(payload))
+		r.Header.Set("Content-Type", "application/json")
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+}
+
+func BenchmarkParameterHandler(b *testing.B) {
+	s := NewServiceBuilder().Build()
+	s.RegisterRoutePOST("/ping", func(w http.ResponseWriter, r *http.Request) {
+		HttpParameters(r)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	payload := `{"id":1,"name":"ann","tags":["a","b","c"],"nested":{"x":1,"y":2}}`
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/ping", strings.NewReader(payload))
+		r.Header.Set("Content-Type", "application/json")
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+}
+
+func BenchmarkQueryParameterHandler(b *testing.B) {
+	s := NewServiceBuilder().Build()
+	s.RegisterRouteGET("/ping", func(w http.ResponseWriter, r *http.Request) {
+		HttpParameterT[int](r, "id")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/ping?id=1&name=ann", nil)
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+}
+
+// BenchmarkJSONBodyParameterHandler is BenchmarkQueryParameterHandler with
+// the parameters in a JSON body, which is unmarshalled once per request.
+func BenchmarkJSONBodyParameterHandler(b *testing.B) {
+	s := NewServiceBuilder().Build()
+	s.RegisterRoutePOST("/ping", func(w http.ResponseWriter, r *http.Request) {
+		HttpParameterT[int](r, "id")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/ping", strings.NewReader(`{"id":1,"name":"ann"}`))
+		r.Header.Set("Content-Type", "application/json")
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+}
+
+// testEmail is a domain type parsed from its text form.
+type testEmail string
+
+func (e *testEmail) UnmarshalText(text []byte) error {
+	if !strings.Contains(string(text), "@") {
+		return fmt.Errorf("invalid email %q", text)
+	}
+	*e = testEmail(strings.ToLower(string(text)))
+	return nil
+}
+
+func TestHttpParameterTUsesTextUnmarshaler(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	r := httptest.NewRequest(http.MethodGet, "/?to=Ann@Example.com&cc=a@x.io&cc=B@y.io&bad=nope", nil)
+	ctx, err := s.BuildContext(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = r.WithContext(ctx)
+
+	if got, ok := HttpParameterT[testEmail](r, "to"); !ok || got != "ann@example.com" {
+		t.Errorf("to = %q, %v, want ann@example.com", got, ok)
+	}
+	if got, ok := HttpParameterSlice[testEmail](r, "cc"); !ok || !reflect.DeepEqual(got, []testEmail{"a@x.io", "b@y.io"}) {
+		t.Errorf("cc = %q, %v", got, ok)
+	}
+	if got, ok := HttpParameterT[testEmail](r, "bad"); ok {
+		t.Errorf("bad = %q, want rejected by UnmarshalText", got)
+	}
+	if _, ok := HttpParameterT[testEmail](r, "missing"); ok {
+		t.Error("missing parameter converted")
+	}
+
+	body := jsonRequest(t, s, `{"to": "Bob@Example.com", "count": 3}`)
+	if got, ok := HttpParameterT[testEmail](body, "to"); !ok || got != "bob@example.com" {
+		t.Errorf("JSON to = %q, %v, want bob@example.com", got, ok)
+	}
+	if got, ok := HttpParameterT[testEmail](body, "count"); ok {
+		t.Errorf("JSON count = %q, want rejected by UnmarshalText", got)
+	}
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+)
+
+// PreloadHint is an asset browsers should start fetching before they parse
+// the page that needs it, sent as a Link header such as
+// </app.js>; rel=preload; as=script.
+type PreloadHint struct {
+	// URL is the asset's path or URL.
+	URL string
+	// As is the kind of asset: script, style, font, image, or fetch.
+	As string
+	// Type optionally names the asset's MIME type, so browsers skip
+	// formats they do not support.
+	Type string
+	// CrossOrigin marks the fetch as CORS, which fonts always require.
+	CrossOrigin bool
+}
+
+// String formats the hint as a Link header value.
+func (h PreloadHint) String() string {
+	var b strings.Builder
+	b.WriteString("<" + h.URL + ">; rel=preload")
+	if h.As != "" {
+		b.WriteString("; as=" + h.As)
+	}
+	if h.Type != "" {
+		b.WriteString(`; type="` + h.Type + `"`)
+	}
+	if h.CrossOrigin {
+		b.WriteString("; crossorigin")
+	}
+	return b.String()
+}
+
+// SetPreloadLinks sends a Link preload header for each hint with every
+// response of the route, such as an SPA shell, so browsers fetch its
+// critical assets early. The headers are set before the handler runs.
+func (s *serviceHttpRouteInfo) SetPreloadLinks(hints []PreloadHint) *serviceHttpRouteInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.preloadLinks = hints
+	return s
+}
+
+// SetStaticPreloadLinks sends a Link preload header for each hint with
+// the static index.html, see SetPreloadLinks.
+func (s *Service) SetStaticPreloadLinks(hints []PreloadHint) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.staticPreloadLinks = hints
+	return s
+}
+
+func addPreloadLinks(w http.ResponseWriter, hints []PreloadHint) {
+	for _, hint := range hints {
+		w.Header().Add("Link", hint.String())
+	}
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+)
+
+var testPreloadHints = []PreloadHint{
+	{URL: "/app.js", As: "script"},
+	{URL: "/font.woff2", As: "font", Type: "font/woff2", CrossOrigin: true},
+}
+
+var testPreloadLinks = []string{
+	"</app.js>; rel=preload; as=script",
+	`</font.woff2>; rel=preload; as=font; type="font/woff2"; crossorigin`,
+}
+
+func TestStaticIndexSendsPreloadLinks(t *testing.T) {
+	s := staticService(t, map[string]string{
+		"index.html": "<html></html>",
+		"app.js":     "run()",
+	})
+	s.SetStaticPreloadLinks(testPreloadHints)
+
+	for _, target := range []string{"/", "/index.html"} {
+		rec := getStatic(s, target, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", target, rec.Code)
+		}
+		if got := rec.Result().Header.Values("Link"); !slices.Equal(got, testPreloadLinks) {
+			t.Errorf("%s: Link = %q, want %q", target, got, testPreloadLinks)
+		}
+	}
+	if got := getStatic(s, "/app.js", "").Result().Header.Values("Link"); got != nil {
+		t.Errorf("/app.js: Link = %q, want none", got)
+	}
+}
+
+func TestRoutePreloadLinksPrecedeBody(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	s.RegisterRouteGET("/shell", func(w http.ResponseWriter, r *http.Request) {
+		WriteHTML(w, "<html></html>", http.StatusOK)
+	}).SetPreloadLinks(testPreloadHints)
+
+	resp, err := http.Get(base + "/shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Values("Link"); !slices.Equal(got, testPreloadLinks) {
+		t.Errorf("Link = %q, want %q", got, testPreloadLinks)
+	}
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// SetPrettyJSON makes WriteT indent every response, which is easier to read
+// when calling endpoints by hand.
+func (s *Service) SetPrettyJSON(enabled bool) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prettyJSON = enabled
+	return s
+}
+
+// SetPrettyJSONQuery controls whether a request can ask for indented output
+// with ?pretty=1. It is enabled by default; disable it in production so
+// clients cannot inflate responses.
+func (s *Service) SetPrettyJSONQuery(enabled bool) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.noPrettyJSONQuery = !enabled
+	return s
+}
+
+// wantsPrettyJSON reports whether responses to r should be indented.
+func (s *Service) wantsPrettyJSON(r *http.Request) bool {
+	s.mu.RLock()
+	pretty, noQuery := s.prettyJSON, s.noPrettyJSONQuery
+	s.mu.RUnlock()
+
+	if pretty {
+		return true
+	}
+	if noQuery {
+		return false
+	}
+
+	value := r.URL.Query().Get("pretty")
+	if value == "" {
+		return false
+	}
+	enabled, err := strconv.ParseBool(value)
+	return err == nil && enabled
+}
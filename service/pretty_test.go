@@ -0,0 +1,41 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrettyJSON(t *testing.T) {
+	const compact = `{"a":1}`
+	const indented = "{\n  \"a\": 1\n}"
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		WriteT(w, map[string]int{"a": 1})
+	}
+
+	tests := []struct {
+		name   string
+		setup  func(s *Service)
+		target string
+		want   string
+	}{
+		{"default", func(s *Service) {}, "/", compact},
+		{"query", func(s *Service) {}, "/?pretty=1", indented},
+		{"query false", func(s *Service) {}, "/?pretty=false", compact},
+		{"query disabled", func(s *Service) { s.SetPrettyJSONQuery(false) }, "/?pretty=1", compact},
+		{"service", func(s *Service) { s.SetPrettyJSON(true).SetPrettyJSONQuery(false) }, "/", indented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServiceBuilder().Build()
+			s.RegisterRouteGET("/", handler)
+			tt.setup(s)
+
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest("GET", tt.target, nil))
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	DefaultRegistrationEndpoint = "__internal_register_service/register_service"
+	DefaultRegistrationInterval = 60 * time.Second
+)
+
+// Registrar announces a running service to the load balancer. The context is
+// cancelled when the service is closed.
+type Registrar interface {
+	Register(ctx context.Context, name string, port int) error
+}
+
+// InvokeRegistrar registers the service by invoking an RPC endpoint with the
+// service name and port. A nil Invoker uses DefaultInvoker.
+type InvokeRegistrar struct {
+	Endpoint string
+	Invoker  Invoker
+}
+
+func (r *InvokeRegistrar) Register(ctx context.Context, name string, port int) error {
+	invoker := r.Invoker
+	if invoker == nil {
+		invoker = DefaultInvoker
+	}
+	_, _, err := InvokeWith[any](ctx, invoker, r.Endpoint, map[string]interface{}{
+		"name": name,
+		"port": port,
+	})
+	return err
+}
+
+type serviceRegistration struct {
+	enabled   bool
+	endpoint  string
+	interval  time.Duration
+	registrar Registrar
+	invoker   Invoker
+}
+
+// SetRegistration enables or disables load balancer registration. It is off
+// by default on the builder; NewServiceWithName turns it on.
+func (b *ServiceBuilder) SetRegistration(enabled bool) *ServiceBuilder {
+	b.registration.enabled = enabled
+	return b
+}
+
+// SetRegistrationEndpoint sets the RPC call used by the default registrar.
+func (b *ServiceBuilder) SetRegistrationEndpoint(endpoint string) *ServiceBuilder {
+	b.registration.endpoint = endpoint
+	return b
+}
+
+// SetRegistrationInterval sets how often the registration is refreshed.
+func (b *ServiceBuilder) SetRegistrationInterval(interval time.Duration) *ServiceBuilder {
+	b.registration.interval = interval
+	return b
+}
+
+// SetRegistrar replaces the default registrar, e.g. with a fake in tests.
+func (b *ServiceBuilder) SetRegistrar(registrar Registrar) *ServiceBuilder {
+	b.registration.registrar = registrar
+	return b
+}
+
+// SetInvoker sets the Invoker used by the default registrar, so tests can
+// observe registration calls without reaching the real server.
+func (b *ServiceBuilder) SetInvoker(invoker Invoker) *ServiceBuilder {
+	b.registration.invoker = invoker
+	return b
+}
+
+// register announces the service immediately and then on every interval
+// until the service is closed.
+func (s *Service) register() {
+	port := s.Port()
+	s.Logger.Infoln("Starting registration goroutine", s.serviceName, port)
+	s.Logger.Infoln("https://io.moonlightcompanies.com/service/" + s.serviceName + "/")
+
+	registrar := s.registration.registrar
+	if registrar == nil {
+		registrar = &InvokeRegistrar{
+			Endpoint: s.registration.endpoint,
+			Invoker:  s.registration.invoker,
+		}
+	}
+
+	interval := s.registration.interval
+	if interval <= 0 {
+		interval = DefaultRegistrationInterval
+	}
+
+	first := true
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if s.Draining() {
+			// let the registration lapse so the load balancer stops
+			// routing new requests here
+			s.Logger.Infoln("draining, registration stopped")
+			return
+		}
+
+		err := registrar.Register(s.ctx, s.serviceName, port)
+		if first {
+			s.Logger.Infoln("register_service result:", err)
+			first = false
+		} else if err != nil {
+			s.Logger.Errorln("register_service failed:", err)
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeRegistrar records every registration on a channel.
+type fakeRegistrar struct {
+	ports chan int
+}
+
+func (f *fakeRegistrar) Register(ctx context.Context, name string, port int) error {
+	select {
+	case f.ports <- port:
+	default:
+	}
+	return nil
+}
+
+func TestRegistrationUsesBoundPort(t *testing.T) {
+	registrar := &fakeRegistrar{ports: make(chan int, 1)}
+	s, _ := startTestService(t, NewServiceBuilder().
+		SetServiceName("test").
+		SetRegistration(true).
+		SetRegistrar(registrar))
+
+	select {
+	case port := <-registrar.ports:
+		if port != s.Port() {
+			t.Fatalf("registered port %d, want %d", port, s.Port())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("service did not register")
+	}
+}
+
+func TestRegistrationIsOptIn(t *testing.T) {
+	registrar := &fakeRegistrar{ports: make(chan int, 1)}
+	startTestService(t, NewServiceBuilder().
+		SetServiceName("test").
+		SetRegistrar(registrar))
+
+	select {
+	case <-registrar.ports:
+		t.Fatal("service registered without SetRegistration(true)")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRegistrationRefreshesOnInterval(t *testing.T) {
+	registrar := &fakeRegistrar{ports: make(chan int, 8)}
+	startTestService(t, NewServiceBuilder().
+		SetServiceName("test").
+		SetRegistration(true).
+		SetRegistrationInterval(10*time.Millisecond).
+		SetRegistrar(registrar))
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-registrar.ports:
+		case <-time.After(time.Second):
+			t.Fatalf("got %d registrations, want 3", i)
+		}
+	}
+}
+
+// fakeInvoker records the calls made through it.
+type fakeInvoker struct {
+	calls chan string
+}
+
+func (f *fakeInvoker) Invoke(ctx context.Context, call string, parameters map[string]interface{}) ([]byte, error) {
+	select {
+	case f.calls <- call:
+	default:
+	}
+	return []byte("{}"), nil
+}
+
+func TestRegistrationInvokesEndpoint(t *testing.T) {
+	invoker := &fakeInvoker{calls: make(chan string, 1)}
+	startTestService(t, NewServiceBuilder().
+		SetServiceName("test").
+		SetRegistration(true).
+		SetRegistrationEndpoint("custom/register").
+		SetInvoker(invoker))
+
+	select {
+	case call := <-invoker.calls:
+		if call != "custom/register" {
+			t.Fatalf("invoked %q, want custom/register", call)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("registration did not invoke")
+	}
+}
+
+// blockingRegistrar blocks every registration until its context is done.
+type blockingRegistrar struct {
+	started  chan struct{}
+	returned chan struct{}
+}
+
+func (b *blockingRegistrar) Register(ctx context.Context, name string, port int) error {
+	close(b.started)
+	<-ctx.Done()
+	close(b.returned)
+	return ctx.Err()
+}
+
+func TestCloseCancelsRegistration(t *testing.T) {
+	registrar := &blockingRegistrar{started: make(chan struct{}), returned: make(chan struct{})}
+	s := NewServiceBuilder().
+		SetHost("127.0.0.1").
+		SetServiceName("test").
+		SetRegistration(true).
+		SetRegistrar(registrar).
+		Build()
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	<-registrar.started
+
+	start := time.Now()
+	s.Close()
+	select {
+	case <-registrar.returned:
+	case <-time.After(time.Second):
+		t.Fatal("registration was not cancelled by Close")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Close took %v", elapsed)
+	}
+}
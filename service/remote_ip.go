@@ -0,0 +1,117 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const parameter_trusted_proxies = parameterKey("trusted_proxies")
+
+// Common trusted proxy ranges for SetTrustedProxies.
+var (
+	// TrustedProxiesLoopback covers a proxy on the same host.
+	TrustedProxiesLoopback = []string{"127.0.0.0/8", "::1/128"}
+	// TrustedProxiesPrivate covers loopback and the private networks a
+	// load balancer usually connects from.
+	TrustedProxiesPrivate = []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7"}
+)
+
+// SetTrustedProxies sets the addresses, as CIDR ranges or single IPs,
+// whose X-Real-IP and X-Forwarded-For headers HttpRemoteIP believes for
+// requests to this service. By default no proxy is trusted and HttpRemoteIP
+// returns the connection's address; behind a load balancer, trust its
+// range, for example SetTrustedProxies(TrustedProxiesPrivate...). Calling
+// it with no arguments trusts no one again.
+func (s *Service) SetTrustedProxies(proxies ...string) error {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, proxy := range proxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				return fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trustedProxies = prefixes
+	return nil
+}
+
+// trustedProxySet is the trusted proxies of the service handling a request.
+type trustedProxySet []netip.Prefix
+
+func (t trustedProxySet) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range t {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwardedAddr parses an address from a forwarding header, which
+// some proxies write with a port.
+func parseForwardedAddr(value string) (netip.Addr, bool) {
+	value = strings.TrimSpace(value)
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return addr.Unmap(), true
+	}
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// HttpRemoteIP returns the IP address of the client, without a port.
+// Forwarding headers are only believed when the connection comes from a
+// proxy trusted with Service.SetTrustedProxies, so clients cannot spoof
+// them. From a trusted proxy, a valid X-Real-IP is used; otherwise
+// X-Forwarded-For is read from the right, skipping trusted hops, and the
+// first untrusted hop is the client. A value that is not an IP address
+// ends the search, leaving the closest valid address found.
+func HttpRemoteIP(r *http.Request) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	trusted, _ := r.Context().Value(parameter_trusted_proxies).(trustedProxySet)
+	peerAddr, ok := parseForwardedAddr(peer)
+	if !ok || !trusted.contains(peerAddr) {
+		return peer
+	}
+
+	if addr, ok := parseForwardedAddr(r.Header.Get("X-Real-IP")); ok {
+		return addr.String()
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	client := peerAddr.String()
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseForwardedAddr(hops[i])
+		if !ok {
+			// a trusted proxy writes addresses, so this came from the client
+			break
+		}
+		client = addr.String()
+		if !trusted.contains(addr) {
+			break
+		}
+	}
+	return client
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// remoteIPService answers every request with HttpRemoteIP.
+func remoteIPService(proxies ...string) (*Service, error) {
+	s := NewServiceBuilder().Build()
+	s.RegisterRouteGET("/ip", func(w http.ResponseWriter, r *http.Request) {
+		WriteRaw(w, "text/plain", HttpRemoteIP(r))
+	})
+	return s, s.SetTrustedProxies(proxies...)
+}
+
+func remoteIP(s *Service, remoteAddr string, header http.Header) string {
+	r := httptest.NewRequest("GET", "/ip", nil)
+	r.RemoteAddr = remoteAddr
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, r)
+	return rec.Body.String()
+}
+
+func TestRemoteIPTrustsNoProxyByDefault(t *testing.T) {
+	s, _ := remoteIPService()
+	header := http.Header{"X-Forwarded-For": {"203.0.113.7"}, "X-Real-Ip": {"203.0.113.8"}}
+	if got := remoteIP(s, "10.0.0.2:4312", header); got != "10.0.0.2" {
+		t.Errorf("HttpRemoteIP = %q, want the connection address", got)
+	}
+	if got := remoteIP(s, "[2001:db8::1]:443", nil); got != "2001:db8::1" {
+		t.Errorf("HttpRemoteIP = %q, want the IPv6 address without port", got)
+	}
+}
+
+func TestRemoteIPForwardedHeaders(t *testing.T) {
+	s, err := remoteIPService(TrustedProxiesPrivate...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		remote string
+		header http.Header
+		want   string
+	}{
+		{"single IP", "10.0.0.2:4312", http.Header{"X-Forwarded-For": {"203.0.113.7"}}, "203.0.113.7"},
+		{"real ip", "10.0.0.2:4312", http.Header{"X-Real-Ip": {"203.0.113.8"}, "X-Forwarded-For": {"203.0.113.7"}}, "203.0.113.8"},
+		{"invalid real ip", "10.0.0.2:4312", http.Header{"X-Real-Ip": {"<script>"}, "X-Forwarded-For": {"203.0.113.7"}}, "203.0.113.7"},
+		{"multiple hops", "10.0.0.2:4312", http.Header{"X-Forwarded-For": {"203.0.113.7, 10.0.0.9, 192.168.1.4"}}, "203.0.113.7"},
+		{"repeated headers", "10.0.0.2:4312", http.Header{"X-Forwarded-For": {"203.0.113.7", "10.0.0.9"}}, "203.0.113.7"},
+		{"hop with port", "10.0.0.2:4312", http.Header{"X-Forwarded-For": {"203.0.113.7:51000"}}, "203.0.113.7"},
+		{"spoofed left-most hop", "10.0.0.2:4312", http.Header{"X-Forwarded-For": {"1.1.1.1, 203.0.113.7"}}, "203.0.113.7"},
+		{"spoofed from untrusted peer", "198.51.100.4:4312", http.Header{"X-Forwarded-For": {"1.1.1.1"}, "X-Real-Ip": {"1.1.1.1"}}, "198.51.100.4"},
+		{"garbage hop", "10.0.0.2:4312", http.Header{"X-Forwarded-For": {"203.0.113.7, not-an-ip, 10.0.0.9"}}, "10.0.0.9"},
+		{"all hops trusted", "10.0.0.2:4312", http.Header{"X-Forwarded-For": {"10.0.0.8, 10.0.0.9"}}, "10.0.0.8"},
+		{"no header", "10.0.0.2:4312", nil, "10.0.0.2"},
+		{"mapped IPv4 peer", "[::ffff:10.0.0.2]:4312", http.Header{"X-Forwarded-For": {"203.0.113.7"}}, "203.0.113.7"},
+	} {
+		if got := remoteIP(s, tc.remote, tc.header); got != tc.want {
+			t.Errorf("%s: HttpRemoteIP = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestTrustedProxiesArePerService(t *testing.T) {
+	trusting, _ := remoteIPService("10.0.0.2")
+	other, _ := remoteIPService()
+	header := http.Header{"X-Forwarded-For": {"203.0.113.7"}}
+
+	if got := remoteIP(trusting, "10.0.0.2:1", header); got != "203.0.113.7" {
+		t.Errorf("trusting service = %q, want the forwarded client", got)
+	}
+	if got := remoteIP(trusting, "10.0.0.3:1", header); got != "10.0.0.3" {
+		t.Errorf("untrusted peer = %q, want the peer", got)
+	}
+	if got := remoteIP(other, "10.0.0.2:1", header); got != "10.0.0.2" {
+		t.Errorf("other service = %q, want the peer", got)
+	}
+
+	if err := trusting.SetTrustedProxies("not a range"); err == nil {
+		t.Error("SetTrustedProxies accepted an invalid range")
+	}
+}
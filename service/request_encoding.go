@@ -0,0 +1,102 @@
+package service
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxDecompressedBodySize bounds a compressed request body once
+// decompressed, so a small upload cannot expand without limit.
+const DefaultMaxDecompressedBodySize = 32 << 20
+
+var (
+	// ErrBodyTooLarge is returned when a request body, once decompressed,
+	// exceeds the service's limit. The service answers 413.
+	ErrBodyTooLarge = errors.New("request body too large")
+	// ErrUnsupportedContentEncoding is returned for a request body encoded
+	// with something other than gzip or deflate. The service answers 415.
+	ErrUnsupportedContentEncoding = errors.New("unsupported content encoding")
+)
+
+// SetMaxDecompressedBodySize sets the largest size a gzip or deflate
+// request body may decompress to. The default is
+// DefaultMaxDecompressedBodySize.
+func (s *Service) SetMaxDecompressedBodySize(n int64) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxDecompressedBody = n
+	return s
+}
+
+// decodeRequestBody replaces a gzip or deflate encoded body of r with one
+// that decompresses it, so parameters and handlers see the plain bytes.
+func (s *Service) decodeRequestBody(r *http.Request) error {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" || r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	if encoding != "gzip" && encoding != "x-gzip" && encoding != "deflate" {
+		return fmt.Errorf("%w: %s", ErrUnsupportedContentEncoding, encoding)
+	}
+
+	s.mu.RLock()
+	limit := s.maxDecompressedBody
+	s.mu.RUnlock()
+	if limit <= 0 {
+		limit = DefaultMaxDecompressedBodySize
+	}
+
+	r.Body = &decodedBody{source: r.Body, encoding: encoding, remaining: limit}
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	return nil
+}
+
+// decodedBody decompresses source, creating the decompressor on the first
+// Read so that waiting for the compressed header honours the same context
+// handling as any other body read.
+type decodedBody struct {
+	source    io.ReadCloser
+	encoding  string
+	reader    io.Reader
+	remaining int64
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.reader == nil {
+		var err error
+		if b.encoding == "deflate" {
+			b.reader, err = zlib.NewReader(b.source)
+		} else {
+			b.reader, err = gzip.NewReader(b.source)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s body: %w", b.encoding, err)
+		}
+	}
+
+	if b.remaining <= 0 {
+		// allow a clean EOF exactly at the limit
+		var probe [1]byte
+		if n, err := b.reader.Read(probe[:]); n > 0 || err == nil {
+			return 0, ErrBodyTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.reader.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *decodedBody) Close() error {
+	return b.source.Close()
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func compressBody(t *testing.T, encoding string, data []byte) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	if encoding == "deflate" {
+		w = zlib.NewWriter(&buf)
+	} else {
+		w = gzip.NewWriter(&buf)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// postEncoded posts body with the given Content-Encoding as JSON to the
+// echo route of s.
+func postEncoded(s *Service, encoding string, body io.Reader) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/echo", body)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Content-Encoding", encoding)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, r)
+	return rec
+}
+
+func echoService() *Service {
+	s := NewServiceBuilder().Build()
+	s.RegisterRoutePOST("/echo", func(w http.ResponseWriter, r *http.Request) {
+		into, err := HttpParameterInto[map[string]string](r)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		WriteT(w, map[string]interface{}{"param": HttpParameters(r)["name"], "into": into["name"]})
+	})
+	return s
+}
+
+func TestCompressedRequestBodies(t *testing.T) {
+	s := echoService()
+	for _, encoding := range []string{"gzip", "deflate"} {
+		rec := postEncoded(s, encoding, compressBody(t, encoding, []byte(`{"name": "ada"}`)))
+		if rec.Code != http.StatusOK || rec.Body.String() != `{"into":"ada","param":"ada"}` {
+			t.Errorf("%s: status %d, body %s", encoding, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestCompressedBodyIsBounded(t *testing.T) {
+	s := echoService().SetMaxDecompressedBodySize(1024)
+	bomb := compressBody(t, "gzip", []byte(`{"name": "`+strings.Repeat("a", 1<<20)+`"}`))
+	if bomb.Len() > 4096 {
+		t.Fatalf("compressed size %d does not show the expansion", bomb.Len())
+	}
+	if rec := postEncoded(s, "gzip", bomb); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", rec.Code)
+	}
+}
+
+func TestRequestBodyEncodingErrors(t *testing.T) {
+	s := echoService()
+	if rec := postEncoded(s, "br", strings.NewReader("...")); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("br: status = %d, want 415", rec.Code)
+	}
+	if rec := postEncoded(s, "gzip", strings.NewReader(`{"name": "not gzip"}`)); rec.Code != http.StatusBadRequest {
+		t.Errorf("corrupt gzip: status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,327 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Moonlight-Companies/goconvert/glob"
+)
+
+// linearResolve is the reference for resolvePath: every route in
+// precedence order, longest pattern first and then registration order,
+// exact patterns before globs, without any index.
+func linearResolve(routes []*serviceHttpRouteInfo, method, path string, fold bool) (*serviceHttpRouteInfo, map[string]string) {
+	ordered := slices.Clone(routes)
+	slices.SortStableFunc(ordered, func(a, b *serviceHttpRouteInfo) int {
+		return len(b.URI) - len(a.URI)
+	})
+	return scanRoutes(ordered, method, path, fold)
+}
+
+// scanRoutes tries every route of ordered, which is in resolution order,
+// exact patterns first, then globs.
+func scanRoutes(ordered []*serviceHttpRouteInfo, method, path string, fold bool) (*serviceHttpRouteInfo, map[string]string) {
+	lowerPath := strings.ToLower(path)
+	for _, route := range ordered {
+		exact := route.URI == path
+		if fold {
+			exact = route.lowerURI == lowerPath
+		}
+		if exact && route.MatchMethod(method) {
+			return route, nil
+		}
+	}
+	for _, route := range ordered {
+		if !route.MatchMethod(method) {
+			continue
+		}
+		if !fold {
+			if matched, named_parameters := route.matchPath(path); matched {
+				return route, named_parameters
+			}
+			continue
+		}
+		matched, named_parameters, err := glob.MatchNamed(route.lowerURI, lowerPath)
+		if err == nil && matched {
+			return route, restoreParameterCase(route.lowerURI, lowerPath, path, named_parameters)
+		}
+	}
+	return nil, nil
+}
+
+var resolveSegments = []string{"api", "users", "items", "v1", "files", "x", "Users"}
+
+// randomPattern builds a route pattern from literal, named parameter and
+// wildcard segments, sometimes with a leading "*" prefix.
+func randomPattern(rng *rand.Rand) string {
+	var b strings.Builder
+	if rng.Intn(3) == 0 {
+		b.WriteString("*")
+		if rng.Intn(3) == 0 {
+			// a literal glued to the wildcard is not a whole segment
+			b.WriteString(resolveSegments[rng.Intn(len(resolveSegments))])
+		}
+	}
+	for range 1 + rng.Intn(4) {
+		b.WriteString("/")
+		switch rng.Intn(7) {
+		case 0:
+			b.WriteString(":id")
+		case 1:
+			b.WriteString("*")
+		case 2:
+			b.WriteString(resolveSegments[rng.Intn(len(resolveSegments))] + "*")
+		default:
+			b.WriteString(resolveSegments[rng.Intn(len(resolveSegments))])
+		}
+	}
+	return b.String()
+}
+
+// randomPath builds a request path from the same literals plus values a
+// parameter or wildcard would take.
+func randomPath(rng *rand.Rand) string {
+	var b strings.Builder
+	if rng.Intn(4) == 0 {
+		b.WriteString("/service/name")
+	}
+	for range 1 + rng.Intn(5) {
+		b.WriteString("/")
+		switch rng.Intn(6) {
+		case 0, 1:
+			fmt.Fprintf(&b, "%d", rng.Intn(100))
+		case 2:
+			b.WriteString(strings.ToUpper(resolveSegments[rng.Intn(len(resolveSegments))]))
+		default:
+			b.WriteString(resolveSegments[rng.Intn(len(resolveSegments))])
+		}
+	}
+	return b.String()
+}
+
+func TestResolveRouteMatchesLinearScan(t *testing.T) {
+	captureLog(t)
+	rng := rand.New(rand.NewSource(1))
+	methods := [][]string{{"GET"}, {"POST"}, {"*"}, {"PUT", "PATCH"}}
+
+	for corpus := range 40 {
+		fold := corpus%2 == 1
+		s := NewServiceBuilder().Build().SetCaseInsensitivePaths(fold)
+		for range 60 {
+			_, err := s.RegisterRouteMethodsE(randomPattern(rng), methods[rng.Intn(len(methods))], noopHandler)
+			if err != nil && !errors.Is(err, ErrDuplicateRoute) {
+				t.Fatal(err)
+			}
+		}
+		routes := slices.Clone(s.routes)
+
+		for range 500 {
+			method := []string{"GET", "POST", "PATCH", "DELETE"}[rng.Intn(4)]
+			path := randomPath(rng)
+			if rng.Intn(5) == 0 {
+				// an exact hit on a registered pattern's text
+				path = routes[rng.Intn(len(routes))].URI
+			}
+
+			want, wantParams := linearResolve(routes, method, path, fold)
+			r := httptest.NewRequest(method, "/", nil)
+			r.URL.Path = path
+			got, gotParams, found := s.ResolveRoute(r)
+			if found != (want != nil) || got != want {
+				t.Fatalf("corpus %d: %s %s resolved to %v, linear scan %v", corpus, method, path, routeURI(got), routeURI(want))
+			}
+			if !maps.Equal(gotParams, wantParams) {
+				t.Fatalf("corpus %d: %s %s parameters %v, linear scan %v", corpus, method, path, gotParams, wantParams)
+			}
+		}
+	}
+}
+
+func TestRouteAnchors(t *testing.T) {
+	for pattern, want := range map[string][]string{
+		"*/mul/:a/:b":     {"mul"},
+		"*/add":           {"add"},
+		"*/events/*":      {"events"},
+		"/users/:id":      {"users"},
+		"/api/v1/items/*": {"api", "v1", "items"},
+		"*/files/r1/*":    {"files", "r1"},
+		"*api/x/:id":      {"x"},
+		"*api/:id":        nil,
+		"/api*":           nil,
+		"/api/v*":         {"api"},
+		"*":               nil,
+		"/:id":            nil,
+	} {
+		if got := routeAnchors(pattern); !slices.Equal(got, want) {
+			t.Errorf("routeAnchors(%q) = %q, want %q", pattern, got, want)
+		}
+	}
+}
+
+func TestRouteIndexUsesLeastSharedAnchor(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	for i := range 3 {
+		s.RegisterRouteGET(fmt.Sprintf("*/r%d/items/:id", i), noopHandler)
+	}
+	if got := len(s.globRoutes.anchored["items"]); got != 0 {
+		t.Errorf("%d routes under items, want each under its own r segment", got)
+	}
+	for i := range 3 {
+		if got := len(s.globRoutes.anchored[fmt.Sprintf("r%d", i)]); got != 1 {
+			t.Errorf("%d routes under r%d, want 1", got, i)
+		}
+	}
+}
+
+func routeURI(route *serviceHttpRouteInfo) string {
+	if route == nil {
+		return "no route"
+	}
+	return route.Method + " " + route.URI
+}
+
+// benchmarkService registers n routes shaped like the services in cmd,
+// which match behind any load balancer prefix with a leading "*": a
+// resource per prefix with a list, an item and a catch-all route. It
+// returns the service and request paths hitting the last registered
+// resource, which a linear scan reaches last among equal lengths, and a
+// path matching nothing.
+func benchmarkService(b *testing.B, n int) (*Service, []string) {
+	b.Helper()
+	s := NewServiceBuilder().Build()
+	i := 0
+	for ; len(s.routes) < n; i++ {
+		for _, pattern := range []string{"*/r%d/items", "*/r%d/items/:id", "*/r%d/files/*"} {
+			if len(s.routes) < n {
+				s.RegisterRouteGET(fmt.Sprintf(pattern, i), noopHandler)
+			}
+		}
+	}
+	last := i - 1
+	return s, []string{
+		fmt.Sprintf("/service/name/r%d/items", last),
+		fmt.Sprintf("/service/name/r%d/items/42", last),
+		fmt.Sprintf("/service/name/r%d/files/a/b", last),
+		"/service/name/missing",
+	}
+}
+
+func benchmarkResolveRoute(b *testing.B, n int) {
+	s, paths := benchmarkService(b, n)
+	rs := make([]*http.Request, len(paths))
+	for i, path := range paths {
+		rs[i] = httptest.NewRequest("GET", path, nil)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.ResolveRoute(rs[i%len(rs)])
+	}
+}
+
+func BenchmarkResolveRoute10(b *testing.B)   { benchmarkResolveRoute(b, 10) }
+func BenchmarkResolveRoute100(b *testing.B)  { benchmarkResolveRoute(b, 100) }
+func BenchmarkResolveRoute1000(b *testing.B) { benchmarkResolveRoute(b, 1000) }
+
+// benchmarkLinearResolve is the baseline for benchmarkResolveRoute: the
+// same routes and paths tried against every route in resolution order.
+func benchmarkLinearResolve(b *testing.B, n int) {
+	s, paths := benchmarkService(b, n)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scanRoutes(s.routes, "GET", paths[i%len(paths)], false)
+	}
+}
+
+func BenchmarkLinearResolve10(b *testing.B)   { benchmarkLinearResolve(b, 10) }
+func BenchmarkLinearResolve100(b *testing.B)  { benchmarkLinearResolve(b, 100) }
+func BenchmarkLinearResolve1000(b *testing.B) { benchmarkLinearResolve(b, 1000) }
+
+func TestRoutesResolveLongestFirstThenRegistrationOrder(t *testing.T) {
+	captureLog(t)
+	s := NewServiceBuilder().Build()
+	for _, pattern := range []string{"/a/*", "/a/:id/x", "/a/:id/*", "*", "/a/:b"} {
+		s.RegisterRouteGET(pattern, noopHandler)
+	}
+
+	var got []string
+	for _, route := range s.routes {
+		got = append(got, route.URI)
+	}
+	want := []string{"/a/:id/x", "/a/:id/*", "/a/:b", "/a/*", "*"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("routes = %v, want %v", got, want)
+	}
+
+	for path, pattern := range map[string]string{
+		"/a/1/x": "/a/:id/x",
+		"/a/1/y": "/a/:id/*",
+		"/a/1":   "/a/:b",
+		"/b":     "*",
+	} {
+		route, _, _ := s.ResolveRoute(httptest.NewRequest("GET", path, nil))
+		if routeURI(route) != "GET "+pattern {
+			t.Errorf("%s resolved to %s, want %s", path, routeURI(route), pattern)
+		}
+	}
+}
+
+func BenchmarkRegisterRoutes1000(b *testing.B) {
+	patterns := make([]string, 1000)
+	for i := range patterns {
+		patterns[i] = fmt.Sprintf("/api/%s/r%d/:id", strings.Repeat("v", i%7), i)
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s := NewServiceBuilder().Build()
+		for _, pattern := range patterns {
+			s.RegisterRouteGET(pattern, noopHandler)
+		}
+	}
+}
+
+func TestShadowCheckMatchesLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	methods := [][]string{{"GET"}, {"POST"}, {"*"}}
+
+	for corpus := range 10 {
+		rec := &recordingLogger{}
+		s := NewServiceBuilder().SetLogger(rec).Build()
+		var want []string
+		for range 80 {
+			pattern, method := randomPattern(rng), methods[rng.Intn(len(methods))]
+			if len(s.exactRoutes[pattern]) > 0 {
+				continue
+			}
+			for _, route := range s.routes {
+				if route.shadows(pattern, method) {
+					want = append(want, fmt.Sprintf("route %s %s is shadowed by %s %s", method[0], pattern, route.Method, route.URI))
+				}
+			}
+			s.RegisterRouteMethods(pattern, method, noopHandler)
+		}
+
+		var got []string
+		for _, line := range rec.lines {
+			if strings.Contains(line, "is shadowed by") {
+				got = append(got, line)
+			}
+		}
+		slices.Sort(got)
+		slices.Sort(want)
+		if !slices.Equal(got, want) {
+			t.Fatalf("corpus %d: logged %q, linear scan %q", corpus, got, want)
+		}
+	}
+}
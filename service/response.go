@@ -0,0 +1,101 @@
+package service
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// responseWriter records the status code and body size written by a
+// handler. It forwards Flush and Hijack and exposes Unwrap so streaming
+// handlers, websocket upgrades, and http.ResponseController keep working
+// through it.
+type responseWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+	pretty  bool
+	errors  *errorRendering
+
+	onEnd   func(status int)
+	endOnce sync.Once
+
+	// onWriteError is called for the first failed write of the response.
+	onWriteError func(err error)
+	writeFailed  bool
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: w}
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	if err != nil && !w.writeFailed {
+		w.writeFailed = true
+		if w.onWriteError != nil {
+			w.onWriteError(err)
+		}
+	}
+	return n, err
+}
+
+func (w *responseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hands the connection to the handler, as websocket libraries that
+// assert http.Hijacker on the writer expect.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hijacker.Hijack()
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// prettyJSON reports whether WriteT should indent its output.
+func (w *responseWriter) prettyJSON() bool {
+	return w.pretty
+}
+
+func (w *responseWriter) errorRendering() *errorRendering {
+	return w.errors
+}
+
+// end runs the OnRequestEnd hook, at most once per request.
+func (w *responseWriter) end() {
+	w.endOnce.Do(func() {
+		if w.onEnd != nil {
+			w.onEnd(w.Status())
+		}
+	})
+}
+
+func (w *responseWriter) streamStarted() {
+	w.end()
+}
+
+// Status returns the status code written so far, 200 if only a body was
+// written, or 0 if nothing was written.
+func (w *responseWriter) Status() int {
+	return w.status
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestResponseWriterHijacks(t *testing.T) {
+	s, _ := startTestService(t, NewServiceBuilder())
+	s.RegisterRouteGET("/upgrade", func(w http.ResponseWriter, r *http.Request) {
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			WriteErrorCode(w, http.StatusInternalServerError, http.ErrNotSupported)
+			return
+		}
+		conn, buf, err := hijacker.Hijack()
+		if err != nil {
+			WriteErrorCode(w, http.StatusInternalServerError, err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\nhijacked")
+		buf.Flush()
+	})
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /upgrade HTTP/1.1\r\nHost: test\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+	rest, _ := io.ReadAll(reader)
+	if string(rest) != "hijacked" {
+		t.Fatalf("read %q after the upgrade, want hijacked", rest)
+	}
+}
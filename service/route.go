@@ -0,0 +1,272 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SetTimeout bounds how long the handler may run. When d elapses the
+// handler's context is cancelled and, if nothing has been written yet, the
+// client receives 504 Gateway Timeout. A handler that already started its
+// response is cut off and further writes fail with http.ErrHandlerTimeout.
+// Zero disables the timeout.
+func (s *serviceHttpRouteInfo) SetTimeout(d time.Duration) *serviceHttpRouteInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeout = d
+	return s
+}
+
+// RequireJSON rejects requests to this route that carry a body whose
+// Content-Type is not JSON with 415 Unsupported Media Type, instead of
+// letting the handler run with the body silently unparsed.
+func (s *serviceHttpRouteInfo) RequireJSON() *serviceHttpRouteInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requireJSON = true
+	return s
+}
+
+// AllowInvalidJSON lets requests whose JSON body does not parse reach the
+// handler, which then sees no body parameters, instead of answering 400.
+// Use it for routes that read the raw body themselves.
+func (s *serviceHttpRouteInfo) AllowInvalidJSON() *serviceHttpRouteInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tolerateInvalidJSON = true
+	return s
+}
+
+// SkipBodyParsing leaves the request body unread for the route's handler,
+// which then streams r.Body itself, for example to proxy it. Parameters
+// still come from the query and the path, but not from the body, and
+// Content-Encoding is not decoded. Multipart bodies are left unread either
+// way, so HttpSaveUpload does not need it.
+func (s *serviceHttpRouteInfo) SkipBodyParsing() *serviceHttpRouteInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipBody = true
+	return s
+}
+
+// parseOptions returns how parameters treats the route's request body.
+func (s *serviceHttpRouteInfo) parseOptions() parseOptions {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return parseOptions{
+		tolerateInvalidJSON: s.tolerateInvalidJSON,
+		skipBody:            s.skipBody,
+	}
+}
+
+// checkContentType writes 415 and returns false if r does not satisfy the
+// route's content type requirement. Requests without a body are allowed.
+func (s *serviceHttpRouteInfo) checkContentType(w http.ResponseWriter, r *http.Request) bool {
+	s.mu.RLock()
+	requireJSON := s.requireJSON
+	s.mu.RUnlock()
+
+	if !requireJSON || r.ContentLength == 0 || isJSONMediaType(mediaType(r)) {
+		return true
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "none"
+	}
+	WriteErrorCode(w, http.StatusUnsupportedMediaType,
+		fmt.Errorf("unsupported content type %s, expected application/json", contentType))
+	return false
+}
+
+// mediaType returns the lowercased media type of r's Content-Type without
+// parameters such as charset, or "" if it is missing.
+func mediaType(r *http.Request) string {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return ""
+	}
+	parsed, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// malformed parameters, keep the type itself
+		parsed, _, _ = strings.Cut(contentType, ";")
+		return strings.ToLower(strings.TrimSpace(parsed))
+	}
+	return parsed
+}
+
+// isJSONMediaType reports whether mediaType is application/json or a
+// structured +json type such as application/problem+json.
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// handle runs the route's handler with the route's options applied.
+func (s *serviceHttpRouteInfo) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	timeout := s.timeout
+	fn := s.Fn
+	if s.cache != nil {
+		fn = s.cache.wrap(fn)
+	}
+	if s.idempotency != nil {
+		fn = s.idempotency.wrap(fn)
+	}
+	fn = chainMiddleware(fn, s.middleware)
+	preloadLinks := s.preloadLinks
+	s.mu.RUnlock()
+
+	addPreloadLinks(w, preloadLinks)
+
+	if timeout > 0 {
+		s.handleTimeout(fn, w, r, timeout)
+		return
+	}
+
+	fn(w, r)
+}
+
+// handleTimeout runs the handler in its own goroutine so a 504 can be sent
+// when it overruns, similar to http.TimeoutHandler but without buffering the
+// response, so streamed output still reaches the client as it is written.
+func (s *serviceHttpRouteInfo) handleTimeout(fn ServiceHandleFunc, w http.ResponseWriter, r *http.Request, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	tw := &timeoutWriter{w: w, header: make(http.Header), ctx: ctx}
+	done := make(chan struct{})
+	panicked := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panicked <- p
+			}
+		}()
+		fn(tw, r)
+		close(done)
+	}()
+
+	select {
+	case p := <-panicked:
+		panic(p)
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if ctx.Err() == nil {
+		// copy headers set without writing a response, which net/http
+		// sends with its implicit 200, and headers set after the response
+		// started, which are trailers
+		dst := tw.w.Header()
+		for k, v := range tw.header {
+			dst[k] = v
+		}
+		return
+	}
+
+	tw.timedOut = true
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		// the client went away, there is nobody to answer
+		return
+	}
+	if !tw.wroteHeader {
+		WriteErrorCode(w, http.StatusGatewayTimeout, errors.New("handler timeout"))
+		return
+	}
+	select {
+	case <-done:
+	default:
+		s.Logger.Errorln("handler timed out after a partial response", r.Method, r.URL.Path)
+	}
+}
+
+// timeoutWriter passes writes through to w until the handler's context is
+// done, after which they fail. It keeps its own header map so a late
+// handler cannot race the timeout response on w's headers.
+type timeoutWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	ctx         context.Context
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, for
+// example to extend the write deadline of a slow response.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
+
+func (tw *timeoutWriter) prettyJSON() bool {
+	return wantsPrettyJSON(tw.w)
+}
+
+func (tw *timeoutWriter) errorRendering() *errorRendering {
+	return errorRenderingOf(tw.w)
+}
+
+func (tw *timeoutWriter) streamStarted() {
+	markStreamStarted(tw.w)
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(statusCode int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expiredLocked() || tw.wroteHeader {
+		return
+	}
+	tw.writeHeaderLocked(statusCode)
+}
+
+// expiredLocked reports whether the response belongs to the timeout now.
+// The context is checked as well, so a handler that wakes up on its
+// cancellation cannot write ahead of the 504. The caller holds tw.mu.
+func (tw *timeoutWriter) expiredLocked() bool {
+	return tw.timedOut || tw.ctx.Err() != nil
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(statusCode int) {
+	dst := tw.w.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+	tw.wroteHeader = true
+	tw.w.WriteHeader(statusCode)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expiredLocked() {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	return tw.w.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expiredLocked() {
+		return
+	}
+	if flusher, ok := tw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// routeAnchors returns the literal segments every path matching pattern
+// contains, such as "mul" for "*/mul/:a/:b" or "users" for "/users/:id".
+// They are the complete segments of the literal text at the start of the
+// pattern, or right after a leading "*". A segment is complete when a "/"
+// precedes it and a "/" or the end of the pattern follows it, so a
+// matching path has it between slashes.
+func routeAnchors(pattern string) []string {
+	rest := pattern
+	if literalPrefix(pattern) == "" && strings.HasPrefix(pattern, "*") {
+		rest = pattern[1:]
+	}
+	run := literalPrefix(rest)
+	atEnd := run == rest
+
+	var anchors []string
+	parts := strings.Split(run, "/")
+	for i := 1; i < len(parts); i++ {
+		last := i == len(parts)-1
+		if parts[i] != "" && (!last || atEnd) {
+			anchors = append(anchors, parts[i])
+		}
+	}
+	return anchors
+}
+
+// routeIndex narrows the glob pass of route resolution to the routes that
+// can match a path. Each route is bucketed under one of its anchors, see
+// routeAnchors, the one with the fewest routes so far, so "api" in
+// "/api/users/:id" only holds the first route of many sharing it. Only
+// buckets for segments of the path, and the routes without an anchor, are
+// tried. Patterns without wildcards are left out, as only the exact
+// pass can match them. Each list is kept in resolution order.
+type routeIndex struct {
+	anchored   map[string][]*serviceHttpRouteInfo
+	unanchored []*serviceHttpRouteInfo
+}
+
+// routePrecedes reports whether a is tried before b: longer patterns
+// first, then registration order.
+func routePrecedes(a, b *serviceHttpRouteInfo) bool {
+	if len(a.URI) != len(b.URI) {
+		return len(a.URI) > len(b.URI)
+	}
+	return a.order < b.order
+}
+
+// insertRoute adds route to list in resolution order.
+func insertRoute(list []*serviceHttpRouteInfo, route *serviceHttpRouteInfo) []*serviceHttpRouteInfo {
+	at := sort.Search(len(list), func(i int) bool {
+		return routePrecedes(route, list[i])
+	})
+	return slices.Insert(list, at, route)
+}
+
+// add indexes route under an anchor of pattern, which is its URI or, for
+// case insensitive matching, its lowerURI.
+func (x *routeIndex) add(route *serviceHttpRouteInfo, pattern string) {
+	if literalPrefix(pattern) == pattern {
+		return
+	}
+	anchors := routeAnchors(pattern)
+	if len(anchors) == 0 {
+		x.unanchored = insertRoute(x.unanchored, route)
+		return
+	}
+	anchor := anchors[0]
+	for _, candidate := range anchors[1:] {
+		if len(x.anchored[candidate]) < len(x.anchored[anchor]) {
+			anchor = candidate
+		}
+	}
+	if x.anchored == nil {
+		x.anchored = make(map[string][]*serviceHttpRouteInfo)
+	}
+	x.anchored[anchor] = insertRoute(x.anchored[anchor], route)
+}
+
+// candidates returns the indexed routes that may match path, in
+// resolution order, appended to buf.
+func (x *routeIndex) candidates(path string, buf []*serviceHttpRouteInfo) []*serviceHttpRouteInfo {
+	result := append(buf, x.unanchored...)
+	sources := 0
+	if len(x.unanchored) > 0 {
+		sources++
+	}
+	for rest := path; rest != ""; {
+		var segment string
+		segment, rest, _ = strings.Cut(rest, "/")
+		if bucket := x.anchored[segment]; len(bucket) > 0 {
+			result = append(result, bucket...)
+			sources++
+		}
+	}
+	if sources < 2 {
+		return result
+	}
+
+	// a segment repeated in the path adds its bucket again
+	slices.SortFunc(result, func(a, b *serviceHttpRouteInfo) int {
+		switch {
+		case a == b:
+			return 0
+		case routePrecedes(a, b):
+			return -1
+		}
+		return 1
+	})
+	return slices.Compact(result)
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"html/template"
+	"net/http"
+	"slices"
+	"sort"
+)
+
+// RouteInfo describes a registered route for listings and documentation.
+// It carries no handler internals.
+type RouteInfo struct {
+	Pattern string   `json:"pattern"`
+	Methods []string `json:"methods"`
+	Order   int      `json:"order"`
+	Summary string   `json:"summary,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+// Routes returns the registered routes in registration order. Order is the
+// position among all routes ever registered on the service.
+func (s *Service) Routes() []RouteInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	routes := make([]RouteInfo, 0, len(s.routes))
+	for _, route := range s.routes {
+		route.mu.RLock()
+		routes = append(routes, RouteInfo{
+			Pattern: route.URI,
+			Methods: slices.Clone(route.methods),
+			Order:   route.order,
+			Summary: route.summary,
+			Tags:    slices.Clone(route.tags),
+		})
+		route.mu.RUnlock()
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Order < routes[j].Order
+	})
+	return routes
+}
+
+var routeTableTemplate = template.Must(template.New("routes").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Routes</title></head>
+<body>
+<table>
+<tr><th>#</th><th>Methods</th><th>Pattern</th></tr>
+{{range .}}<tr><td>{{.Order}}</td><td>{{range $i, $m := .Methods}}{{if $i}}, {{end}}{{$m}}{{end}}</td><td><code>{{.Pattern}}</code></td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// RegisterRouteTable serves the routing table at uri, as an HTML table to
+// browsers and as JSON otherwise. It exposes the service's API surface, so
+// only register it where that is acceptable, for example behind Use.
+func (s *Service) RegisterRouteTable(uri string) *serviceHttpRouteInfo {
+	return s.RegisterRouteGET(uri, func(w http.ResponseWriter, r *http.Request) {
+		routes := s.Routes()
+		if containsAcceptType(r.Header.Get("Accept"), "text/html") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if err := routeTableTemplate.Execute(w, routes); err != nil {
+				s.Logger.Errorln("route table", err)
+			}
+			return
+		}
+		WriteT(w, routes)
+	})
+}
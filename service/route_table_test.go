@@ -0,0 +1,56 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRoutesListsRegistrationOrder(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	s.RegisterRouteGET("/a", noopHandler)
+	s.RegisterRouteMethods("*/users/:id", []string{"PUT", "PATCH"}, noopHandler).Describe("Update a user", "users")
+	s.RegisterRoutePOST("/longer/path", noopHandler)
+
+	want := []RouteInfo{
+		{Pattern: "/a", Methods: []string{"GET"}, Order: 0},
+		{Pattern: "*/users/:id", Methods: []string{"PUT", "PATCH"}, Order: 1, Summary: "Update a user", Tags: []string{"users"}},
+		{Pattern: "/longer/path", Methods: []string{"POST"}, Order: 2},
+	}
+	if got := s.Routes(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Routes() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRegisterRouteTable(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	s.RegisterRouteGET("/users/:id", noopHandler)
+	s.RegisterRouteTable("/routes")
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/routes", nil))
+	var routes []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &routes); err != nil {
+		t.Fatalf("JSON table: %v: %s", err, rec.Body)
+	}
+	if len(routes) != 2 || routes[0]["pattern"] != "/users/:id" || routes[1]["pattern"] != "/routes" {
+		t.Errorf("JSON table = %v", routes)
+	}
+	for _, route := range routes {
+		for key := range route {
+			if key != "pattern" && key != "methods" && key != "order" {
+				t.Errorf("route exposes %q", key)
+			}
+		}
+	}
+
+	html := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/routes", nil)
+	r.Header.Set("Accept", "text/html,application/xhtml+xml")
+	s.ServeHTTP(html, r)
+	if !strings.HasPrefix(html.Header().Get("Content-Type"), "text/html") || !strings.Contains(html.Body.String(), "<code>/users/:id</code>") {
+		t.Errorf("HTML table: %s %s", html.Header().Get("Content-Type"), html.Body)
+	}
+}
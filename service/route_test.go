@@ -0,0 +1,329 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func noopHandler(w http.ResponseWriter, r *http.Request) {}
+
+func TestRegisterRouteERejectsDuplicates(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	if _, err := s.RegisterRouteE("*/add", "POST", noopHandler); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RegisterRouteE("*/add", "POST", noopHandler); !errors.Is(err, ErrDuplicateRoute) {
+		t.Fatalf("err = %v, want ErrDuplicateRoute", err)
+	}
+	if _, err := s.RegisterRouteE("*/add", "GET", noopHandler); err != nil {
+		t.Fatalf("another method on the same pattern: %v", err)
+	}
+	if _, err := s.RegisterRouteMethodsE("*/add", []string{"PUT", "GET"}, noopHandler); !errors.Is(err, ErrDuplicateRoute) {
+		t.Fatalf("overlapping methods: err = %v, want ErrDuplicateRoute", err)
+	}
+}
+
+func TestRegisterRoutePanicsOnDuplicate(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	s.RegisterRouteGET("/users", noopHandler)
+
+	defer func() {
+		err, _ := recover().(error)
+		if !errors.Is(err, ErrDuplicateRoute) {
+			t.Fatalf("recovered %v, want ErrDuplicateRoute", err)
+		}
+	}()
+	s.RegisterRouteGET("/users", noopHandler)
+}
+
+func TestShadowedRouteIsLogged(t *testing.T) {
+	rec := &recordingLogger{}
+	s := NewServiceBuilder().SetLogger(rec).Build()
+	s.RegisterRouteGET("/api/*", noopHandler)
+	s.RegisterRouteGET("/api/x", noopHandler)
+
+	if !rec.contains("route GET /api/x is shadowed by GET /api/*") {
+		t.Fatalf("shadowed route was not logged, got %q", rec.lines)
+	}
+}
+
+func TestHttpMatchedRouteIsThePattern(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	matched := make(chan string, 1)
+	s.RegisterRouteGET("*/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		matched <- HttpMatchedRoute(r)
+	})
+
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/users/42", nil))
+	if got := <-matched; got != "*/users/:id" {
+		t.Fatalf("HttpMatchedRoute = %q, want */users/:id", got)
+	}
+
+	s.SetNotFoundHandler(func(w http.ResponseWriter, r *http.Request) {
+		matched <- HttpMatchedRoute(r)
+	})
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nowhere", nil))
+	if got := <-matched; got != "" {
+		t.Fatalf("HttpMatchedRoute = %q for an unmatched path, want empty", got)
+	}
+}
+
+func TestRouteTimeoutAnswers504AndCancels(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	cancelled := make(chan error, 1)
+	s.RegisterRouteGET("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			cancelled <- r.Context().Err()
+		case <-time.After(time.Second):
+			cancelled <- nil
+		}
+		WriteRaw(w, "text/plain", "late")
+	}).SetTimeout(50 * time.Millisecond)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504", w.Code)
+	}
+	if err := <-cancelled; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("handler context error = %v, want DeadlineExceeded", err)
+	}
+	if strings.Contains(w.Body.String(), "late") {
+		t.Fatal("late write reached the response")
+	}
+}
+
+func TestRouteTimeoutKeepsPartialResponse(t *testing.T) {
+	s := NewServiceBuilder().SetLogger(&recordingLogger{}).Build()
+	s.RegisterRouteGET("/partial", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		<-r.Context().Done()
+	}).SetTimeout(50 * time.Millisecond)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/partial", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "partial" {
+		t.Fatalf("got %d %q, want the partial 200", w.Code, w.Body.String())
+	}
+}
+
+func TestRouteTimeoutKeepsHeadersWithoutBody(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	s.RegisterRouteGET("/headers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Result", "ok")
+	}).SetTimeout(time.Second)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/headers", nil))
+	if got := w.Header().Get("X-Result"); got != "ok" {
+		t.Fatalf("X-Result = %q, want ok", got)
+	}
+}
+
+func TestRouteTimeoutWriterUnwraps(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	s.RegisterRouteGET("/deadline", func(w http.ResponseWriter, r *http.Request) {
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(time.Minute)); err != nil {
+			WriteErrorCode(w, http.StatusInternalServerError, err)
+			return
+		}
+		WriteRaw(w, "text/plain", "ok")
+	}).SetTimeout(time.Second)
+
+	resp, err := http.Get(base + "/deadline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestRegisterRouteMethodsServesEachMethod(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	var methods []string
+	route := s.RegisterRouteMethods("/items/:id", []string{"PUT", "PATCH"}, func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+	})
+	if route.Method != "PUT,PATCH" {
+		t.Errorf("Method = %q, want PUT,PATCH", route.Method)
+	}
+
+	for _, method := range []string{"PUT", "PATCH", "POST"} {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/items/1", nil))
+	}
+	if got := strings.Join(methods, " "); got != "PUT PATCH" {
+		t.Fatalf("handler ran for %q, want PUT PATCH", got)
+	}
+}
+
+func TestRequireJSON(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	s.RegisterRoutePOST("/items", func(w http.ResponseWriter, r *http.Request) {
+		WriteT(w, HttpParameters(r))
+	}).RequireJSON()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"json", "application/json", `{"a":1}`, http.StatusOK},
+		{"json with charset", "application/json; charset=utf-8", `{"a":1}`, http.StatusOK},
+		{"json suffix", "application/merge-patch+json", `{"a":1}`, http.StatusOK},
+		{"no body", "", "", http.StatusOK},
+		{"missing", "", `{"a":1}`, http.StatusUnsupportedMediaType},
+		{"wrong", "text/plain", `{"a":1}`, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/items", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, r)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusUnsupportedMediaType && !strings.Contains(rec.Body.String(), "expected application/json") {
+				t.Errorf("body = %q, want it to name application/json", rec.Body)
+			}
+		})
+	}
+}
+
+func TestFormWithCharsetIsParsed(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	got := make(chan any, 1)
+	s.RegisterRoutePOST("/form", func(w http.ResponseWriter, r *http.Request) {
+		got <- HttpParameters(r)["name"]
+	})
+
+	r := httptest.NewRequest("POST", "/form", strings.NewReader("name=ada"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
+	s.ServeHTTP(httptest.NewRecorder(), r)
+	if name := <-got; name != "ada" {
+		t.Fatalf("name = %v, want ada", name)
+	}
+}
+
+func TestRegisterRoutesWhileServing(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	s.RegisterRouteGET("/static", noopHandler)
+
+	const plugins = 50
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range plugins {
+			s.RegisterRouteGET(fmt.Sprintf("/plugin/%d/*", i), func(w http.ResponseWriter, r *http.Request) {
+				WriteT(w, i)
+			})
+			s.RegisterRouteMethods(fmt.Sprintf("/plugin/%d/items/:id", i), []string{"PUT", "PATCH"}, noopHandler).
+				SetCache(time.Minute)
+		}
+	}()
+
+	errs := make(chan error, 4*plugins)
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range plugins {
+				for _, path := range []string{"/static", fmt.Sprintf("/plugin/%d/x", i)} {
+					resp, err := http.Get(base + path)
+					if err != nil {
+						errs <- err
+						continue
+					}
+					resp.Body.Close()
+					// a plugin route may not be registered yet
+					if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+						errs <- fmt.Errorf("GET %s: status %d", path, resp.StatusCode)
+					}
+				}
+				s.Stats()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// once registered, every plugin route resolves
+	for i := range plugins {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest("GET", fmt.Sprintf("/plugin/%d/x", i), nil))
+		if rec.Body.String() != fmt.Sprint(i) {
+			t.Fatalf("plugin %d answered %q", i, rec.Body)
+		}
+	}
+}
+
+// trackedBody is a request body that records how much was read from it.
+type trackedBody struct {
+	io.Reader
+	read int
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	b.read += n
+	return n, err
+}
+
+func (b *trackedBody) Close() error { return nil }
+
+func TestSkipBodyParsingLeavesBodyUnread(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	var (
+		sawOriginal bool
+		unread      int
+		body        string
+		params      map[string]interface{}
+	)
+	original := &trackedBody{Reader: strings.NewReader(`{"name":"ann"}`)}
+	s.RegisterRoutePOST("/proxy", func(w http.ResponseWriter, r *http.Request) {
+		sawOriginal = r.Body == io.ReadCloser(original)
+		unread = original.read
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		params = HttpParameters(r)
+	}).SkipBodyParsing()
+
+	r := httptest.NewRequest(http.MethodPost, "/proxy?page=2", nil)
+	r.Body = original
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Content-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if !sawOriginal || unread != 0 {
+		t.Errorf("handler got original body %v with %d bytes already read", sawOriginal, unread)
+	}
+	if body != `{"name":"ann"}` {
+		t.Errorf("body = %q", body)
+	}
+	if !reflect.DeepEqual(params, map[string]interface{}{"page": "2"}) {
+		t.Errorf("parameters = %v, want only the query", params)
+	}
+}
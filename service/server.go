@@ -2,10 +2,15 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
+	"slices"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -17,27 +22,80 @@ import (
 type ServiceHandleFunc func(http.ResponseWriter, *http.Request)
 
 type serviceHttpRouteInfo struct {
-	URI    string
+	URI string
+	// Method is the method the route answers, "*" for any, or the methods
+	// joined with commas for a route registered with RegisterRouteMethods.
 	Method string
 	Fn     ServiceHandleFunc
 	Hits   int32
-	Logger *logger.Logger
+	Logger Logger
+
+	methods []string
+	// order is the route's position in registration order.
+	order int
+	// summary and tags document the route in the OpenAPI spec.
+	summary string
+	tags    []string
+
+	// lowerURI is URI with its literal text lowercased, used for case
+	// insensitive matching. Named parameter names keep their case.
+	lowerURI string
+	// literalPrefix is the start of URI before any wildcard, which a
+	// path must share to match.
+	literalPrefix string
+
+	mu          sync.RWMutex
+	timeout     time.Duration
+	requireJSON bool
+	middleware  []Middleware
+	cache       *routeCache
+	idempotency *idempotencyStore
+
+	tolerateInvalidJSON bool
+	skipBody            bool
+	preloadLinks        []PreloadHint
 }
 
 func NewServiceHttpRouteInfo(uri, method string, fn ServiceHandleFunc) *serviceHttpRouteInfo {
+	info := newServiceHttpRouteInfo(uri, []string{method}, fn)
+	info.Logger = NewGologger(uri)
+	return info
+}
+
+func newServiceHttpRouteInfo(uri string, methods []string, fn ServiceHandleFunc) *serviceHttpRouteInfo {
 	info := &serviceHttpRouteInfo{
 		URI:    uri,
-		Method: method,
+		Method: strings.Join(methods, ","),
 		Fn:     fn,
 		Hits:   0,
-		Logger: logger.NewLogger(uri),
+
+		methods:       methods,
+		lowerURI:      lowerPattern(uri),
+		literalPrefix: literalPrefix(uri),
 	}
 
 	return info
 }
 
+// globMetaCharacters are the characters that can start a non-literal part
+// of a route pattern.
+const globMetaCharacters = "*?[{:\\"
+
+// literalPrefix returns the text of pattern before its first wildcard or
+// named parameter. Any path the pattern matches starts with it.
+func literalPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, globMetaCharacters); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
 func (s *serviceHttpRouteInfo) MatchURL(r *http.Request) (matched bool, named_parameters map[string]string) {
-	matched, matched_named_parameters, err := glob.MatchNamed(s.URI, r.URL.Path)
+	return s.matchPath(r.URL.Path)
+}
+
+func (s *serviceHttpRouteInfo) matchPath(path string) (matched bool, named_parameters map[string]string) {
+	matched, matched_named_parameters, err := glob.MatchNamed(s.URI, path)
 
 	if err != nil {
 		return false, nil
@@ -47,21 +105,119 @@ func (s *serviceHttpRouteInfo) MatchURL(r *http.Request) (matched bool, named_pa
 }
 
 func (s *serviceHttpRouteInfo) MatchMethod(method string) bool {
-	return s.Method == method || s.Method == "*"
+	return slices.Contains(s.methods, method) || slices.Contains(s.methods, "*")
+}
+
+// overlaps reports whether the route answers any of methods.
+func (s *serviceHttpRouteInfo) overlaps(methods []string) bool {
+	for _, method := range methods {
+		if method == "*" || s.MatchMethod(method) {
+			return true
+		}
+	}
+	return false
+}
+
+// shadows reports whether this already registered route would be tried
+// before a new route with the given pattern and method, and matches that
+// pattern's own text, so the new route is likely unreachable by glob.
+func (s *serviceHttpRouteInfo) shadows(uri string, methods []string) bool {
+	if len(s.URI) < len(uri) {
+		return false
+	}
+	if !s.overlaps(methods) {
+		return false
+	}
+	if s.URI == uri {
+		return true
+	}
+	matched, _, err := glob.MatchNamed(s.URI, uri)
+	return err == nil && matched
 }
 
 type Service struct {
-	FnLastChance http.HandlerFunc
-	Logger       *logger.Logger
-	serviceName  string
-	staticPath   string
-	routes       []*serviceHttpRouteInfo
-	done         chan struct{}
-	mu           sync.RWMutex
-	server       *http.Server
-	port         int
+	// FnLastChance handles requests that matched no route, embedded
+	// constant, or static file. When set it replaces the not found handler.
+	FnLastChance         http.HandlerFunc
+	notFound             ServiceHandleFunc
+	errorRenderer        ErrorRenderer
+	panicRecovery        bool
+	trailingSlash        TrailingSlashMode
+	caseInsensitive      bool
+	sseClientJS          []byte
+	noEmbeddedSseClient  bool
+	maxDecompressedBody  int64
+	maxBodySize          int64
+	bodyReadTimeout      time.Duration
+	parameterPrecedence  []ParameterSource
+	Logger               Logger
+	newLogger            LoggerFactory
+	serviceName          string
+	staticPath           string
+	staticPreloadLinks   []PreloadHint
+	staticDirs           []string
+	staticCacheControl   func(path string) string
+	staticPrefix         *string
+	staticPrefixOptional bool
+	routes               []*serviceHttpRouteInfo
+	// exactRoutes indexes routes by pattern for the exact match pass of
+	// resolvePath, each list in resolution order.
+	exactRoutes map[string][]*serviceHttpRouteInfo
+	// globRoutes narrows the glob pass of resolvePath to the routes that
+	// can match a path; foldRoutes and foldExactRoutes do the same for
+	// case insensitive matching, keyed by lowercased patterns.
+	globRoutes        routeIndex
+	foldRoutes        routeIndex
+	foldExactRoutes   map[string][]*serviceHttpRouteInfo
+	routeCount        int
+	done              chan struct{}
+	closeOnce         sync.Once
+	sseServers        []*SseServer
+	ctx               context.Context
+	cancel            context.CancelFunc
+	registration      serviceRegistration
+	mu                sync.RWMutex
+	server            *http.Server
+	host              string
+	port              int
+	listenAddr        string
+	addr              net.Addr
+	timeouts          serviceTimeouts
+	configureServer   []func(*http.Server)
+	maxHeaderBytes    int
+	maxURLLength      int
+	prettyJSON        bool
+	hooks             serviceHooks
+	middleware        []Middleware
+	noPrettyJSONQuery bool
+	floatJSONNumbers  bool
+	batchTimeout      time.Duration
+	trustedProxies    []netip.Prefix
+	draining          atomic.Bool
+}
+
+// serviceTimeouts holds the http.Server timeouts applied by Start.
+type serviceTimeouts struct {
+	read       time.Duration
+	readHeader time.Duration
+	write      time.Duration
+	idle       time.Duration
 }
 
+// Default server timeouts, chosen to be safe for a public-facing service.
+// A request, including its body, must be read within DefaultReadTimeout;
+// HttpSaveUpload extends the read deadline for long uploads. Responses
+// have no write timeout by default, so streamed and long-running responses
+// are not cut off. With SetWriteTimeout, SSE routes, WriteStream, and
+// WriteChunked clear their own write deadline, while other handlers must
+// finish within it.
+const (
+	DefaultReadTimeout       = 60 * time.Second
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultWriteTimeout      = 0
+	DefaultIdleTimeout       = 120 * time.Second
+)
+
 func (s *Service) String() string {
 	return "service::" + s.serviceName
 }
@@ -72,7 +228,21 @@ func (s *Service) SetLoggingLevel(level logger.LogLevel) *Service {
 	return s
 }
 
-// SetStaticPath sets the static path for the server.
+// SetNotFoundHandler sets the handler for requests that matched nothing.
+// It runs with the parameter context populated, so HttpParameterT works,
+// and only when FnLastChance is nil. The default writes a plain 404.
+//
+// Resolution order in ServeHTTP is: registered routes, the embedded sse.js,
+// the static directory, FnLastChance, then the not found handler.
+func (s *Service) SetNotFoundHandler(fn ServiceHandleFunc) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notFound = fn
+	return s
+}
+
+// SetStaticPath sets the static path for the server. It is searched before
+// any directory added with AddStaticDir.
 func (s *Service) SetStaticPath(path string) *Service {
 	s.staticPath = path
 	return s
@@ -81,55 +251,51 @@ func (s *Service) SetStaticPath(path string) *Service {
 // Start initializes the HTTP server and, if a service name is set,
 // starts the load balancer registration goroutine.
 func (s *Service) Start() error {
-	addr := "0.0.0.0:" + strconv.Itoa(s.port)
+	addr := s.listenAddr
+	if addr == "" {
+		addr = net.JoinHostPort(s.host, strconv.Itoa(s.port))
+	}
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		s.Logger.Errorln("Error starting HTTP server:", err)
 		return err
 	}
 
-	var port int
 	_, portStr, err := net.SplitHostPort(listener.Addr().String())
 	if err != nil {
+		listener.Close()
 		return err
 	}
-	port, err = strconv.Atoi(portStr)
+	port, err := strconv.Atoi(portStr)
 	if err != nil {
+		listener.Close()
 		return err
 	}
 
+	// Record the resolved address so a port of 0 reports the OS-assigned
+	// port to Port, Addr, and the registration goroutine alike.
+	s.mu.Lock()
+	s.addr = listener.Addr()
+	s.port = port
+	s.mu.Unlock()
+
 	s.Logger.Infoln("Service started on", listener.Addr().String())
 
-	// Only register with load balancer if a service name is set.
-	if s.serviceName != "" {
-		go func() {
-			s.Logger.Infoln("Starting registration goroutine", s.serviceName, port)
-			s.Logger.Infoln("https://io.moonlightcompanies.com/service/" + s.serviceName + "/")
-			first := true
-			ticker := time.NewTicker(500 * time.Millisecond)
-			defer ticker.Stop()
-			for {
-				select {
-				case <-s.done:
-					return
-				case <-ticker.C:
-				}
-
-				resp, _, _ := Invoke[any]("__internal_register_service/register_service", map[string]interface{}{
-					"name": s.serviceName,
-					"port": port,
-				})
-				if first {
-					s.Logger.Infoln("register_service result:", resp)
-					first = false
-					ticker.Reset(60 * time.Second)
-				}
-			}
-		}()
+	// Only register with load balancer if enabled and a service name is set.
+	if s.registration.enabled && s.serviceName != "" {
+		go s.register()
 	}
 
 	s.server = &http.Server{
-		Handler: s,
+		Handler:           s,
+		ReadTimeout:       s.timeouts.read,
+		ReadHeaderTimeout: s.timeouts.readHeader,
+		WriteTimeout:      s.timeouts.write,
+		IdleTimeout:       s.timeouts.idle,
+		MaxHeaderBytes:    s.maxHeaderBytes,
+	}
+	for _, configure := range s.configureServer {
+		configure(s.server)
 	}
 	go func() {
 		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
@@ -139,15 +305,60 @@ func (s *Service) Start() error {
 	return nil
 }
 
-func (s *Service) Close() {
-	close(s.done)
+// SetMaxURLLength rejects requests whose path and query are longer than n
+// bytes with 414 URI Too Long before they are routed, which keeps
+// pathological paths away from the glob matcher. Zero, the default, allows
+// any length up to the header limit.
+func (s *Service) SetMaxURLLength(n int) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxURLLength = n
+	return s
+}
+
+// Addr returns the address the service is listening on, or nil if the
+// service has not been started.
+func (s *Service) Addr() net.Addr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.addr
+}
+
+// Port returns the port the service is listening on. Before Start it returns
+// the configured port, after Start the port actually bound.
+func (s *Service) Port() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.port
+}
+
+// Shutdown stops registration, tells SSE clients to go away and ends their
+// streams, then gracefully shuts down the HTTP server, waiting for in-flight
+// requests until ctx is done. It is safe to call more than once.
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.cancel()
+	})
+
+	s.mu.RLock()
+	sseServers := append([]*SseServer(nil), s.sseServers...)
+	s.mu.RUnlock()
+	for _, sse := range sseServers {
+		sse.Shutdown()
+	}
 
 	if s.server != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		s.server.Shutdown(ctx)
+		return s.server.Shutdown(ctx)
 	}
+	return nil
+}
 
+// Close shuts the service down, allowing in-flight requests 5 seconds.
+func (s *Service) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.Shutdown(ctx)
 }
 
 func (s *Service) RegisterRouteGET(uri string, fn ServiceHandleFunc) *serviceHttpRouteInfo {
@@ -162,43 +373,148 @@ func (s *Service) RegisterRouteALL(uri string, fn ServiceHandleFunc) *serviceHtt
 	return s.RegisterRoute(uri, "*", fn)
 }
 
+// ErrDuplicateRoute is returned by RegisterRouteE when the same pattern and
+// method are already registered.
+var ErrDuplicateRoute = errors.New("duplicate route")
+
+// RegisterRoute registers fn for uri and method. It panics if the same
+// pattern and method are already registered, use RegisterRouteE to handle
+// that as an error. Routes may be registered while the service is serving;
+// requests already routed are unaffected.
 func (s *Service) RegisterRoute(uri, method string, fn ServiceHandleFunc) *serviceHttpRouteInfo {
+	result, err := s.RegisterRouteE(uri, method, fn)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// RegisterRouteE is like RegisterRoute but returns ErrDuplicateRoute instead
+// of panicking. Routes that can never be reached because an earlier glob
+// pattern already matches them are logged as a warning.
+func (s *Service) RegisterRouteE(uri, method string, fn ServiceHandleFunc) (*serviceHttpRouteInfo, error) {
+	return s.registerRoute(uri, []string{method}, fn)
+}
+
+// RegisterRouteMethods registers one handler for each of methods, for
+// example PUT and PATCH, without answering every method like "*" does.
+// Methods are matched exactly, so custom methods work too. It panics if
+// any of the methods is already registered for the same pattern.
+func (s *Service) RegisterRouteMethods(uri string, methods []string, fn ServiceHandleFunc) *serviceHttpRouteInfo {
+	result, err := s.RegisterRouteMethodsE(uri, methods, fn)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// RegisterRouteMethodsE is like RegisterRouteMethods but returns
+// ErrDuplicateRoute instead of panicking.
+func (s *Service) RegisterRouteMethodsE(uri string, methods []string, fn ServiceHandleFunc) (*serviceHttpRouteInfo, error) {
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no methods given for %s", uri)
+	}
+	return s.registerRoute(uri, slices.Clone(methods), fn)
+}
+
+func (s *Service) registerRoute(uri string, methods []string, fn ServiceHandleFunc) (*serviceHttpRouteInfo, error) {
 	uri = replaceAllDoubleSlashes(uri)
+	method := strings.Join(methods, ",")
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	result := NewServiceHttpRouteInfo(uri, method, fn)
-	s.routes = append(s.routes, result)
+	for _, route := range s.exactRoutes[uri] {
+		for _, m := range methods {
+			if slices.Contains(route.methods, m) {
+				return nil, fmt.Errorf("%w: %s %s", ErrDuplicateRoute, m, uri)
+			}
+		}
+	}
+
+	// Only routes that the index offers for the pattern's text, or with
+	// the same literal pattern, can match it, so the others are not
+	// globbed. This keeps registering many routes from being quadratic.
+	var buf [16]*serviceHttpRouteInfo
+	shadowing := s.globRoutes.candidates(uri, buf[:0])
+	for _, route := range s.exactRoutes[uri] {
+		if route.literalPrefix == route.URI {
+			shadowing = append(shadowing, route)
+		}
+	}
+	for _, route := range shadowing {
+		if route.shadows(uri, methods) {
+			s.Logger.Errorln("route", method, uri, "is shadowed by", route.Method, route.URI)
+		}
+	}
 
-	sort.SliceStable(s.routes, func(i, j int) bool {
-		return len(s.routes[i].URI) > len(s.routes[j].URI)
+	result := newServiceHttpRouteInfo(uri, methods, fn)
+	result.order = s.routeCount
+	s.routeCount++
+	result.Logger = s.newLogger(uri)
+	// Routes are kept longest pattern first, in registration order among
+	// equal lengths, so the new route goes after every route at least as
+	// long. Build a new slice rather than inserting in place, so a slice
+	// handed out earlier is never modified while it is being ranged over.
+	at := sort.Search(len(s.routes), func(i int) bool {
+		return len(s.routes[i].URI) < len(uri)
 	})
+	routes := make([]*serviceHttpRouteInfo, 0, len(s.routes)+1)
+	routes = append(routes, s.routes[:at]...)
+	routes = append(routes, result)
+	routes = append(routes, s.routes[at:]...)
+	s.routes = routes
+	// Routes sharing a pattern sort next to each other in registration
+	// order, so appending keeps each list in resolution order.
+	if s.exactRoutes == nil {
+		s.exactRoutes = make(map[string][]*serviceHttpRouteInfo)
+	}
+	s.exactRoutes[uri] = append(s.exactRoutes[uri], result)
+	if s.foldExactRoutes == nil {
+		s.foldExactRoutes = make(map[string][]*serviceHttpRouteInfo)
+	}
+	s.foldExactRoutes[result.lowerURI] = insertRoute(s.foldExactRoutes[result.lowerURI], result)
+	s.globRoutes.add(result, uri)
+	s.foldRoutes.add(result, result.lowerURI)
 
-	return result
+	return result, nil
 }
 
 func (s *Service) ResolveRoute(r *http.Request) (*serviceHttpRouteInfo, map[string]string, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	// look for exact match first
-	for _, route := range s.routes {
-		if !route.MatchMethod(r.Method) {
-			continue
+	route, named_parameters, found := s.resolvePath(r.Method, r.URL.Path)
+	if !found && s.trailingSlash == TrailingSlashLenient {
+		if alternate, ok := toggleTrailingSlash(r.URL.Path); ok {
+			return s.resolvePath(r.Method, alternate)
 		}
-		if r.URL.Path == route.URI {
+	}
+	return route, named_parameters, found
+}
+
+// resolvePath finds the route for method and path. The caller holds s.mu.
+func (s *Service) resolvePath(method, path string) (*serviceHttpRouteInfo, map[string]string, bool) {
+	if s.caseInsensitive {
+		return s.resolvePathFold(method, path)
+	}
+
+	// look for exact match first
+	for _, route := range s.exactRoutes[path] {
+		if route.MatchMethod(method) {
 			return route, nil, true
 		}
 	}
 
-	// look for glob match
-	for _, route := range s.routes {
-		if !route.MatchMethod(r.Method) {
+	// look for glob match among the routes the index leaves, skipping
+	// patterns whose literal start differs before running the matcher
+	var buf [16]*serviceHttpRouteInfo
+	for _, route := range s.globRoutes.candidates(path, buf[:0]) {
+		if !strings.HasPrefix(path, route.literalPrefix) || !route.MatchMethod(method) {
 			continue
 		}
 
-		if matched, named_parameters := route.MatchURL(r); matched {
+		if matched, named_parameters := route.matchPath(path); matched {
 			return route, named_parameters, true
 		}
 	}
@@ -207,19 +523,108 @@ func (s *Service) ResolveRoute(r *http.Request) (*serviceHttpRouteInfo, map[stri
 }
 
 func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.Logger.Debugln("Request", r.Method, r.URL.Path)
+	start := time.Now()
+	r = r.WithContext(context.WithValue(r.Context(), parameter_request_start, start))
+	rw := newResponseWriter(w)
+	rw.pretty = s.wantsPrettyJSON(r)
+
+	s.mu.RLock()
+	hooks := s.hooks
+	errorRenderer := s.errorRenderer
+	recovery := s.panicRecovery
+	trustedProxies := s.trustedProxies
+	s.mu.RUnlock()
+	if len(trustedProxies) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), parameter_trusted_proxies, trustedProxySet(trustedProxies)))
+	}
+	rw.errors = &errorRendering{render: errorRenderer, r: r}
+
+	if hooks.requestStart != nil {
+		hooks.requestStart(r)
+	}
+	rw.onWriteError = func(err error) {
+		logInfow(s.Logger, "response write failed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rw.Status(),
+			"error", err,
+		)
+		if hooks.writeError != nil {
+			hooks.writeError(r, err)
+		}
+	}
+	if hooks.requestEnd != nil {
+		rw.onEnd = func(status int) {
+			hooks.requestEnd(r, status, time.Since(start))
+		}
+	}
+	defer func() {
+		rw.end()
+		logDebugw(s.Logger, "Request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rw.Status(),
+			"duration", time.Since(start),
+		)
+	}()
+	if recovery {
+		defer s.recoverPanic(rw, r)
+	}
+
+	s.serve(rw, r)
+}
+
+// serve dispatches r to a route, the embedded constants, the static
+// directory, or the not found handler, in that order.
+func (s *Service) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	maxURLLength := s.maxURLLength
+	s.mu.RUnlock()
+	if maxURLLength > 0 && len(r.URL.RequestURI()) > maxURLLength {
+		WriteErrorCode(w, http.StatusRequestURITooLong, errors.New("request URI too long"))
+		return
+	}
 
 	sh, params_uri, found := s.ResolveRoute(r)
-	parametersCtx, parametersErr := s.parameters(r, params_uri)
+	if !found && s.redirectTrailingSlash(w, r) {
+		return
+	}
+	if found && !sh.checkContentType(w, r) {
+		return
+	}
+	var opts parseOptions
+	if found {
+		opts = sh.parseOptions()
+	}
+	parametersCtx, parametersErr := s.parameters(w, r, params_uri, opts)
 	if parametersErr != nil {
-		WriteError(w, parametersErr)
+		statusCode := http.StatusBadRequest
+		switch {
+		case errors.Is(parametersErr, context.DeadlineExceeded):
+			statusCode = http.StatusRequestTimeout
+		case errors.Is(parametersErr, ErrBodyTooLarge):
+			statusCode = http.StatusRequestEntityTooLarge
+		case errors.Is(parametersErr, ErrUnsupportedContentEncoding):
+			statusCode = http.StatusUnsupportedMediaType
+		}
+		WriteErrorCode(w, statusCode, parametersErr)
 		return
 	}
 	r = r.WithContext(parametersCtx)
 
 	if found {
+		r = r.WithContext(context.WithValue(r.Context(), parameter_matched_route, sh.URI))
+		s.mu.RLock()
+		routeResolved := s.hooks.routeResolved
+		s.mu.RUnlock()
+		if routeResolved != nil {
+			routeResolved(r, sh)
+		}
 		atomic.AddInt32(&sh.Hits, 1)
-		sh.Fn(w, r)
+		s.mu.RLock()
+		handle := chainMiddleware(sh.handle, s.middleware)
+		s.mu.RUnlock()
+		handle(w, r)
 		return
 	}
 
@@ -241,22 +646,50 @@ func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.mu.RLock()
+	notFound := s.notFound
+	s.mu.RUnlock()
+	if notFound != nil {
+		notFound(w, r)
+		return
+	}
+
 	http.Error(w, "not found", http.StatusNotFound)
 }
 
 // ServiceBuilder implements a builder pattern for Service.
 type ServiceBuilder struct {
-	port        int
-	serviceName string
-	logger      *logger.Logger
+	host         string
+	port         int
+	listenAddr   string
+	serviceName  string
+	logger       Logger
+	newLogger    LoggerFactory
+	timeouts     serviceTimeouts
+	registration serviceRegistration
+
+	configureServer []func(*http.Server)
+	maxHeaderBytes  int
 }
 
 // NewServiceBuilder creates a new ServiceBuilder with default values.
 func NewServiceBuilder() *ServiceBuilder {
 	return &ServiceBuilder{
+		host:        "0.0.0.0",
 		port:        0,
 		serviceName: "",
-		logger:      logger.NewLogger("service"),
+		logger:      NewGologger("service"),
+		newLogger:   defaultLoggerFactory,
+		timeouts: serviceTimeouts{
+			read:       DefaultReadTimeout,
+			readHeader: DefaultReadHeaderTimeout,
+			write:      DefaultWriteTimeout,
+			idle:       DefaultIdleTimeout,
+		},
+		registration: serviceRegistration{
+			endpoint: DefaultRegistrationEndpoint,
+			interval: DefaultRegistrationInterval,
+		},
 	}
 }
 
@@ -266,25 +699,114 @@ func (b *ServiceBuilder) SetPort(port int) *ServiceBuilder {
 	return b
 }
 
+// SetHost sets the host or interface address the service binds to,
+// e.g. "127.0.0.1". Defaults to "0.0.0.0".
+func (b *ServiceBuilder) SetHost(host string) *ServiceBuilder {
+	b.host = host
+	return b
+}
+
+// SetListenAddr sets the full listen address (host:port), overriding
+// SetHost and SetPort.
+func (b *ServiceBuilder) SetListenAddr(addr string) *ServiceBuilder {
+	b.listenAddr = addr
+	return b
+}
+
+// SetLogger sets the logger used by the service. Routes and SSE servers
+// registered on the service share it instead of creating their own.
+func (b *ServiceBuilder) SetLogger(l Logger) *ServiceBuilder {
+	b.logger = l
+	b.newLogger = func(string) Logger { return l }
+	return b
+}
+
+// SetLoggerFactory sets how per-route and per-SSE-endpoint loggers are created.
+func (b *ServiceBuilder) SetLoggerFactory(factory LoggerFactory) *ServiceBuilder {
+	b.newLogger = factory
+	return b
+}
+
 // SetServiceName sets the service name for the service.
 func (b *ServiceBuilder) SetServiceName(name string) *ServiceBuilder {
 	b.serviceName = name
 	return b
 }
 
+// SetReadTimeout sets the maximum duration for reading an entire request,
+// including the body. Zero means no timeout.
+func (b *ServiceBuilder) SetReadTimeout(d time.Duration) *ServiceBuilder {
+	b.timeouts.read = d
+	return b
+}
+
+// SetReadHeaderTimeout sets the maximum duration for reading request headers.
+// Zero falls back to the read timeout.
+func (b *ServiceBuilder) SetReadHeaderTimeout(d time.Duration) *ServiceBuilder {
+	b.timeouts.readHeader = d
+	return b
+}
+
+// SetWriteTimeout sets the maximum duration before timing out writes of a
+// response, measured from the end of reading the request headers. SSE
+// routes, WriteStream, and WriteChunked are exempt, they clear the deadline
+// per connection; any other response is cut off once it elapses. Zero, the
+// default, means no timeout.
+func (b *ServiceBuilder) SetWriteTimeout(d time.Duration) *ServiceBuilder {
+	b.timeouts.write = d
+	return b
+}
+
+// SetIdleTimeout sets the maximum time to wait for the next request on a
+// keep-alive connection. Zero falls back to the read timeout.
+func (b *ServiceBuilder) SetIdleTimeout(d time.Duration) *ServiceBuilder {
+	b.timeouts.idle = d
+	return b
+}
+
+// SetMaxHeaderBytes limits the size of request headers, including the
+// request line. Larger requests are answered with 431 Request Header
+// Fields Too Large by net/http. Zero keeps http.DefaultMaxHeaderBytes.
+func (b *ServiceBuilder) SetMaxHeaderBytes(n int) *ServiceBuilder {
+	b.maxHeaderBytes = n
+	return b
+}
+
+// ConfigureServer adds a function that can adjust the http.Server before it
+// starts serving, for example to set ConnState, BaseContext, ErrorLog, or
+// TLSNextProto. It runs after the defaults, including the timeouts, are
+// applied. Functions run in the order they were added.
+func (b *ServiceBuilder) ConfigureServer(fn func(*http.Server)) *ServiceBuilder {
+	b.configureServer = append(b.configureServer, fn)
+	return b
+}
+
 // Build creates a Service instance based on the builder's configuration.
 func (b *ServiceBuilder) Build() *Service {
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &Service{
-		Logger:      b.logger,
-		done:        make(chan struct{}),
-		routes:      make([]*serviceHttpRouteInfo, 0),
-		serviceName: b.serviceName,
-		port:        b.port,
+		Logger:       b.logger,
+		newLogger:    b.newLogger,
+		done:         make(chan struct{}),
+		ctx:          ctx,
+		cancel:       cancel,
+		routes:       make([]*serviceHttpRouteInfo, 0),
+		serviceName:  b.serviceName,
+		host:         b.host,
+		port:         b.port,
+		listenAddr:   b.listenAddr,
+		timeouts:     b.timeouts,
+		registration: b.registration,
+
+		configureServer: slices.Clone(b.configureServer),
+		maxHeaderBytes:  b.maxHeaderBytes,
 	}
 }
 
-// NewServiceWithName creates a new Service with the given service name.
+// NewServiceWithName creates a new Service with the given service name and
+// load balancer registration enabled.
 // useful for top level initialization.
 func NewServiceWithName(name string) *Service {
-	return NewServiceBuilder().SetServiceName(name).Build()
+	return NewServiceBuilder().SetServiceName(name).SetRegistration(true).Build()
 }
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTestService builds a service on a loopback port chosen by the OS,
+// starts it, and shuts it down when the test ends.
+func startTestService(t *testing.T, b *ServiceBuilder) (*Service, string) {
+	t.Helper()
+	s := b.SetHost("127.0.0.1").SetPort(0).Build()
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	// A connection the client dialed but never used counts as active for
+	// five seconds during shutdown, so close those first.
+	t.Cleanup(http.DefaultClient.CloseIdleConnections)
+	return s, "http://" + s.Addr().String()
+}
+
+func TestDefaultTimeoutsLeaveWritesUnbounded(t *testing.T) {
+	s, _ := startTestService(t, NewServiceBuilder())
+
+	if s.server.WriteTimeout != 0 {
+		t.Errorf("WriteTimeout = %v, want 0", s.server.WriteTimeout)
+	}
+	if s.server.ReadTimeout != DefaultReadTimeout {
+		t.Errorf("ReadTimeout = %v, want %v", s.server.ReadTimeout, DefaultReadTimeout)
+	}
+	if s.server.ReadHeaderTimeout != DefaultReadHeaderTimeout {
+		t.Errorf("ReadHeaderTimeout = %v, want %v", s.server.ReadHeaderTimeout, DefaultReadHeaderTimeout)
+	}
+	if s.server.IdleTimeout != DefaultIdleTimeout {
+		t.Errorf("IdleTimeout = %v, want %v", s.server.IdleTimeout, DefaultIdleTimeout)
+	}
+}
+
+func TestBuilderTimeoutsApplyToServer(t *testing.T) {
+	var configured *http.Server
+	s, _ := startTestService(t, NewServiceBuilder().
+		SetReadTimeout(time.Second).
+		SetReadHeaderTimeout(2*time.Second).
+		SetWriteTimeout(3*time.Second).
+		SetIdleTimeout(4*time.Second).
+		ConfigureServer(func(srv *http.Server) { configured = srv }))
+
+	if configured != s.server {
+		t.Fatal("ConfigureServer was not called with the server")
+	}
+	got := []time.Duration{s.server.ReadTimeout, s.server.ReadHeaderTimeout, s.server.WriteTimeout, s.server.IdleTimeout}
+	want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 4 * time.Second}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("timeouts = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestWriteTimeoutCutsOffSlowHandler(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder().SetWriteTimeout(100*time.Millisecond))
+	s.RegisterRouteGET("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		WriteRaw(w, "text/plain", "late")
+	})
+
+	resp, err := http.Get(base + "/slow")
+	if err == nil {
+		body, readErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if readErr == nil && string(body) == "late" {
+			t.Fatal("response was written after the write timeout")
+		}
+	}
+}
+
+func TestSseOutlivesWriteTimeout(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder().SetWriteTimeout(100*time.Millisecond))
+	sse := s.RegisterSSE("/events", newTestSseHandler)
+
+	events, _ := connectSse(t, base+"/events")
+
+	time.Sleep(300 * time.Millisecond)
+	sse.Broadcast(SseMessage{"event": "late"})
+	if msg := readSseMessage(t, events); msg.Event() != "late" {
+		t.Fatalf("event = %q, want late", msg.Event())
+	}
+}
+
+func TestSetHostBindsInterface(t *testing.T) {
+	s, _ := startTestService(t, NewServiceBuilder())
+
+	addr, ok := s.Addr().(*net.TCPAddr)
+	if !ok || !addr.IP.IsLoopback() {
+		t.Fatalf("Addr() = %v, want a loopback address", s.Addr())
+	}
+}
+
+func TestSetListenAddrOverridesHostAndPort(t *testing.T) {
+	s := NewServiceBuilder().SetHost("0.0.0.0").SetPort(1).SetListenAddr("127.0.0.1:0").Build()
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	addr, ok := s.Addr().(*net.TCPAddr)
+	if !ok || !addr.IP.IsLoopback() || addr.Port == 0 || addr.Port == 1 {
+		t.Fatalf("Addr() = %v, want 127.0.0.1 on an OS-assigned port", s.Addr())
+	}
+}
+
+func TestAddrIsNilBeforeStart(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	if s.Addr() != nil {
+		t.Fatalf("Addr() = %v before Start, want nil", s.Addr())
+	}
+}
+
+func TestPortZeroReportsBoundPort(t *testing.T) {
+	s, _ := startTestService(t, NewServiceBuilder())
+	s.RegisterRouteGET("/ping", func(w http.ResponseWriter, r *http.Request) {
+		WriteRaw(w, "text/plain", "pong")
+	})
+
+	if s.Port() == 0 {
+		t.Fatal("Port() = 0 after Start")
+	}
+	if got := s.Addr().(*net.TCPAddr).Port; got != s.Port() {
+		t.Fatalf("Addr() port %d != Port() %d", got, s.Port())
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(s.Port())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /ping HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "pong" {
+		t.Fatalf("got %d %q, want 200 pong", resp.StatusCode, body)
+	}
+}
+
+func TestNotFoundHandlerFiresForUnmatchedPath(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	s.RegisterRouteGET("/known", noopHandler)
+	s.SetNotFoundHandler(func(w http.ResponseWriter, r *http.Request) {
+		name, _ := HttpParameterT[string](r, "name")
+		WriteErrorCode(w, http.StatusNotFound, errors.New("no page for "+name))
+	})
+
+	resp, err := http.Get(base + "/missing?name=ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusNotFound || !strings.Contains(string(body), "no page for ada") {
+		t.Fatalf("got %d %q, want the custom 404", resp.StatusCode, body)
+	}
+}
+
+func TestLastChanceRunsBeforeNotFound(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	s.FnLastChance = func(w http.ResponseWriter, r *http.Request) {
+		WriteRaw(w, "text/plain", "last chance")
+	}
+	s.SetNotFoundHandler(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("not found handler ran after FnLastChance")
+	})
+
+	resp, err := http.Get(base + "/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "last chance" {
+		t.Fatalf("body = %q, want last chance", body)
+	}
+}
+
+func TestConfigureServerConnStateFires(t *testing.T) {
+	states := make(chan http.ConnState, 16)
+	s, base := startTestService(t, NewServiceBuilder().ConfigureServer(func(srv *http.Server) {
+		srv.ConnState = func(conn net.Conn, state http.ConnState) { states <- state }
+	}))
+	s.RegisterRouteGET("/ping", noopHandler)
+
+	resp, err := http.Get(base + "/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	seen := make(map[http.ConnState]bool)
+	timeout := time.After(2 * time.Second)
+	for !seen[http.StateNew] || !seen[http.StateActive] {
+		select {
+		case state := <-states:
+			seen[state] = true
+		case <-timeout:
+			t.Fatalf("ConnState saw %v, want new and active", seen)
+		}
+	}
+}
+
+func TestOversizedHeadersAre431(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder().SetMaxHeaderBytes(1024))
+	s.RegisterRouteGET("/ping", noopHandler)
+
+	req, _ := http.NewRequest("GET", base+"/ping", nil)
+	req.Header.Set("X-Padding", strings.Repeat("a", 8192))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Fatalf("status = %d, want 431", resp.StatusCode)
+	}
+
+	resp, err = http.Get(base + "/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("small request status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestLongURLIs414(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	s.SetMaxURLLength(64)
+	routed := false
+	s.RegisterRouteGET("/*", func(w http.ResponseWriter, r *http.Request) { routed = true })
+
+	resp, err := http.Get(base + "/" + strings.Repeat("a/", 64) + "?q=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestURITooLong || routed {
+		t.Fatalf("status = %d, routed %v, want 414 before routing", resp.StatusCode, routed)
+	}
+
+	resp, err = http.Get(base + "/short")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !routed {
+		t.Fatalf("short URL status = %d, routed %v", resp.StatusCode, routed)
+	}
+}
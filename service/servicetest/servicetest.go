@@ -0,0 +1,48 @@
+// Package servicetest provides helpers for unit testing service handlers
+// without starting a server.
+package servicetest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/Moonlight-Companies/gohttp/service"
+)
+
+// NewRequest builds an incoming test request whose context carries the
+// unified parameters, so handlers can call service.HttpParameterT and
+// friends. pathParams are the named parameters a route pattern would have
+// matched and may be nil. JSON bodies are only parsed with a Content-Type
+// header, which NewRequestWithHeader can set.
+func NewRequest(s *service.Service, method, target string, body io.Reader, pathParams map[string]string) (*http.Request, error) {
+	return NewRequestWithHeader(s, method, target, nil, body, pathParams)
+}
+
+// NewRequestWithHeader is like NewRequest but sets the given headers before
+// parameters are parsed.
+func NewRequestWithHeader(s *service.Service, method, target string, header http.Header, body io.Reader, pathParams map[string]string) (*http.Request, error) {
+	r := httptest.NewRequest(method, target, body)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+
+	ctx, err := s.BuildContext(r, pathParams)
+	if err != nil {
+		return nil, err
+	}
+	return r.WithContext(ctx), nil
+}
+
+// Serve runs fn against a request built by NewRequestWithHeader and returns
+// the recorded response.
+func Serve(s *service.Service, fn service.ServiceHandleFunc, method, target string, header http.Header, body io.Reader, pathParams map[string]string) (*httptest.ResponseRecorder, error) {
+	r, err := NewRequestWithHeader(s, method, target, header, body, pathParams)
+	if err != nil {
+		return nil, err
+	}
+
+	w := httptest.NewRecorder()
+	fn(w, r)
+	return w, nil
+}
@@ -0,0 +1,53 @@
+package servicetest_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Moonlight-Companies/gohttp/service"
+	"github.com/Moonlight-Companies/gohttp/service/servicetest"
+)
+
+func greet(w http.ResponseWriter, r *http.Request) {
+	name, _ := service.HttpParameterT[string](r, "name")
+	id, _ := service.HttpParameterT[string](r, "id")
+	service.WriteRaw(w, "text/plain", "hello "+name+" "+id)
+}
+
+func TestServeBuildsParameters(t *testing.T) {
+	s := service.NewServiceBuilder().Build()
+	header := http.Header{"Content-Type": {"application/json"}}
+
+	w, err := servicetest.Serve(s, greet, http.MethodPost, "/users/7", header, strings.NewReader(`{"name":"ada"}`), map[string]string{"id": "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Body.String(); got != "hello ada 7" {
+		t.Fatalf("body = %q, want %q", got, "hello ada 7")
+	}
+}
+
+func TestNewRequestReadsQuery(t *testing.T) {
+	s := service.NewServiceBuilder().Build()
+
+	r, err := servicetest.NewRequest(s, http.MethodGet, "/?name=grace", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := service.HttpParameterT[string](r, "name"); !ok || name != "grace" {
+		t.Fatalf("name = %q, %v, want grace", name, ok)
+	}
+}
+
+func ExampleServe() {
+	s := service.NewServiceBuilder().Build()
+
+	w, err := servicetest.Serve(s, greet, http.MethodGet, "/users/7?name=ada", nil, nil, map[string]string{"id": "7"})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(w.Code, w.Body.String())
+	// Output: 200 hello ada 7
+}
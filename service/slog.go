@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/Moonlight-Companies/gologger/logger"
+)
+
+// SlogLogger is a StructuredLogger backed by log/slog.
+type SlogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlogLogger wraps l so it can be passed to ServiceBuilder.SetLogger.
+// Request logs, SSE session events, and other internal logs are emitted as
+// key/value attributes.
+func NewSlogLogger(l *slog.Logger) *SlogLogger {
+	return &SlogLogger{l: l}
+}
+
+func (s *SlogLogger) Debugln(args ...interface{}) {
+	s.l.Debug(sprintln(args...))
+}
+
+func (s *SlogLogger) Infoln(args ...interface{}) {
+	s.l.Info(sprintln(args...))
+}
+
+func (s *SlogLogger) Errorln(args ...interface{}) {
+	s.l.Error(sprintln(args...))
+}
+
+func (s *SlogLogger) Debugw(msg string, kv ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelDebug, msg, kv...)
+}
+
+func (s *SlogLogger) Infow(msg string, kv ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelInfo, msg, kv...)
+}
+
+func (s *SlogLogger) Errorw(msg string, kv ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelError, msg, kv...)
+}
+
+// SetLevel is a no-op, the level is controlled by the slog.Handler.
+func (s *SlogLogger) SetLevel(level logger.LogLevel) {}
+
+func sprintln(args ...interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+}
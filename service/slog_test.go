@@ -0,0 +1,63 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// syncBuffer is a bytes.Buffer safe for the server and the test to share.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) lines() [][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Split(bytes.TrimSpace(b.buf.Bytes()), []byte("\n"))
+}
+
+func TestSlogLoggerLogsRequestAttributes(t *testing.T) {
+	var out syncBuffer
+	l := NewSlogLogger(slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	s, base := startTestService(t, NewServiceBuilder().SetLogger(l))
+	s.RegisterRouteGET("/teapot", func(w http.ResponseWriter, r *http.Request) {
+		WriteErrorCode(w, http.StatusTeapot, io.EOF)
+	})
+
+	resp, err := http.Get(base + "/teapot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	s.Close()
+
+	for _, line := range out.lines() {
+		var record map[string]any
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		if record["msg"] != "Request" {
+			continue
+		}
+		if record["method"] != "GET" || record["path"] != "/teapot" || record["status"] != float64(http.StatusTeapot) {
+			t.Fatalf("request record = %v", record)
+		}
+		if _, ok := record["duration"]; !ok {
+			t.Fatalf("request record has no duration: %v", record)
+		}
+		return
+	}
+	t.Fatalf("no request record in %q", out.lines())
+}
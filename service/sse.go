@@ -1,12 +1,17 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Moonlight-Companies/gologger/logger"
@@ -24,30 +29,119 @@ func (m *SseMessage) Event() string {
 	return ""
 }
 
-func (m *SseMessage) Encode() ([]byte, error) {
+// sseEnvelope carries a message through the fanout and a session's direct
+// queue together with how it is framed and delivered, so SseMessage stays
+// the payload the client receives.
+type sseEnvelope struct {
+	msg SseMessage
+	// raw is sent as plain text instead of msg when isRaw is set.
+	raw   string
+	isRaw bool
+	// id is emitted as an SSE id: field, which the client echoes as
+	// Last-Event-ID on reconnect.
+	id string
+	// retry is emitted as an SSE retry: field ahead of the data.
+	retry time.Duration
+	// topic limits delivery to the sessions subscribed to it.
+	topic string
+	// written is signalled by every session that writes the message, for
+	// BroadcastSync.
+	written chan struct{}
+}
+
+// event returns the "event" of the payload, empty for raw text.
+func (e *sseEnvelope) event() string {
+	return e.msg.Event()
+}
+
+// sseEventID removes line breaks from id, which would end the id: field.
+func sseEventID(id string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(id)
+}
+
+// encodeSseData frames data as SSE data: lines. CRLF, CR, and LF are all
+// line breaks in SSE, so each is split on to keep the stream well-formed.
+func encodeSseData(data string) []byte {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+
+	var b bytes.Buffer
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: ")
+		b.WriteString(line)
+		b.WriteString("\r\n")
+	}
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// encode frames the envelope as one SSE event. Raw text is split into one
+// data: line per line, as the SSE spec requires, so the client receives it
+// joined back with "\n".
+func (e *sseEnvelope) encode() ([]byte, error) {
+	var b bytes.Buffer
+	if e.id != "" {
+		b.WriteString("id: " + e.id + "\r\n")
+	}
+	if e.retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\r\n", e.retry.Milliseconds())
+	}
+
+	if e.isRaw {
+		b.Write(encodeSseData(e.raw))
+		return b.Bytes(), nil
+	}
+
 	// Marshal the data into JSON.
-	encoded_message, err := json.Marshal(m)
+	encoded_message, err := json.Marshal(e.msg)
 	if err != nil {
 		return nil, err
 	}
+	b.Write(encodeSseData(string(encoded_message)))
+	return b.Bytes(), nil
+}
 
-	// Prepare the SSE format with proper prefixes and suffixes.
-	sseFormattedMessage := fmt.Sprintf("data: %s\r\n\r\n", encoded_message)
+// Encode returns msg as an SSE event whose data is its JSON encoding.
+func (m *SseMessage) Encode() ([]byte, error) {
+	env := sseEnvelope{msg: *m}
+	return env.encode()
+}
 
-	return []byte(sseFormattedMessage), nil
+// writeSseEnvelope encodes env in SSE framing and writes it, flushing when
+// flush is set so the client receives it immediately.
+func writeSseEnvelope(w http.ResponseWriter, env sseEnvelope, flush bool) error {
+	encoded, err := env.encode()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(encoded); err != nil {
+		return err
+	}
+
+	if flush {
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	return nil
 }
 
 // EventHandler lets user provide interface such that state can be maintained,
 // message filtering, and arbitrary callbacks can be handled per client.
 type SseEventHandler interface {
-	// OnInitialize is called when the http request is initialized.
+	// OnInitialize is called when the http request is initialized, before
+	// any response is written. Return RejectSse to refuse the connection
+	// with a status code such as 401 or 403.
 	OnInitialize(w http.ResponseWriter, r *http.Request, server *SseServer, session *SseSession) error
-	// OnConnect is called when a new session is created.
+	// OnConnect is called when a new session is created, after on_connect
+	// was sent. Returning an error ends the stream.
 	OnConnect(w http.ResponseWriter, r *http.Request) error
 	// OnDisconnect is called when a session is closed.
 	OnDisconnect(w http.ResponseWriter, r *http.Request)
 	// OnMessage is called before a message is sent for filtering.
-	// Returning false skips sending the message.
+	// Returning false skips sending the message. msg is nil for text sent
+	// with BroadcastRaw or DirectMessageRaw.
 	OnMessage(w http.ResponseWriter, r *http.Request, msg SseMessage) bool
 	// OnCallback handles user-defined callbacks (e.g. via POST endpoints).
 	OnCallback(w http.ResponseWriter, r *http.Request)
@@ -55,15 +149,58 @@ type SseEventHandler interface {
 
 type SseEventHandlerFactory func() SseEventHandler
 
+// SseRejection is returned from OnInitialize to refuse a connection with a
+// specific status code, such as 401 or 403. Any other error refuses it with
+// 400. OnInitialize runs before the event stream headers are written.
+type SseRejection struct {
+	StatusCode int
+	Err        error
+}
+
+// RejectSse returns an SseRejection refusing the connection with
+// statusCode and err as the message.
+func RejectSse(statusCode int, err error) error {
+	return &SseRejection{StatusCode: statusCode, Err: err}
+}
+
+func (e *SseRejection) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SseRejection) Unwrap() error {
+	return e.Err
+}
+
 // SseSession represents an individual SSE client session.
 type SseSession struct {
+	ctx                context.Context
 	client_id          ClientID
+	csrf_token         string
 	user_handler       SseEventHandler
 	done               chan struct{}
-	broadcast_messages *mpmc.Consumer[SseMessage]
-	direct_messages    chan SseMessage
+	broadcast_messages *mpmc.Consumer[sseEnvelope]
+	direct_messages    chan sseEnvelope
+	draining           chan struct{}
+	drainOnce          sync.Once
 	mu                 sync.Mutex
 	closed             bool
+	pendingAcks        map[string]chan struct{}
+	lastActivity       atomic.Int64
+	lastEventID        string
+	topics             map[string]bool
+	events             map[string]bool
+	slow               atomic.Bool
+	lastPong           atomic.Int64
+}
+
+// touch records that the session wrote to or heard from its client.
+func (s *SseSession) touch() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+// idleFor returns how long ago the session was last active.
+func (s *SseSession) idleFor() time.Duration {
+	return time.Since(time.Unix(0, s.lastActivity.Load()))
 }
 
 func (s *SseSession) String() string {
@@ -74,21 +211,209 @@ func (s *SseSession) ClientID() ClientID {
 	return s.client_id
 }
 
-// DirectMessage attempts to queue a direct message non-blockingly.
+// LastPong returns when the client last answered a ping, or the zero time
+// if it has not. The embedded sse.js client answers every ping with a pong
+// on the callback route.
+func (s *SseSession) LastPong() time.Time {
+	if nanos := s.lastPong.Load(); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Time{}
+}
+
+// LastEventID returns the id of the last event the client received before
+// reconnecting, from the Last-Event-ID header or, for the embedded client,
+// the last_event_id query parameter. It is empty on a first connection.
+// Ids are only sent for messages given one with BroadcastWithID or
+// DirectMessageWithID.
+func (s *SseSession) LastEventID() string {
+	return s.lastEventID
+}
+
+// Control events a client publishes to the callback route to change its
+// topics.
+const (
+	sseSubscribeEvent   = "__subscribe"
+	sseUnsubscribeEvent = "__unsubscribe"
+)
+
+// Subscribe adds topic to the topics the session receives from
+// BroadcastTopic. Clients subscribe themselves by publishing
+// {"event": "__subscribe", "topic": ...} to the callback route, which
+// SseConfig.AuthorizeTopic must allow.
+func (s *SseSession) Subscribe(topic string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.topics == nil {
+		s.topics = make(map[string]bool)
+	}
+	s.topics[topic] = true
+}
+
+// receives reports whether a broadcast of env is written to the session,
+// rather than skipped for its SseConfig.EventsQuery filter or because it
+// is not subscribed to the topic.
+func (s *SseSession) receives(env *sseEnvelope) bool {
+	if s.events != nil && !s.events[env.event()] {
+		return false
+	}
+	return env.topic == "" || s.Subscribed(env.topic)
+}
+
+// Unsubscribe removes topic from the session's topics.
+func (s *SseSession) Unsubscribe(topic string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.topics, topic)
+}
+
+// Subscribed reports whether the session receives topic.
+func (s *SseSession) Subscribed(topic string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.topics[topic]
+}
+
+// Topics returns the topics the session is subscribed to.
+func (s *SseSession) Topics() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	topics := make([]string, 0, len(s.topics))
+	for topic := range s.topics {
+		topics = append(topics, topic)
+	}
+	slices.Sort(topics)
+	return topics
+}
+
+// QueueDepth returns how many messages are waiting to be written to the
+// client: broadcasts backed up in its fanout buffer plus queued direct
+// messages. A depth that keeps growing means the client is not keeping up.
+func (s *SseSession) QueueDepth() int {
+	return len(s.broadcast_messages.Messages) + len(s.direct_messages)
+}
+
+// CSRFToken returns the per-session token sent in the on_connect message.
+// Callbacks must echo it when CSRF protection is enabled on the server.
+func (s *SseSession) CSRFToken() string {
+	return s.csrf_token
+}
+
+var (
+	ErrSseSessionClosed   = errors.New("session closed")
+	ErrSseBufferFull      = errors.New("direct message buffer full")
+	ErrSseSessionNotFound = errors.New("session not found")
+)
+
+// DirectMessage attempts to queue a direct message non-blockingly. It fails
+// with ErrSseSessionClosed or, when the client is not keeping up,
+// ErrSseBufferFull.
 func (s *SseSession) DirectMessage(msg SseMessage) error {
+	return s.directMessage(sseEnvelope{msg: msg})
+}
+
+// DirectMessageWithID is DirectMessage with an event id, such as a change
+// log sequence number. A reconnecting client reports the last id it
+// received, see LastEventID, so the application can resume after it. Line
+// breaks are removed from id.
+func (s *SseSession) DirectMessageWithID(id string, msg SseMessage) error {
+	return s.directMessage(sseEnvelope{msg: msg, id: sseEventID(id)})
+}
+
+func (s *SseSession) directMessage(env sseEnvelope) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.closed {
-		return errors.New("session closed")
+		return ErrSseSessionClosed
 	}
 	select {
-	case s.direct_messages <- msg:
+	case s.direct_messages <- env:
 		return nil
 	default:
-		return errors.New("direct message buffer full")
+		return ErrSseBufferFull
 	}
 }
 
+// ErrSseAckTimeout is returned by DirectMessageAck when the client did not
+// acknowledge the message in time.
+var ErrSseAckTimeout = errors.New("timed out waiting for ack")
+
+// DirectMessageAck sends msg and waits until the client acknowledges it or
+// timeout elapses. The message is given an "id", unless it already has one,
+// and "ack_requested": true; sse.js answers by publishing
+// {"event": "ack", "id": ...} once its handlers have run. An ack whose id
+// matches no waiting DirectMessageAck, such as one arriving after the
+// timeout, is passed to OnCallback like any other callback. It fails with
+// ErrSseSessionClosed when the session ends before the ack arrives.
+func (s *SseSession) DirectMessageAck(msg SseMessage, timeout time.Duration) error {
+	id, _ := msg["id"].(string)
+	if id == "" {
+		id = CreateFastUniqueIdentifier()
+	}
+	copied := make(SseMessage, len(msg)+2)
+	for k, v := range msg {
+		copied[k] = v
+	}
+	copied["id"] = id
+	copied["ack_requested"] = true
+
+	acked := make(chan struct{})
+	s.mu.Lock()
+	if s.pendingAcks == nil {
+		s.pendingAcks = make(map[string]chan struct{})
+	}
+	s.pendingAcks[id] = acked
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pendingAcks, id)
+		s.mu.Unlock()
+	}()
+
+	if err := s.DirectMessage(copied); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-acked:
+		return nil
+	case <-s.done:
+		return ErrSseSessionClosed
+	case <-timer.C:
+		return ErrSseAckTimeout
+	}
+}
+
+// ack resolves the pending DirectMessageAck for id, reporting whether one
+// was waiting.
+func (s *SseSession) ack(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	acked, ok := s.pendingAcks[id]
+	if ok {
+		close(acked)
+		delete(s.pendingAcks, id)
+	}
+	return ok
+}
+
+// DirectMessageRaw queues plain, possibly multi-line, text for this
+// session. Each line is sent as its own data: line, as the SSE spec
+// requires, so the client receives the text joined back with "\n".
+func (s *SseSession) DirectMessageRaw(data string) error {
+	return s.directMessage(sseEnvelope{raw: data, isRaw: true})
+}
+
+// drain asks the session to write out its queued direct messages and end
+// the stream.
+func (s *SseSession) drain() {
+	s.drainOnce.Do(func() {
+		close(s.draining)
+	})
+}
+
 // Close shuts down the session exactly once.
 func (s *SseSession) Close() {
 	s.mu.Lock()
@@ -109,20 +434,247 @@ type FnSseCallback func(w http.ResponseWriter, r *http.Request, s *SseSession)
 
 // SseServer holds the global fanout and active client sessions.
 type SseServer struct {
-	Logging *logger.Logger
-	fanout  *mpmc.Producer[SseMessage]
-	factory SseEventHandlerFactory
-	clients map[ClientID]*SseSession
-	mu      sync.RWMutex
+	Logging        Logger
+	fanout         *mpmc.Producer[sseEnvelope]
+	factory        SseEventHandlerFactory
+	clients        map[ClientID]*SseSession
+	mu             sync.RWMutex
+	requireCSRF    bool
+	shuttingDown   bool
+	maxConnections int
+	highWater      int
+	flushInterval  time.Duration
+	compress       bool
+	idleTimeout    time.Duration
+	goingAwayRetry time.Duration
+	retryHint      time.Duration
+	links          []*SseServer
+	slowThreshold  int
+	onSlowConsumer func(*SseSession, int)
+	pingInterval   time.Duration
+	maxMissedPongs int
 }
 
+// DefaultGoingAwayRetry is the reconnect delay suggested to clients when
+// the server shuts down.
+const DefaultGoingAwayRetry = 30 * time.Second
+
 func (s *SseServer) String() string {
 	return "sse::server"
 }
 
-// Broadcast sends a message to all connected consumers.
+// Broadcast sends a message to all connected consumers, and to those of
+// the servers linked with Link.
 func (s *SseServer) Broadcast(msg SseMessage) {
-	s.fanout.Write(msg)
+	s.broadcast(sseEnvelope{msg: msg})
+}
+
+// BroadcastWithID is Broadcast with an event id, such as a change log
+// sequence number. A reconnecting client reports the last id it received,
+// see SseSession.LastEventID, so the application can resume the stream
+// after it. Line breaks are removed from id.
+func (s *SseServer) BroadcastWithID(id string, msg SseMessage) {
+	s.broadcast(sseEnvelope{msg: msg, id: sseEventID(id)})
+}
+
+func (s *SseServer) broadcast(env sseEnvelope) {
+	s.fanout.Write(env)
+
+	s.mu.RLock()
+	links := s.links
+	s.mu.RUnlock()
+	for _, linked := range links {
+		linked.fanout.Write(env)
+	}
+}
+
+// BroadcastTopic sends msg to the sessions subscribed to topic, including
+// those of linked servers. The client receives it with a "topic" field.
+func (s *SseServer) BroadcastTopic(topic string, msg SseMessage) {
+	copied := make(SseMessage, len(msg)+1)
+	for k, v := range msg {
+		copied[k] = v
+	}
+	copied["topic"] = topic
+	s.broadcast(sseEnvelope{msg: copied, topic: topic})
+}
+
+// ErrSseBroadcastTimeout is returned by BroadcastSync when not every
+// session wrote the message in time, and by BroadcastBlocking when not
+// every session had room for it.
+var ErrSseBroadcastTimeout = errors.New("timed out waiting for broadcast to be written")
+
+// BroadcastSync broadcasts msg like Broadcast and waits until every session
+// connected at the time, including those of linked servers, has written it
+// to its stream, or until timeout elapses. It returns how many did; the
+// error is ErrSseBroadcastTimeout if some did not. A write only means the
+// message was handed to the connection and flushed, not that the client
+// received it, see DirectMessageAck for that. Messages dropped by a
+// handler's OnMessage are never written and so count as missing, while
+// sessions that exclude the event with SseConfig.EventsQuery are not
+// waited for.
+func (s *SseServer) BroadcastSync(msg SseMessage, timeout time.Duration) (int, error) {
+	env := sseEnvelope{msg: msg}
+	expected := 0
+	for _, session := range s.sessionsWithLinks() {
+		if session.receives(&env) {
+			expected++
+		}
+	}
+
+	written := make(chan struct{}, expected)
+	env.written = written
+	s.broadcast(env)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for delivered := 0; delivered < expected; delivered++ {
+		select {
+		case <-written:
+		case <-timer.C:
+			return delivered, ErrSseBroadcastTimeout
+		}
+	}
+	return expected, nil
+}
+
+// signalWritten tells a waiting BroadcastSync that env has been written.
+// Sessions that connected after the broadcast began are not waited for,
+// so a full channel is skipped rather than blocked on.
+func signalWritten(env sseEnvelope) {
+	if env.written != nil {
+		select {
+		case env.written <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// sseBlockingRetry is how often BroadcastBlocking retries a session whose
+// queue is full.
+const sseBlockingRetry = 5 * time.Millisecond
+
+// BroadcastBlocking sends msg to every connected session, including those
+// of linked servers, without dropping it for sessions that are behind.
+// Broadcast is a lossy fast path: the fanout never waits, so under
+// sustained overload a slow client silently misses messages. Use
+// BroadcastBlocking instead for messages that must not be lost, such as
+// a "config changed" signal.
+//
+// The message is queued on each session's direct queue, waiting up to
+// timeout for room on the full ones. It returns how many sessions queued
+// it; the error is ErrSseBroadcastTimeout if some stayed full. Sessions
+// that exclude the event with SseConfig.EventsQuery are skipped.
+//
+// The price is that the caller is held back by the slowest client, up to
+// timeout, and that the message shares the queue used by DirectMessage,
+// so it may be written ahead of ordinary broadcasts still waiting in a
+// session's fanout buffer.
+func (s *SseServer) BroadcastBlocking(msg SseMessage, timeout time.Duration) (int, error) {
+	deadline := time.Now().Add(timeout)
+	env := sseEnvelope{msg: msg}
+
+	var queued atomic.Int64
+	var timedOut atomic.Bool
+	var wg sync.WaitGroup
+	for _, session := range s.sessionsWithLinks() {
+		if !session.receives(&env) {
+			continue
+		}
+		err := session.directMessage(env)
+		if err == nil {
+			queued.Add(1)
+			continue
+		}
+		if !errors.Is(err, ErrSseBufferFull) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(session *SseSession) {
+			defer wg.Done()
+			if session.directMessageUntil(env, deadline) == nil {
+				queued.Add(1)
+			} else if time.Now().After(deadline) {
+				timedOut.Store(true)
+			}
+		}(session)
+	}
+	wg.Wait()
+
+	if timedOut.Load() {
+		return int(queued.Load()), ErrSseBroadcastTimeout
+	}
+	return int(queued.Load()), nil
+}
+
+// directMessageUntil retries DirectMessage while the session's queue is
+// full, until deadline. It does not hold the session lock while waiting,
+// so the session can still be closed.
+func (s *SseSession) directMessageUntil(env sseEnvelope, deadline time.Time) error {
+	ticker := time.NewTicker(sseBlockingRetry)
+	defer ticker.Stop()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for {
+		err := s.directMessage(env)
+		if !errors.Is(err, ErrSseBufferFull) {
+			return err
+		}
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return ErrSseSessionClosed
+		case <-timer.C:
+			return err
+		}
+	}
+}
+
+// sessionsWithLinks returns the connected sessions of s and of the servers
+// linked to it.
+func (s *SseServer) sessionsWithLinks() []*SseSession {
+	s.mu.RLock()
+	sessions := make([]*SseSession, 0, len(s.clients))
+	for _, session := range s.clients {
+		sessions = append(sessions, session)
+	}
+	links := s.links
+	s.mu.RUnlock()
+
+	for _, linked := range links {
+		linked.mu.RLock()
+		for _, session := range linked.clients {
+			sessions = append(sessions, session)
+		}
+		linked.mu.RUnlock()
+	}
+	return sessions
+}
+
+// Link forwards every Broadcast on s to the clients of other as well, so
+// several SSE endpoints can share global messages such as system alerts
+// while keeping their own. Links are one-way; link both servers to each
+// other to share in both directions. A forwarded message is only delivered
+// to the linked server's own clients and not forwarded again, so mutual or
+// circular links cannot loop.
+func (s *SseServer) Link(other *SseServer) *SseServer {
+	if other == s {
+		return s
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !slices.Contains(s.links, other) {
+		s.links = append(slices.Clone(s.links), other)
+	}
+	return s
+}
+
+// BroadcastRaw sends plain, possibly multi-line, text to all connected
+// consumers. Each line is sent as its own data: line, as the SSE spec
+// requires, so the client receives the text joined back with "\n".
+func (s *SseServer) BroadcastRaw(data string) {
+	s.broadcast(sseEnvelope{raw: data, isRaw: true})
 }
 
 // Find retrieves a client session by client ID.
@@ -133,6 +685,29 @@ func (s *SseServer) Find(client_id ClientID) (*SseSession, bool) {
 	return session, exists
 }
 
+// DirectMessageMany queues msg for each of the given clients and reports
+// how many accepted it. Clients that could not be messaged are returned
+// with the reason: ErrSseSessionNotFound when not connected, otherwise the
+// DirectMessage error. failures is nil when every client accepted it.
+func (s *SseServer) DirectMessageMany(ids []ClientID, msg SseMessage) (delivered int, failures map[ClientID]error) {
+	for _, id := range ids {
+		session, ok := s.Find(id)
+		err := ErrSseSessionNotFound
+		if ok {
+			err = session.DirectMessage(msg)
+		}
+		if err == nil {
+			delivered++
+			continue
+		}
+		if failures == nil {
+			failures = make(map[ClientID]error)
+		}
+		failures[id] = err
+	}
+	return delivered, failures
+}
+
 // CloneClientList returns a copy of the client list. Can avoid mtx of range
 func (s *SseServer) CloneClientList() map[ClientID]*SseSession {
 	s.mu.RLock()
@@ -155,24 +730,439 @@ func (s *SseServer) Range(fn func(*SseSession) bool) {
 	}
 }
 
+// SetCSRFProtection requires callbacks to carry the session's CSRF token,
+// either in the X-CSRF-Token header or a csrf_token parameter. Callbacks
+// with a missing or wrong token are rejected with 403.
+func (s *SseServer) SetCSRFProtection(enabled bool) *SseServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requireCSRF = enabled
+	return s
+}
+
+// DefaultSseSlowConsumerThreshold is the queue depth at which a session
+// is reported to OnSlowConsumer.
+const DefaultSseSlowConsumerThreshold = 512
+
+// DefaultSseSlowConsumerInterval is how often queue depths are checked
+// while an OnSlowConsumer callback is set.
+const DefaultSseSlowConsumerInterval = time.Second
+
+// OnSlowConsumer sets fn to be called with a session and its QueueDepth
+// when the depth reaches the slow consumer threshold, so a client that
+// has fallen behind can be logged or disconnected before it starts losing
+// broadcasts. fn is called once each time the session crosses the
+// threshold, from a background goroutine, and must not block. Depths are
+// sampled every DefaultSseSlowConsumerInterval.
+func (s *SseServer) OnSlowConsumer(fn func(session *SseSession, depth int)) *SseServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onSlowConsumer = fn
+	return s
+}
+
+// SetSlowConsumerThreshold sets the queue depth reported to
+// OnSlowConsumer. It defaults to DefaultSseSlowConsumerThreshold.
+func (s *SseServer) SetSlowConsumerThreshold(depth int) *SseServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.slowThreshold = depth
+	return s
+}
+
+// checkSlowConsumers reports sessions whose queue depth has crossed the
+// threshold since the last check.
+func (s *SseServer) checkSlowConsumers() {
+	s.mu.RLock()
+	fn := s.onSlowConsumer
+	threshold := s.slowThreshold
+	sessions := make([]*SseSession, 0, len(s.clients))
+	for _, session := range s.clients {
+		sessions = append(sessions, session)
+	}
+	s.mu.RUnlock()
+	if fn == nil {
+		return
+	}
+	if threshold <= 0 {
+		threshold = DefaultSseSlowConsumerThreshold
+	}
+
+	for _, session := range sessions {
+		depth := session.QueueDepth()
+		if depth < threshold {
+			session.slow.Store(false)
+		} else if !session.slow.Swap(true) {
+			fn(session, depth)
+		}
+	}
+}
+
+// slowConsumerLoop runs checkSlowConsumers periodically until done is
+// closed.
+func (s *SseServer) slowConsumerLoop(done <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.checkSlowConsumers()
+		}
+	}
+}
+
+// DefaultSseReapInterval is how often each SSE server reconciles its
+// clients map, removing sessions whose request has already ended.
+const DefaultSseReapInterval = time.Minute
+
+var (
+	ErrSseTooManyConnections = errors.New("too many connections")
+	ErrSseDuplicateClientID  = errors.New("duplicate client id")
+)
+
+// SetMaxConnections limits the number of concurrent sessions. Connections
+// beyond the limit are rejected with 503. Zero, the default, is unlimited.
+func (s *SseServer) SetMaxConnections(max int) *SseServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxConnections = max
+	return s
+}
+
+// ConnectionCount returns the number of connected sessions.
+func (s *SseServer) ConnectionCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.clients)
+}
+
+// ConnectionHighWater returns the largest number of concurrently connected
+// sessions seen.
+func (s *SseServer) ConnectionHighWater() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.highWater
+}
+
+// add registers session, enforcing the connection limit and unique ids.
+// When the session's id is taken and suffix is set, the session is
+// registered as id + "-" + suffix instead.
+func (s *SseServer) add(session *SseSession, suffix ClientID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxConnections > 0 && len(s.clients) >= s.maxConnections {
+		return ErrSseTooManyConnections
+	}
+	if _, exists := s.clients[session.client_id]; exists && suffix != "" {
+		session.client_id += "-" + suffix
+	}
+	if _, exists := s.clients[session.client_id]; exists {
+		return ErrSseDuplicateClientID
+	}
+
+	s.clients[session.client_id] = session
+	if len(s.clients) > s.highWater {
+		s.highWater = len(s.clients)
+	}
+	return nil
+}
+
+// remove unregisters session if it is still the one registered for its id.
+func (s *SseServer) remove(session *SseSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[session.client_id] == session {
+		delete(s.clients, session.client_id)
+	}
+}
+
+// reap removes sessions whose request context is done but which are still
+// registered, and returns how many were removed.
+func (s *SseServer) reap() int {
+	s.mu.Lock()
+	var stale []*SseSession
+	for id, session := range s.clients {
+		if session.ctx != nil && session.ctx.Err() != nil {
+			delete(s.clients, id)
+			stale = append(stale, session)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, session := range stale {
+		session.Close()
+	}
+	return len(stale)
+}
+
+// reapLoop runs reap periodically until done is closed.
+func (s *SseServer) reapLoop(done <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if n := s.reap(); n > 0 {
+				s.Logging.Errorln("reaped stale sessions:", n)
+			}
+		}
+	}
+}
+
+// SetFlushInterval batches writes to each client: messages written within
+// d of the first unflushed one are sent with a single flush, trading up to d
+// of latency for fewer syscalls and TCP segments under bursts. Zero, the
+// default, flushes after every message. Applies to new connections.
+func (s *SseServer) SetFlushInterval(d time.Duration) *SseServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushInterval = d
+	return s
+}
+
+// SetCompression gzips event streams for clients that advertise gzip in
+// Accept-Encoding. Each flush also flushes the compressor, so messages are
+// still delivered as they are sent. It is off by default because some
+// proxies buffer or mishandle compressed event streams. Applies to new
+// connections.
+func (s *SseServer) SetCompression(enabled bool) *SseServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.compress = enabled
+	return s
+}
+
+// DefaultSsePingInterval is how long a stream may go without a message
+// before a ping is sent.
+const DefaultSsePingInterval = 60 * time.Second
+
+// SetPingInterval sets how long a stream may go without a message before a
+// ping is sent. It defaults to DefaultSsePingInterval. Applies to new
+// connections.
+func (s *SseServer) SetPingInterval(d time.Duration) *SseServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pingInterval = d
+	return s
+}
+
+// SetMaxMissedPongs closes sessions whose client has not answered the last
+// n pings with a pong. Writes into a half-open connection keep succeeding
+// until the TCP buffers fill, so this detects clients that are gone long
+// before a write fails. Only enable it when every client answers pings, as
+// sse.js does; zero, the default, never closes sessions for missing pongs.
+// Applies to new connections.
+func (s *SseServer) SetMaxMissedPongs(n int) *SseServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxMissedPongs = n
+	return s
+}
+
+// SetIdleTimeout closes sessions that have neither written to their client
+// nor received a callback from it for d. Pings are writes and count as
+// activity, so with d above the ping interval a session only goes
+// idle when writes stall, such as a client that stopped reading until the
+// connection's buffers filled. Zero, the default, never closes idle
+// sessions. Applies to new connections.
+func (s *SseServer) SetIdleTimeout(d time.Duration) *SseServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idleTimeout = d
+	return s
+}
+
+// watchIdle ends the session once it has been idle for timeout. A write
+// stuck on a client that stopped reading is unblocked by expiring the
+// connection's write deadline.
+func (s *SseServer) watchIdle(ctx context.Context, cancel context.CancelFunc, rc *http.ResponseController, session *SseSession, timeout time.Duration) {
+	interval := timeout / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if session.idleFor() >= timeout {
+				logDebugw(s.Logging, "session idle", "client_id", session.client_id)
+				rc.SetWriteDeadline(time.Now())
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// sseLastEventID reads the id a reconnecting client last received.
+// EventSource sends it as a header on its own reconnects; clients that open
+// a new EventSource instead pass it as a query parameter.
+func sseLastEventID(r *http.Request) string {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("last_event_id")
+}
+
+// sseEventFilter reads the comma separated event types listed in the query
+// parameter name, or nil when there are none.
+func sseEventFilter(r *http.Request, name string) map[string]bool {
+	if name == "" {
+		return nil
+	}
+	var events map[string]bool
+	for _, value := range r.URL.Query()[name] {
+		for _, event := range strings.Split(value, ",") {
+			if event = strings.TrimSpace(event); event != "" {
+				if events == nil {
+					events = make(map[string]bool)
+				}
+				events[event] = true
+			}
+		}
+	}
+	return events
+}
+
+// SetRetryHint sends d as the SSE retry: field with on_connect, so
+// EventSource clients wait that long before reconnecting after the stream
+// drops. The going_away message on shutdown carries its own hint, see
+// SetGoingAwayRetry. Zero, the default, leaves the delay to the client.
+func (s *SseServer) SetRetryHint(d time.Duration) *SseServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retryHint = d
+	return s
+}
+
+// SetGoingAwayRetry sets the reconnect delay sent to clients in the
+// going_away message on shutdown.
+func (s *SseServer) SetGoingAwayRetry(d time.Duration) *SseServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.goingAwayRetry = d
+	return s
+}
+
+// Shutdown sends every session a going_away event with a retry: hint, so
+// clients back off instead of reconnecting to an instance that is stopping,
+// then ends the sessions. New connections are refused with 503 from then on.
+// Service.Shutdown calls it for every registered SSE server.
+func (s *SseServer) Shutdown() {
+	s.mu.Lock()
+	s.shuttingDown = true
+	retry := s.goingAwayRetry
+	sessions := make([]*SseSession, 0, len(s.clients))
+	for _, session := range s.clients {
+		sessions = append(sessions, session)
+	}
+	s.mu.Unlock()
+
+	if retry <= 0 {
+		retry = DefaultGoingAwayRetry
+	}
+
+	for _, session := range sessions {
+		session.directMessage(sseEnvelope{
+			msg: SseMessage{
+				"event":    "going_away",
+				"retry_ms": retry.Milliseconds(),
+			},
+			retry: retry,
+		})
+		session.drain()
+	}
+}
+
 // SetLoggingLevel sets the logging level for the server.
 func (s *SseServer) SetLoggingLevel(level logger.LogLevel) *SseServer {
 	s.Logging.SetLevel(level)
 	return s
 }
 
-// Start creates the SSE server and registers its HTTP routes.
+// SseConfig customizes the routes registered by RegisterSSEWithConfig.
+// The zero value matches RegisterSSE.
+//
+// Callbacks reach the handler through two paths. The callback route
+// (uri + CallbackPath) always dispatches to OnCallback and, being the longer
+// pattern, takes precedence for its own path. The main SSE route additionally
+// treats requests that carry X-Client-ID but do not accept text/event-stream
+// as callbacks, unless DisableInlineCallback is set. DisableCallbacks
+// removes both.
+type SseConfig struct {
+	// CallbackPath is appended to the SSE uri for the callback route.
+	// Defaults to "/callback".
+	CallbackPath string
+	// CallbackMethods are the methods accepted by the callback route.
+	// Defaults to POST.
+	CallbackMethods []string
+	// DisableInlineCallback makes the main SSE route serve only the event
+	// stream.
+	DisableInlineCallback bool
+	// DisableCallbacks registers no callback route and makes the main SSE
+	// route serve only the event stream, for strictly server to client
+	// streams that should not expose a POST endpoint. OnCallback is never
+	// called, and clients cannot answer pings, acknowledge messages, or
+	// subscribe to topics, so SetMaxMissedPongs, DirectMessageAck, and
+	// BroadcastTopic cannot be used.
+	DisableCallbacks bool
+	// ClientID chooses the id of a new session, which is also the key
+	// callbacks are looked up by, for example the authenticated username.
+	// When nil or returning "", the broadcast consumer id is used. A
+	// connection whose id is already connected, such as a user's second
+	// tab, gets the id suffixed with "-" and its consumer id; on_connect
+	// tells the client the id it was given.
+	ClientID func(r *http.Request) ClientID
+	// AuthorizeTopic decides whether a client may subscribe to topic with
+	// a __subscribe callback. When nil every such subscription is refused,
+	// so topics stay private unless the application opts in; the server
+	// can still call SseSession.Subscribe itself. Refused subscriptions are
+	// answered with 403.
+	AuthorizeTopic func(r *http.Request, session *SseSession, topic string) bool
+	// EventsQuery names a query parameter in which clients list the event
+	// types they want from broadcasts, for example "events" for
+	// ?events=chat_message,user_join. Broadcasts whose Event is not listed,
+	// including those without an event, are not sent to such a client.
+	// Direct messages are not filtered. Empty disables the filter.
+	EventsQuery string
+}
+
+// RegisterSSE creates the SSE server and registers its HTTP routes.
 func (svc *Service) RegisterSSE(uri string, factory SseEventHandlerFactory) *SseServer {
+	return svc.RegisterSSEWithConfig(uri, factory, SseConfig{})
+}
+
+// RegisterSSEWithConfig is like RegisterSSE with control over how callbacks
+// are routed.
+func (svc *Service) RegisterSSEWithConfig(uri string, factory SseEventHandlerFactory, config SseConfig) *SseServer {
+	if config.CallbackPath == "" {
+		config.CallbackPath = "/callback"
+	}
+	if len(config.CallbackMethods) == 0 {
+		config.CallbackMethods = []string{"POST"}
+	}
+
 	srv := &SseServer{
-		fanout:  mpmc.NewProducer[SseMessage](mpmc.ProducerKind_All, 2048, 2048),
-		Logging: logger.NewLogger("sse::" + uri),
+		fanout:  mpmc.NewProducer[sseEnvelope](mpmc.ProducerKind_All, 2048, 2048),
+		Logging: svc.newLogger("sse::" + uri),
 		factory: factory,
 		clients: make(map[ClientID]*SseSession),
 	}
 
-	callbacks := []string{
-		uri + "/callback",
-	}
+	svc.mu.Lock()
+	svc.sseServers = append(svc.sseServers, srv)
+	svc.mu.Unlock()
+
+	go srv.reapLoop(svc.done, DefaultSseReapInterval)
+	go srv.slowConsumerLoop(svc.done, DefaultSseSlowConsumerInterval)
 
 	handleCallback := func(w http.ResponseWriter, r *http.Request) {
 		var clientID ClientID = ""
@@ -205,166 +1195,352 @@ func (svc *Service) RegisterSSE(uri string, factory SseEventHandlerFactory) *Sse
 
 		srv.mu.RLock()
 		session, exists := srv.clients[clientID]
+		requireCSRF := srv.requireCSRF
 		srv.mu.RUnlock()
 		if !exists {
 			WriteError(w, errors.New("client not found"))
 			return
 		}
 
+		if requireCSRF {
+			token := r.Header.Get("X-CSRF-Token")
+			if token == "" {
+				token, _ = HttpParameterT[string](r, "csrf_token")
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(session.csrf_token)) != 1 {
+				WriteErrorCode(w, http.StatusForbidden, errors.New("invalid csrf token"))
+				return
+			}
+		}
+
+		session.touch()
+
+		event, _ := HttpParameterT[string](r, "event")
+		if event == "pong" {
+			session.lastPong.Store(time.Now().UnixNano())
+		}
+
+		// an ack is only taken here when it resolves a DirectMessageAck;
+		// applications may use "ack" for their own messages too
+		if event == "ack" {
+			id, _ := HttpParameterT[string](r, "id")
+			if id != "" && session.ack(id) {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+
+		// __subscribe and __unsubscribe turn the callback channel into a
+		// control channel for the session's topics; the prefix keeps them
+		// apart from application events, which reach OnCallback
+		if event == sseSubscribeEvent || event == sseUnsubscribeEvent {
+			topic, _ := HttpParameterT[string](r, "topic")
+			if topic == "" {
+				WriteError(w, errors.New("missing topic"))
+				return
+			}
+			if event == sseUnsubscribeEvent {
+				session.Unsubscribe(topic)
+			} else if config.AuthorizeTopic == nil || !config.AuthorizeTopic(r, session, topic) {
+				WriteErrorCode(w, http.StatusForbidden, errors.New("topic not allowed"))
+				return
+			} else {
+				session.Subscribe(topic)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
 		if session.user_handler != nil {
 			session.user_handler.OnCallback(w, r)
 		}
 	}
 
 	// Register callback endpoints.
-	for _, callbackURL := range callbacks {
-		// capture the current URL
-		url := callbackURL
-		svc.RegisterRoutePOST(url, handleCallback)
+	if !config.DisableCallbacks {
+		for _, method := range config.CallbackMethods {
+			svc.RegisterRoute(uri+config.CallbackPath, method, handleCallback)
+		}
 	}
 
 	// Register the main SSE route.
-	// This route is used for both SSE and callback messages.
+	// Unless disabled, this route is used for both SSE and callback messages.
 	svc.RegisterRoute(uri, "*", func(w http.ResponseWriter, r *http.Request) {
 		acceptHeader := r.Header.Get("Accept")
 		clientID := r.Header.Get("X-Client-ID")
 		if !containsAcceptType(acceptHeader, "text/event-stream") && clientID != "" {
-			handleCallback(w, r)
+			if config.DisableCallbacks {
+				// a callback must not open a stream instead
+				WriteErrorCode(w, http.StatusNotFound, errors.New("callbacks disabled"))
+				return
+			}
+			if !config.DisableInlineCallback {
+				handleCallback(w, r)
+				return
+			}
+		}
+
+		srv.mu.RLock()
+		shuttingDown := srv.shuttingDown
+		srv.mu.RUnlock()
+		if shuttingDown {
+			WriteErrorCode(w, http.StatusServiceUnavailable, errors.New("shutting down"))
 			return
 		}
 
-		// Set SSE headers.
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
+		// Event streams are long-lived, lift the server read/write deadlines
+		// for this connection so the configured timeouts don't cut them off.
+		rc := http.NewResponseController(w)
+		rc.SetReadDeadline(time.Time{})
+		rc.SetWriteDeadline(time.Time{})
 
 		rctx, cancel := context.WithCancel(r.Context())
 		defer cancel()
 
 		broadcastConsumer := srv.fanout.CreateConsumer(rctx)
-		client_id := ClientID(broadcastConsumer.Id())
+		consumer_id := ClientID(broadcastConsumer.Id())
+		client_id := consumer_id
+		var suffix ClientID
+		if config.ClientID != nil {
+			if custom := config.ClientID(r); custom != "" {
+				client_id, suffix = custom, consumer_id
+			}
+		}
 
 		session := &SseSession{
+			ctx:                rctx,
 			client_id:          client_id,
+			csrf_token:         CreateFastUniqueIdentifier(),
+			lastEventID:        sseLastEventID(r),
+			events:             sseEventFilter(r, config.EventsQuery),
 			done:               make(chan struct{}),
-			direct_messages:    make(chan SseMessage, 256),
+			direct_messages:    make(chan sseEnvelope, 256),
+			draining:           make(chan struct{}),
 			broadcast_messages: broadcastConsumer,
 		}
-		srv.mu.Lock()
-		srv.clients[client_id] = session
-		srv.mu.Unlock()
-		defer session.Close()
+		if err := srv.add(session, suffix); err != nil {
+			broadcastConsumer.Close()
+			statusCode := http.StatusServiceUnavailable
+			if errors.Is(err, ErrSseDuplicateClientID) {
+				statusCode = http.StatusConflict
+			}
+			WriteErrorCode(w, statusCode, err)
+			return
+		}
+		// Registered before any user code runs, so a panic in the handler
+		// callbacks cannot leave the session behind in the clients map.
+		defer func() {
+			srv.remove(session)
+			session.Close()
+		}()
+
+		srv.mu.RLock()
+		compress := srv.compress
+		idleTimeout := srv.idleTimeout
+		retryHint := srv.retryHint
+		srv.mu.RUnlock()
 
-		// Initialize the user handler.
+		// Initialize the user handler before anything is written, so it can
+		// still reject the connection with a plain error response.
 		if srv.factory != nil {
 			uh := srv.factory()
 			if uh != nil {
 				session.user_handler = uh
 				if err := uh.OnInitialize(w, r, srv, session); err != nil {
-					WriteError(w, err)
+					statusCode := http.StatusBadRequest
+					var rejection *SseRejection
+					if errors.As(err, &rejection) {
+						statusCode = rejection.StatusCode
+					}
+					WriteErrorCode(w, statusCode, err)
 					return
 				}
 			}
 		}
 
-		// On disconnect, call the disconnect callback and clean up.
+		session.touch()
+		if idleTimeout > 0 {
+			go srv.watchIdle(rctx, cancel, rc, session, idleTimeout)
+		}
+
+		// Set SSE headers.
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		// Connection is a hop-by-hop header that HTTP/2 forbids; streams
+		// there stay open without it.
+		if r.ProtoMajor < 2 {
+			w.Header().Set("Connection", "keep-alive")
+		}
+		if compress && acceptsGzip(r) {
+			gw := newGzipFlushWriter(w)
+			defer gw.Close()
+			w = gw
+		}
+
+		logDebugw(srv.Logging, "session connected", "client_id", session.client_id, "remote", HttpRemoteIP(r))
+
+		// On disconnect, call the disconnect callback.
 		defer func() {
-			srv.mu.Lock()
-			delete(srv.clients, session.client_id)
-			srv.mu.Unlock()
+			logDebugw(srv.Logging, "session disconnected", "client_id", session.client_id)
 
 			if session.user_handler != nil {
 				session.user_handler.OnDisconnect(w, r)
 			}
 		}()
 
-		// Send the client ID to the client.
-		session.DirectMessage(SseMessage{
+		srv.mu.RLock()
+		pingInterval := srv.pingInterval
+		maxMissedPongs := srv.maxMissedPongs
+		srv.mu.RUnlock()
+		if pingInterval <= 0 {
+			pingInterval = DefaultSsePingInterval
+		}
+		pingTicker := time.NewTicker(pingInterval)
+		defer pingTicker.Stop()
+		var pingSent time.Time
+		missedPongs := 0
+
+		// With a flush interval, writes are batched and flushed when the
+		// timer armed by the first unflushed write fires.
+		srv.mu.RLock()
+		flushInterval := srv.flushInterval
+		srv.mu.RUnlock()
+		var flushC <-chan time.Time
+		var flushTimer *time.Timer
+		flushPending := false
+		if flushInterval > 0 {
+			flushTimer = time.NewTimer(flushInterval)
+			flushTimer.Stop()
+			defer flushTimer.Stop()
+			flushC = flushTimer.C
+		}
+
+		// send filters msg through the user handler and writes it, reporting
+		// false once the stream can no longer be written.
+		send := func(env sseEnvelope) bool {
+			if env.topic != "" && !session.Subscribed(env.topic) {
+				return true
+			}
+			if session.user_handler != nil && !session.user_handler.OnMessage(w, r, env.msg) {
+				return true
+			}
+
+			tracked := env.written != nil
+			if err := writeSseEnvelope(w, env, flushInterval <= 0 || tracked); err != nil {
+				logDebugw(srv.Logging, "write failed", "client_id", session.client_id, "error", err)
+				return false
+			}
+			signalWritten(env)
+			session.touch()
+			if flushInterval > 0 && !flushPending {
+				flushPending = true
+				flushTimer.Reset(flushInterval)
+			}
+
+			pingTicker.Reset(pingInterval)
+			return true
+		}
+
+		flush := func() {
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+			if flushTimer != nil {
+				flushTimer.Stop()
+			}
+			flushPending = false
+		}
+
+		// Send the client ID to the client and run the connect callback
+		// before the message loop starts, so on_connect is always the first
+		// message a client sees, ahead of any broadcast.
+		connectMsg := SseMessage{
 			"event":       "on_connect",
 			"observer_id": session.client_id,
 			"client_id":   session.client_id,
-		})
+			"csrf_token":  session.csrf_token,
+		}
+		if config.DisableCallbacks {
+			connectMsg["callbacks"] = false
+		} else if config.DisableInlineCallback {
+			connectMsg["callback_path"] = config.CallbackPath
+		}
+		if retryHint > 0 {
+			connectMsg["retry_ms"] = retryHint.Milliseconds()
+		}
+		if !send(sseEnvelope{msg: connectMsg, retry: retryHint}) {
+			return
+		}
+		flush()
+		markStreamStarted(w)
 
-		// Call the connect callback.
+		// Call the connect callback. The stream has already started, so an
+		// error can only end it.
 		if session.user_handler != nil {
 			if err := session.user_handler.OnConnect(w, r); err != nil {
-				WriteError(w, err)
+				logDebugw(srv.Logging, "connect rejected", "client_id", session.client_id, "error", err)
 				return
 			}
 		}
 
 		done := rctx.Done()
 
-		pingInterval := 60 * time.Second
-		pingTicker := time.NewTicker(pingInterval)
-		defer pingTicker.Stop()
-
 		for {
 			select {
 			// Broadcast messages.
-			case msg, ok := <-session.broadcast_messages.Messages:
+			case env, ok := <-session.broadcast_messages.Messages:
 				if !ok {
 					return
 				}
-
-				if session.user_handler != nil && !session.user_handler.OnMessage(w, r, msg) {
+				if session.events != nil && !session.events[env.event()] {
 					continue
 				}
-
-				if encoded, err := msg.Encode(); err == nil {
-					if _, err := w.Write(encoded); err != nil {
-						return
-					}
-				} else {
-					WriteError(w, err)
+				if !send(env) {
 					return
 				}
-
-				if flusher, ok := w.(http.Flusher); ok {
-					flusher.Flush()
-					pingTicker.Reset(pingInterval)
-				}
 			// Direct messages.
 			case directMsg, ok := <-session.direct_messages:
-				if !ok {
+				if !ok || !send(directMsg) {
 					return
 				}
-
-				if session.user_handler != nil && !session.user_handler.OnMessage(w, r, directMsg) {
-					continue
-				}
-
-				if encoded, err := directMsg.Encode(); err == nil {
-					if _, err := w.Write(encoded); err != nil {
+			// Ping messages.
+			case <-pingTicker.C:
+				if maxMissedPongs > 0 && !pingSent.IsZero() {
+					if session.lastPong.Load() < pingSent.UnixNano() {
+						missedPongs++
+					} else {
+						missedPongs = 0
+					}
+					if missedPongs >= maxMissedPongs {
+						logDebugw(srv.Logging, "client stopped answering pings", "client_id", session.client_id, "missed", missedPongs)
 						return
 					}
-				} else {
-					WriteError(w, err)
-					return
 				}
 
-				if flusher, ok := w.(http.Flusher); ok {
-					flusher.Flush()
-					pingTicker.Reset(pingInterval)
-				}
-			// Ping messages.
-			case <-pingTicker.C:
 				pingMsg := SseMessage{
 					"event":   "ping",
 					"payload": time.Now().Unix(),
 				}
 
-				if encoded, err := pingMsg.Encode(); err == nil {
-					if _, err := w.Write(encoded); err != nil {
-						return
-					}
-				} else {
-					WriteError(w, err)
+				if err := writeSseEnvelope(w, sseEnvelope{msg: pingMsg}, true); err != nil {
 					return
 				}
-
-				if flusher, ok := w.(http.Flusher); ok {
-					flusher.Flush()
+				pingSent = time.Now()
+				session.touch()
+			// Batched writes are due.
+			case <-flushC:
+				flush()
+			// Shutdown: flush queued direct messages, then end the stream.
+			case <-session.draining:
+				for {
+					select {
+					case directMsg, ok := <-session.direct_messages:
+						if !ok || !send(directMsg) {
+							return
+						}
+					default:
+						return
+					}
 				}
 			case <-done:
 				return
@@ -0,0 +1,357 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSseClientNotConnected is returned by SseClient.Publish before the
+// server has sent on_connect.
+var ErrSseClientNotConnected = errors.New("sse client not connected")
+
+// ErrSseCallbacksDisabled is returned by SseClient.Publish when the server
+// was registered with SseConfig.DisableCallbacks.
+var ErrSseCallbacksDisabled = errors.New("sse server accepts no callbacks")
+
+// SseEvent is an event received by SseClient.
+type SseEvent struct {
+	// ID is the SSE id: field, empty when the server sent none.
+	ID string
+	// Event is the "event" of Message, or else the SSE event: field.
+	Event string
+	// Data is the event's data, its lines joined with "\n".
+	Data string
+	// Message is Data decoded as a JSON object. It is nil for other data,
+	// such as text sent with BroadcastRaw.
+	Message SseMessage
+}
+
+// SseClient consumes an event stream served by RegisterSSE from Go, the
+// counterpart of the embedded sse.js for service-to-service use. Like the
+// script it reconnects with backoff, resumes with Last-Event-ID, answers
+// pings and ack requests, and subscribes to its topics again on every new
+// session.
+type SseClient struct {
+	url     string
+	options SseClientOptions
+	client  *http.Client
+
+	mu          sync.Mutex
+	clientID    ClientID
+	csrfToken   string
+	callbackURL string
+	lastEventID string
+	retryHint   time.Duration
+	topics      map[string]bool
+	noCallbacks bool
+}
+
+// NewSseClient returns a client for the SSE endpoint at url. options are
+// those of sse.js: the reconnect backoff and the callback path Publish
+// posts to, which a server registered with DisableInlineCallback replaces
+// on connect.
+func NewSseClient(url string, options SseClientOptions) *SseClient {
+	if options.ReconnectDelay <= 0 {
+		options.ReconnectDelay = 3 * time.Second
+	}
+	if options.MaxReconnectDelay < options.ReconnectDelay {
+		options.MaxReconnectDelay = max(60*time.Second, options.ReconnectDelay)
+	}
+	return &SseClient{
+		url:         url,
+		options:     options,
+		client:      &http.Client{},
+		callbackURL: url + options.CallbackPath,
+		topics:      make(map[string]bool),
+	}
+}
+
+// SetHTTPClient sets the client used for the stream and for Publish. It
+// must not have a Timeout, which would end every stream after it.
+func (c *SseClient) SetHTTPClient(client *http.Client) *SseClient {
+	c.client = client
+	return c
+}
+
+// ClientID returns the id of the current session, empty until connected.
+func (c *SseClient) ClientID() ClientID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.clientID
+}
+
+// LastEventID returns the id of the last message received with one.
+func (c *SseClient) LastEventID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastEventID
+}
+
+// Connect streams events from the server until ctx is cancelled, then
+// closes the returned channel. Every event the server sends is delivered,
+// including on_connect after each reconnect and ping. Decode an event's
+// Message into a struct with SseMessageInto. Connect must be called only
+// once.
+func (c *SseClient) Connect(ctx context.Context) <-chan SseEvent {
+	messages := make(chan SseEvent, 64)
+	go func() {
+		defer close(messages)
+		attempts := 0
+		for {
+			opened, err := c.stream(ctx, messages)
+			if ctx.Err() != nil {
+				return
+			}
+			if opened {
+				attempts = 0
+			}
+			if err != nil && !errors.Is(err, io.EOF) {
+				c.logError("sse client: stream failed", "error", err)
+			}
+
+			timer := time.NewTimer(c.reconnectDelay(attempts))
+			attempts++
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}()
+	return messages
+}
+
+// logError reports an error the client recovers from to the Logger of its
+// options, if any.
+func (c *SseClient) logError(msg string, kv ...interface{}) {
+	if c.options.Logger != nil {
+		logErrorw(c.options.Logger, msg, kv...)
+	}
+}
+
+// reconnectDelay returns the backoff before the next attempt, doubling
+// from ReconnectDelay with jitter, and at least the server's going_away
+// hint.
+func (c *SseClient) reconnectDelay(attempts int) time.Duration {
+	c.mu.Lock()
+	hint := c.retryHint
+	c.retryHint = 0
+	c.mu.Unlock()
+
+	ceiling := c.options.ReconnectDelay << min(attempts, 16)
+	if ceiling > c.options.MaxReconnectDelay || ceiling <= 0 {
+		ceiling = c.options.MaxReconnectDelay
+	}
+	delay := ceiling/2 + time.Duration(rand.Int63n(int64(ceiling/2)+1))
+	return max(delay, hint)
+}
+
+// stream reads one connection until it ends, reporting whether it was
+// opened.
+func (c *SseClient) stream(ctx context.Context, messages chan<- SseEvent) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if id := c.LastEventID(); id != "" {
+		req.Header.Set("Last-Event-ID", id)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxInvokeErrorBody))
+		return false, fmt.Errorf("sse connect: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	var data []string
+	var event, id string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return true, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "" {
+			if data != nil {
+				if !c.dispatch(ctx, messages, strings.Join(data, "\n"), event, id) {
+					return true, ctx.Err()
+				}
+			}
+			data, event, id = nil, "", ""
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "data":
+			data = append(data, value)
+		case "event":
+			event = value
+		case "id":
+			id = value
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms > 0 {
+				c.options.ReconnectDelay = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// dispatch decodes one event, handles the events sse.js handles itself,
+// and delivers it. It reports false when ctx was cancelled first.
+func (c *SseClient) dispatch(ctx context.Context, messages chan<- SseEvent, data, event, id string) bool {
+	ev := SseEvent{ID: id, Event: event, Data: data}
+	msg := SseMessage{}
+	if json.Unmarshal([]byte(data), &msg) == nil && msg != nil {
+		ev.Message = msg
+		if name := msg.Event(); name != "" {
+			ev.Event = name
+		}
+	}
+	if id != "" {
+		c.mu.Lock()
+		c.lastEventID = id
+		c.mu.Unlock()
+	}
+
+	switch ev.Event {
+	case "on_connect":
+		c.connected(ctx, ev.Message)
+	case "going_away":
+		if ms, ok := ev.Message["retry_ms"].(float64); ok {
+			c.mu.Lock()
+			c.retryHint = time.Duration(ms) * time.Millisecond
+			c.mu.Unlock()
+		}
+	case "ping":
+		go c.Publish(ctx, SseMessage{"event": "pong", "payload": ev.Message["payload"]})
+	}
+
+	select {
+	case messages <- ev:
+	case <-ctx.Done():
+		return false
+	}
+
+	if requested, _ := ev.Message["ack_requested"].(bool); requested {
+		if ackID, ok := ev.Message["id"].(string); ok {
+			go c.Publish(ctx, SseMessage{"event": "ack", "id": ackID})
+		}
+	}
+	return true
+}
+
+// connected records the new session from on_connect and subscribes it to
+// the client's topics.
+func (c *SseClient) connected(ctx context.Context, msg SseMessage) {
+	c.mu.Lock()
+	clientID, _ := msg["client_id"].(string)
+	c.clientID = ClientID(clientID)
+	c.csrfToken, _ = msg["csrf_token"].(string)
+	c.noCallbacks = msg["callbacks"] == false
+	if path, ok := msg["callback_path"].(string); ok && path != "" {
+		c.callbackURL = c.url + path
+	}
+	if ms, ok := msg["retry_ms"].(float64); ok && ms > 0 {
+		c.options.ReconnectDelay = time.Duration(ms) * time.Millisecond
+	}
+	topics := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	c.mu.Unlock()
+
+	for _, topic := range topics {
+		if err := c.Publish(ctx, SseMessage{"event": sseSubscribeEvent, "topic": topic}); err != nil {
+			c.logError("sse client: subscribe failed", "topic", topic, "error", err)
+		}
+	}
+}
+
+// Publish posts msg to the server's callback route for the current
+// session, where it reaches the handler's OnCallback. It fails with
+// ErrSseClientNotConnected before the first on_connect, and with
+// ErrSseCallbacksDisabled when the server accepts no callbacks.
+func (c *SseClient) Publish(ctx context.Context, msg SseMessage) error {
+	c.mu.Lock()
+	clientID, csrfToken, callbackURL := c.clientID, c.csrfToken, c.callbackURL
+	noCallbacks := c.noCallbacks
+	c.mu.Unlock()
+	if clientID == "" {
+		return ErrSseClientNotConnected
+	}
+	if noCallbacks {
+		return ErrSseCallbacksDisabled
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Client-ID", string(clientID))
+	if csrfToken != "" {
+		req.Header.Set("X-CSRF-Token", csrfToken)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxInvokeErrorBody))
+		return fmt.Errorf("sse publish: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// Subscribe receives messages the server sends to topic with
+// BroadcastTopic, now if connected and again on every reconnect.
+func (c *SseClient) Subscribe(ctx context.Context, topic string) error {
+	c.mu.Lock()
+	c.topics[topic] = true
+	c.mu.Unlock()
+	err := c.Publish(ctx, SseMessage{"event": sseSubscribeEvent, "topic": topic})
+	if errors.Is(err, ErrSseClientNotConnected) {
+		return nil
+	}
+	return err
+}
+
+// Unsubscribe stops receiving topic.
+func (c *SseClient) Unsubscribe(ctx context.Context, topic string) error {
+	c.mu.Lock()
+	delete(c.topics, topic)
+	c.mu.Unlock()
+	err := c.Publish(ctx, SseMessage{"event": sseUnsubscribeEvent, "topic": topic})
+	if errors.Is(err, ErrSseClientNotConnected) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// NewSseStructMessage converts v into an SseMessage through its JSON
+// encoding, so json tags apply, and sets its "event". The event name comes
+// from an sse tag on any field, conventionally a blank one:
+//
+//	type UserJoined struct {
+//		_    struct{} `sse:"user_joined"`
+//		Name string   `json:"name"`
+//	}
+//
+// Without a tag the type name is used in snake case, so UserJoined becomes
+// "user_joined". An "event" field of v itself takes precedence over both.
+func NewSseStructMessage[T any](v T) (SseMessage, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("sse message: %T is not a struct", v)
+	}
+
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	msg := SseMessage{}
+	if err := json.Unmarshal(encoded, &msg); err != nil {
+		return nil, err
+	}
+	if _, ok := msg["event"]; !ok {
+		msg["event"] = sseEventName(t)
+	}
+	return msg, nil
+}
+
+// BroadcastStruct broadcasts v on server as built by NewSseStructMessage.
+func BroadcastStruct[T any](server *SseServer, v T) error {
+	msg, err := NewSseStructMessage(v)
+	if err != nil {
+		return err
+	}
+	server.Broadcast(msg)
+	return nil
+}
+
+// SseMessageInto decodes msg into T through its JSON encoding, the
+// reverse of NewSseStructMessage, for the SseEvent.Message of events
+// received with SseClient.
+func SseMessageInto[T any](msg SseMessage) (result T, err error) {
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(encoded, &result)
+	return result, err
+}
+
+// sseEventName returns the sse tag of the first field that has one, or the
+// type name in snake case.
+func sseEventName(t reflect.Type) string {
+	for i := 0; i < t.NumField(); i++ {
+		if name, ok := t.Field(i).Tag.Lookup("sse"); ok && name != "" {
+			return name
+		}
+	}
+
+	var b strings.Builder
+	runes := []rune(t.Name())
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// start a word at a lower-to-upper change, and before the last
+			// capital of an acronym followed by lowercase, as in HTTPError
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+type UserJoined struct {
+	Name string `json:"name"`
+}
+
+type tagged struct {
+	_     struct{} `sse:"custom_name"`
+	Count int      `json:"count"`
+}
+
+type HTTPError struct {
+	Code int
+}
+
+type withEvent struct {
+	Event string `json:"event"`
+}
+
+func TestSseStructEventNames(t *testing.T) {
+	for _, tc := range []struct {
+		value any
+		want  SseMessage
+	}{
+		{UserJoined{Name: "ada"}, SseMessage{"event": "user_joined", "name": "ada"}},
+		{&UserJoined{Name: "bob"}, SseMessage{"event": "user_joined", "name": "bob"}},
+		{tagged{Count: 2}, SseMessage{"event": "custom_name", "count": float64(2)}},
+		{HTTPError{Code: 500}, SseMessage{"event": "http_error", "Code": float64(500)}},
+		{withEvent{Event: "explicit"}, SseMessage{"event": "explicit"}},
+	} {
+		got, err := NewSseStructMessage(tc.value)
+		if err != nil {
+			t.Errorf("%T: %v", tc.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%T = %v, want %v", tc.value, got, tc.want)
+		}
+	}
+
+	if _, err := NewSseStructMessage(42); err == nil {
+		t.Error("NewSseStructMessage accepted an int")
+	}
+}
+
+func TestBroadcastStructReachesClient(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	sse := s.RegisterSSE("/events", newTestSseHandler)
+	events, _ := connectSse(t, base+"/events")
+
+	if err := BroadcastStruct(sse, UserJoined{Name: "ada"}); err != nil {
+		t.Fatal(err)
+	}
+	msg := readSseMessage(t, events)
+	if msg.Event() != "user_joined" {
+		t.Fatalf("event = %q, want user_joined", msg.Event())
+	}
+	if got, err := SseMessageInto[UserJoined](msg); err != nil || got.Name != "ada" {
+		t.Errorf("SseMessageInto = %+v, %v", got, err)
+	}
+}
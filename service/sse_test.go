@@ -0,0 +1,1587 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testSseHandler accepts every connection and message and hands callbacks
+// to onCallback when set.
+type testSseHandler struct {
+	onCallback func(w http.ResponseWriter, r *http.Request)
+}
+
+func newTestSseHandler() SseEventHandler {
+	return &testSseHandler{}
+}
+
+func (h *testSseHandler) OnInitialize(w http.ResponseWriter, r *http.Request, server *SseServer, session *SseSession) error {
+	return nil
+}
+
+func (h *testSseHandler) OnConnect(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func (h *testSseHandler) OnDisconnect(w http.ResponseWriter, r *http.Request) {}
+
+func (h *testSseHandler) OnMessage(w http.ResponseWriter, r *http.Request, msg SseMessage) bool {
+	return true
+}
+
+func (h *testSseHandler) OnCallback(w http.ResponseWriter, r *http.Request) {
+	if h.onCallback != nil {
+		h.onCallback(w, r)
+	}
+}
+
+// connectSse opens an event stream at url that is closed when the test
+// ends, and returns it after reading on_connect.
+func connectSse(t *testing.T, url string) (*bufio.Reader, SseMessage) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		t.Fatalf("connect status = %d", resp.StatusCode)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	events := bufio.NewReader(resp.Body)
+	connect := readSseMessage(t, events)
+	if connect.Event() != "on_connect" {
+		t.Fatalf("first event = %q, want on_connect", connect.Event())
+	}
+	return events, connect
+}
+
+// readSseData reads the next event from an event stream and returns its
+// data lines joined.
+func readSseData(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var data []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event stream: %v", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if data == nil {
+				continue
+			}
+			return strings.Join(data, "\n")
+		}
+		if value, ok := strings.CutPrefix(line, "data: "); ok {
+			data = append(data, value)
+		}
+	}
+}
+
+// readSseMessage reads the next event and decodes its JSON data.
+func readSseMessage(t *testing.T, r *bufio.Reader) SseMessage {
+	t.Helper()
+	data := readSseData(t, r)
+	msg := SseMessage{}
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		t.Fatalf("event data %q: %v", data, err)
+	}
+	return msg
+}
+
+// postCallback posts body as JSON to url on behalf of clientID.
+func postCallback(t *testing.T, url string, clientID any, header http.Header, body SseMessage) *http.Response {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Client-ID", fmt.Sprint(clientID))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestCallbackRequiresCSRFToken(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	called := make(chan struct{}, 1)
+	s.RegisterSSE("/events", func() SseEventHandler {
+		return &testSseHandler{onCallback: func(w http.ResponseWriter, r *http.Request) {
+			called <- struct{}{}
+		}}
+	}).SetCSRFProtection(true)
+
+	_, connect := connectSse(t, base+"/events")
+	clientID := connect["client_id"]
+	token, _ := connect["csrf_token"].(string)
+	if token == "" {
+		t.Fatal("on_connect carries no csrf_token")
+	}
+
+	resp := postCallback(t, base+"/events/callback", clientID, nil, SseMessage{"event": "hello"})
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("callback without token: status = %d, want 403", resp.StatusCode)
+	}
+	resp = postCallback(t, base+"/events/callback", clientID, http.Header{"X-Csrf-Token": {"wrong"}}, SseMessage{"event": "hello"})
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("callback with a wrong token: status = %d, want 403", resp.StatusCode)
+	}
+	select {
+	case <-called:
+		t.Fatal("OnCallback ran without a valid token")
+	default:
+	}
+
+	resp = postCallback(t, base+"/events/callback", clientID, nil, SseMessage{"event": "hello", "csrf_token": token})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback with token: status = %d, want 200", resp.StatusCode)
+	}
+	select {
+	case <-called:
+	default:
+		t.Fatal("OnCallback did not run")
+	}
+}
+
+// callbackRecorder returns a factory whose handlers report each callback's
+// event on the returned channel.
+func callbackRecorder() (SseEventHandlerFactory, chan string) {
+	events := make(chan string, 16)
+	factory := func() SseEventHandler {
+		return &testSseHandler{onCallback: func(w http.ResponseWriter, r *http.Request) {
+			event, _ := HttpParameterT[string](r, "event")
+			events <- event
+		}}
+	}
+	return factory, events
+}
+
+func TestCallbackDispatchPaths(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	factory, called := callbackRecorder()
+	s.RegisterSSEWithConfig("/events", factory, SseConfig{
+		CallbackPath:    "/cb",
+		CallbackMethods: []string{http.MethodPut},
+	})
+	_, connect := connectSse(t, base+"/events")
+	clientID := connect["client_id"]
+
+	req, _ := http.NewRequest(http.MethodPut, base+"/events/cb", strings.NewReader(`{"event":"explicit"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Client-ID", fmt.Sprint(clientID))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := <-called; got != "explicit" {
+		t.Fatalf("explicit route delivered %q", got)
+	}
+
+	resp = postCallback(t, base+"/events/callback", clientID, nil, SseMessage{"event": "default"})
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("default callback path: status = %d, want 404", resp.StatusCode)
+	}
+
+	postCallback(t, base+"/events", clientID, nil, SseMessage{"event": "inline"})
+	if got := <-called; got != "inline" {
+		t.Fatalf("inline callback delivered %q", got)
+	}
+}
+
+func TestDisableInlineCallback(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	factory, called := callbackRecorder()
+	s.RegisterSSEWithConfig("/events", factory, SseConfig{DisableInlineCallback: true})
+	_, connect := connectSse(t, base+"/events")
+	clientID := connect["client_id"]
+	if connect["callback_path"] != "/callback" {
+		t.Fatalf("on_connect callback_path = %v, want /callback", connect["callback_path"])
+	}
+
+	// the main route now only opens streams, so the inline post is answered
+	// with one instead of reaching OnCallback
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, base+"/events", strings.NewReader(`{"event":"inline"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Client-ID", fmt.Sprint(clientID))
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("inline post answered with %q, want an event stream", ct)
+		}
+		resp.Body.Close()
+	}
+
+	postCallback(t, base+"/events/callback", clientID, nil, SseMessage{"event": "explicit"})
+	if got := <-called; got != "explicit" {
+		t.Fatalf("callback route delivered %q, want explicit", got)
+	}
+}
+
+// earlyBroadcaster broadcasts as soon as its session is initialized, before
+// on_connect has been sent.
+type earlyBroadcaster struct {
+	testSseHandler
+}
+
+func (h *earlyBroadcaster) OnInitialize(w http.ResponseWriter, r *http.Request, server *SseServer, session *SseSession) error {
+	server.Broadcast(SseMessage{"event": "early"})
+	return nil
+}
+
+func TestOnConnectArrivesBeforeBroadcast(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	s.RegisterSSE("/events", func() SseEventHandler { return &earlyBroadcaster{} })
+
+	for i := 0; i < 20; i++ {
+		// connectSse fails unless on_connect is the first event
+		events, _ := connectSse(t, base+"/events")
+		if msg := readSseMessage(t, events); msg.Event() != "early" {
+			t.Fatalf("second event = %q, want early", msg.Event())
+		}
+	}
+}
+
+func TestEnvelopeEncoding(t *testing.T) {
+	tests := []struct {
+		env  sseEnvelope
+		want string
+	}{
+		{sseEnvelope{msg: SseMessage{"event": "x"}}, "data: {\"event\":\"x\"}\r\n\r\n"},
+		{sseEnvelope{msg: SseMessage{"event": "x"}, id: "7", retry: 1500 * time.Millisecond}, "id: 7\r\nretry: 1500\r\ndata: {\"event\":\"x\"}\r\n\r\n"},
+		{sseEnvelope{raw: "one\ntwo\r\nthree\rfour", isRaw: true}, "data: one\r\ndata: two\r\ndata: three\r\ndata: four\r\n\r\n"},
+		{sseEnvelope{raw: "", isRaw: true}, "data: \r\n\r\n"},
+	}
+	for _, tt := range tests {
+		got, err := tt.env.encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("encode() = %q, want %q", got, tt.want)
+		}
+	}
+	if id := sseEventID("a\r\nb"); id != "ab" {
+		t.Errorf("sseEventID = %q, want ab", id)
+	}
+}
+
+func TestBroadcastRawMultiLine(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	sse := s.RegisterSSE("/events", newTestSseHandler)
+	events, _ := connectSse(t, base+"/events")
+
+	sse.BroadcastRaw("first line\nsecond line\r\nthird line")
+	if got := readSseData(t, events); got != "first line\nsecond line\nthird line" {
+		t.Fatalf("data = %q", got)
+	}
+	// the stream is still framed correctly after the multi-line event
+	sse.Broadcast(SseMessage{"event": "after"})
+	if msg := readSseMessage(t, events); msg.Event() != "after" {
+		t.Fatalf("event = %q, want after", msg.Event())
+	}
+}
+
+// messageRecorder reports every message its OnMessage sees.
+// messageRecorder hands every message OnMessage sees to seen and skips
+// those whose event is drop.
+type messageRecorder struct {
+	testSseHandler
+	seen chan SseMessage
+	drop string
+}
+
+func (h *messageRecorder) OnMessage(w http.ResponseWriter, r *http.Request, msg SseMessage) bool {
+	h.seen <- msg
+	return h.drop == "" || msg.Event() != h.drop
+}
+
+func TestOnMessageSeesPayloadOnly(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	seen := make(chan SseMessage, 16)
+	sse := s.RegisterSSE("/events", func() SseEventHandler { return &messageRecorder{seen: seen} })
+	_, connect := connectSse(t, base+"/events")
+	<-seen // on_connect
+	session, _ := sse.Find(ClientID(connect["client_id"].(string)))
+	session.Subscribe("news")
+
+	sse.BroadcastWithID("42", SseMessage{"event": "with_id"})
+	sse.BroadcastTopic("news", SseMessage{"event": "topic"})
+	if _, err := sse.BroadcastSync(SseMessage{"event": "sync"}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	session.DirectMessageRaw("text")
+
+	want := []SseMessage{
+		{"event": "with_id"},
+		{"event": "topic", "topic": "news"},
+		{"event": "sync"},
+		nil,
+	}
+	for _, w := range want {
+		got := <-seen
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("OnMessage saw %v, want %v", got, w)
+		}
+	}
+}
+
+func TestSseClientReceivesEvents(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	sse := s.RegisterSSE("/events", newTestSseHandler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	client := NewSseClient(base+"/events", SseClientOptions{CallbackPath: "/callback"})
+	events := client.Connect(ctx)
+	next := func() SseEvent {
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event received")
+			return SseEvent{}
+		}
+	}
+
+	if ev := next(); ev.Event != "on_connect" || ev.Message["client_id"] != string(client.ClientID()) {
+		t.Fatalf("first event = %+v, want on_connect for %s", ev, client.ClientID())
+	}
+
+	sse.BroadcastWithID("42", SseMessage{"event": "numbered", "n": 1})
+	ev := next()
+	if ev.ID != "42" || ev.Event != "numbered" || ev.Message["n"] != float64(1) {
+		t.Fatalf("event = %+v", ev)
+	}
+	if client.LastEventID() != "42" {
+		t.Fatalf("LastEventID = %q, want 42", client.LastEventID())
+	}
+	decoded, err := SseMessageInto[struct{ N int }](ev.Message)
+	if err != nil || decoded.N != 1 {
+		t.Fatalf("SseMessageInto = %+v, %v", decoded, err)
+	}
+
+	sse.BroadcastRaw("plain\ntext")
+	if ev := next(); ev.Message != nil || ev.Data != "plain\ntext" || ev.Event != "" {
+		t.Fatalf("raw event = %+v", ev)
+	}
+}
+
+func TestShutdownSendsGoingAway(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	s.RegisterSSE("/events", newTestSseHandler).SetGoingAwayRetry(45 * time.Second)
+
+	events, _ := connectSse(t, base+"/events")
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- s.Shutdown(ctx)
+	}()
+
+	var retry string
+	var data []string
+	for data == nil || !strings.Contains(strings.Join(data, ""), "going_away") {
+		line, err := events.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended before going_away: %v", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if value, ok := strings.CutPrefix(line, "retry: "); ok {
+			retry = value
+		} else if value, ok := strings.CutPrefix(line, "data: "); ok {
+			data = append(data, value)
+		} else if line == "" {
+			data = nil
+		}
+	}
+	if retry != "45000" {
+		t.Errorf("retry = %q, want 45000", retry)
+	}
+	msg := SseMessage{}
+	if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["retry_ms"] != float64(45000) {
+		t.Errorf("retry_ms = %v, want 45000", msg["retry_ms"])
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if _, err := http.Get(base + "/events"); err == nil {
+		t.Error("service still accepting connections after Shutdown")
+	}
+}
+
+// flushCounter is a ResponseWriter that counts writes and flushes.
+type flushCounter struct {
+	header  http.Header
+	writes  atomic.Int64
+	flushes atomic.Int64
+}
+
+func (w *flushCounter) Header() http.Header { return w.header }
+
+func (w *flushCounter) WriteHeader(statusCode int) {}
+
+func (w *flushCounter) Write(p []byte) (int, error) {
+	w.writes.Add(1)
+	return len(p), nil
+}
+
+func (w *flushCounter) Flush() { w.flushes.Add(1) }
+
+// benchmarkSseFlushes sends b.N direct messages in bursts of 32 to a
+// stream served with the given flush interval and reports flushes per
+// message.
+func benchmarkSseFlushes(b *testing.B, interval time.Duration) {
+	s := NewServiceBuilder().Build()
+	srv := s.RegisterSSE("/events", newTestSseHandler).SetFlushInterval(interval)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &flushCounter{header: http.Header{}}
+	r := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx)
+	r.Header.Set("Accept", "text/event-stream")
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		s.ServeHTTP(w, r)
+	}()
+	defer func() {
+		cancel()
+		<-served
+	}()
+
+	var session *SseSession
+	for session == nil {
+		srv.Range(func(found *SseSession) bool {
+			session = found
+			return false
+		})
+		time.Sleep(time.Millisecond)
+	}
+	for w.writes.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	writes, flushes := w.writes.Load(), w.flushes.Load()
+
+	msg := SseMessage{"event": "tick", "payload": 1}
+	b.ResetTimer()
+	for sent := 0; sent < b.N; {
+		burst := min(32, b.N-sent)
+		for range burst {
+			for session.DirectMessage(msg) != nil {
+				time.Sleep(time.Microsecond)
+			}
+		}
+		sent += burst
+		for w.writes.Load() < writes+int64(sent) {
+			time.Sleep(10 * time.Microsecond)
+		}
+	}
+	b.StopTimer()
+	time.Sleep(2 * interval)
+	b.ReportMetric(float64(w.flushes.Load()-flushes)/float64(b.N), "flushes/msg")
+}
+
+func BenchmarkSseFlushPerMessage(b *testing.B) {
+	benchmarkSseFlushes(b, 0)
+}
+
+func BenchmarkSseFlushInterval5ms(b *testing.B) {
+	benchmarkSseFlushes(b, 5*time.Millisecond)
+}
+
+// panicOnConnect panics after the stream has started.
+type panicOnConnect struct {
+	testSseHandler
+}
+
+func (h *panicOnConnect) OnConnect(w http.ResponseWriter, r *http.Request) error {
+	panic("connect failed")
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPanicDuringConnectRemovesSession(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	srv := s.RegisterSSE("/events", func() SseEventHandler { return &panicOnConnect{} })
+
+	req, _ := http.NewRequest(http.MethodGet, base+"/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	waitFor(t, "session removal", func() bool { return srv.ConnectionCount() == 0 })
+	if got := srv.ConnectionHighWater(); got != 1 {
+		t.Errorf("ConnectionHighWater = %d, want 1", got)
+	}
+}
+
+func TestMaxConnectionsRejectsWith503(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	srv := s.RegisterSSE("/events", newTestSseHandler).SetMaxConnections(2)
+
+	connectSse(t, base+"/events")
+	connectSse(t, base+"/events")
+
+	req, _ := http.NewRequest(http.MethodGet, base+"/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("third connection status = %d, want 503", resp.StatusCode)
+	}
+	if got := srv.ConnectionCount(); got != 2 {
+		t.Errorf("ConnectionCount = %d, want 2", got)
+	}
+	if got := srv.ConnectionHighWater(); got != 2 {
+		t.Errorf("ConnectionHighWater = %d, want 2", got)
+	}
+}
+
+func TestReapRemovesSessionsWithDoneContext(t *testing.T) {
+	srv := NewServiceBuilder().Build().RegisterSSE("/events", newTestSseHandler)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for id, sessionCtx := range map[ClientID]context.Context{"stale": ctx, "live": context.Background()} {
+		srv.add(&SseSession{
+			ctx:                sessionCtx,
+			client_id:          id,
+			done:               make(chan struct{}),
+			direct_messages:    make(chan sseEnvelope),
+			broadcast_messages: srv.fanout.CreateConsumer(sessionCtx),
+		}, "")
+	}
+
+	if n := srv.reap(); n != 1 {
+		t.Errorf("reap = %d, want 1", n)
+	}
+	if _, ok := srv.Find("stale"); ok {
+		t.Error("stale session still registered")
+	}
+	if _, ok := srv.Find("live"); !ok {
+		t.Error("live session was reaped")
+	}
+}
+
+func TestDirectMessageAck(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	factory, callbacks := callbackRecorder()
+	srv := s.RegisterSSE("/events", factory)
+
+	events, connect := connectSse(t, base+"/events")
+	clientID := connect["client_id"]
+	session, ok := srv.Find(ClientID(clientID.(string)))
+	if !ok {
+		t.Fatal("session not found")
+	}
+
+	acked := make(chan error, 1)
+	go func() {
+		acked <- session.DirectMessageAck(SseMessage{"event": "order", "id": "m1"}, 5*time.Second)
+	}()
+	msg := readSseMessage(t, events)
+	if msg["id"] != "m1" || msg["ack_requested"] != true {
+		t.Fatalf("message = %v, want id m1 with ack_requested", msg)
+	}
+	if resp := postCallback(t, base+"/events", clientID, nil, SseMessage{"event": "ack", "id": "m1"}); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("ack status = %d, want 204", resp.StatusCode)
+	}
+	if err := <-acked; err != nil {
+		t.Fatalf("DirectMessageAck = %v", err)
+	}
+
+	// an ack nothing waits for is the application's own message
+	if resp := postCallback(t, base+"/events", clientID, nil, SseMessage{"event": "ack", "id": "m1"}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unmatched ack status = %d, want 200", resp.StatusCode)
+	}
+	if got := <-callbacks; got != "ack" {
+		t.Fatalf("OnCallback saw %q, want ack", got)
+	}
+}
+
+func TestDirectMessageAckTimesOut(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	srv := s.RegisterSSE("/events", newTestSseHandler)
+
+	_, connect := connectSse(t, base+"/events")
+	session, _ := srv.Find(ClientID(connect["client_id"].(string)))
+	if err := session.DirectMessageAck(SseMessage{"event": "order"}, 20*time.Millisecond); !errors.Is(err, ErrSseAckTimeout) {
+		t.Fatalf("DirectMessageAck = %v, want ErrSseAckTimeout", err)
+	}
+}
+
+func TestDirectMessageAckSessionClosed(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	srv := s.RegisterSSE("/events", newTestSseHandler)
+
+	events, connect := connectSse(t, base+"/events")
+	session, _ := srv.Find(ClientID(connect["client_id"].(string)))
+	acked := make(chan error, 1)
+	go func() {
+		acked <- session.DirectMessageAck(SseMessage{"event": "order"}, 5*time.Second)
+	}()
+	readSseMessage(t, events)
+	session.Close()
+	if err := <-acked; !errors.Is(err, ErrSseSessionClosed) {
+		t.Fatalf("DirectMessageAck = %v, want ErrSseSessionClosed", err)
+	}
+}
+
+func TestCompressedStreamDecompressesPerMessage(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	srv := s.RegisterSSE("/events", newTestSseHandler).SetCompression(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, base+"/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	// set explicitly, so the transport leaves the body compressed
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+
+	// each message must arrive decompressible on its own, while the
+	// stream is still open
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := bufio.NewReader(gz)
+	if msg := readSseMessage(t, events); msg.Event() != "on_connect" {
+		t.Fatalf("first event = %q, want on_connect", msg.Event())
+	}
+	srv.Broadcast(SseMessage{"event": "telemetry", "payload": strings.Repeat("abc", 100)})
+	if msg := readSseMessage(t, events); msg.Event() != "telemetry" || msg["payload"] != strings.Repeat("abc", 100) {
+		t.Fatalf("second event = %v", msg)
+	}
+}
+
+func TestCompressionNeedsAcceptEncoding(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	s.RegisterSSE("/events", newTestSseHandler).SetCompression(true)
+
+	req, _ := http.NewRequest(http.MethodGet, base+"/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Accept-Encoding", "identity")
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("Content-Encoding"); got != "" {
+		t.Fatalf("Content-Encoding = %q without gzip in Accept-Encoding", got)
+	}
+}
+
+func TestIdleSessionIsDisconnected(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	srv := s.RegisterSSE("/events", newTestSseHandler).SetIdleTimeout(100 * time.Millisecond)
+
+	events, _ := connectSse(t, base+"/events")
+	ended := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, events)
+		ended <- err
+	}()
+	select {
+	case <-ended:
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle session was not disconnected")
+	}
+	waitFor(t, "session removal", func() bool { return srv.ConnectionCount() == 0 })
+}
+
+func TestCallbacksKeepSessionActive(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	srv := s.RegisterSSE("/events", newTestSseHandler).SetIdleTimeout(150 * time.Millisecond)
+
+	_, connect := connectSse(t, base+"/events")
+	for range 10 {
+		time.Sleep(40 * time.Millisecond)
+		postCallback(t, base+"/events", connect["client_id"], nil, SseMessage{"event": "still-here"})
+	}
+	if srv.ConnectionCount() != 1 {
+		t.Fatal("session with regular callbacks was disconnected as idle")
+	}
+}
+
+func TestCustomClientID(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	factory, callbacks := callbackRecorder()
+	srv := s.RegisterSSEWithConfig("/events", factory, SseConfig{
+		ClientID: func(r *http.Request) ClientID {
+			return ClientID(r.URL.Query().Get("user"))
+		},
+	})
+
+	_, first := connectSse(t, base+"/events?user=ada")
+	if first["client_id"] != "ada" {
+		t.Fatalf("client_id = %v, want ada", first["client_id"])
+	}
+	if _, ok := srv.Find("ada"); !ok {
+		t.Fatal("session is not registered under its custom id")
+	}
+	if resp := postCallback(t, base+"/events", "ada", nil, SseMessage{"event": "hello"}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback status = %d", resp.StatusCode)
+	}
+	if got := <-callbacks; got != "hello" {
+		t.Fatalf("callback event = %q", got)
+	}
+
+	// a second tab of the same user is suffixed instead of refused
+	_, second := connectSse(t, base+"/events?user=ada")
+	secondID, _ := second["client_id"].(string)
+	if !strings.HasPrefix(secondID, "ada-") || len(secondID) <= len("ada-") {
+		t.Fatalf("duplicate client_id = %q, want ada-<consumer id>", secondID)
+	}
+	if srv.ConnectionCount() != 2 {
+		t.Fatalf("ConnectionCount = %d, want 2", srv.ConnectionCount())
+	}
+
+	// without a custom id the consumer id is used
+	_, anonymous := connectSse(t, base+"/events")
+	if id, _ := anonymous["client_id"].(string); id == "" || strings.HasPrefix(id, "ada") {
+		t.Fatalf("fallback client_id = %q", id)
+	}
+}
+
+// rejectingHandler refuses connections without an Authorization header.
+type rejectingHandler struct {
+	testSseHandler
+}
+
+func (h *rejectingHandler) OnInitialize(w http.ResponseWriter, r *http.Request, server *SseServer, session *SseSession) error {
+	switch r.Header.Get("Authorization") {
+	case "":
+		return RejectSse(http.StatusUnauthorized, errors.New("login required"))
+	case "Bearer guest":
+		return RejectSse(http.StatusForbidden, errors.New("not allowed"))
+	case "Bearer broken":
+		return errors.New("bad request")
+	}
+	return nil
+}
+
+func TestOnInitializeRejectsBeforeStreaming(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	srv := s.RegisterSSE("/events", func() SseEventHandler { return &rejectingHandler{} })
+
+	tests := []struct {
+		authorization string
+		status        int
+		message       string
+	}{
+		{"", http.StatusUnauthorized, "login required"},
+		{"Bearer guest", http.StatusForbidden, "not allowed"},
+		{"Bearer broken", http.StatusBadRequest, "bad request"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, base+"/events", nil)
+		req.Header.Set("Accept", "text/event-stream")
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%q: status = %d, want %d", tt.authorization, resp.StatusCode, tt.status)
+		}
+		if ct := resp.Header.Get("Content-Type"); strings.HasPrefix(ct, "text/event-stream") {
+			t.Errorf("%q: rejection was sent as an event stream", tt.authorization)
+		}
+		if !strings.Contains(string(body), tt.message) {
+			t.Errorf("%q: body = %q, want %q", tt.authorization, body, tt.message)
+		}
+	}
+	waitFor(t, "rejected sessions to be removed", func() bool { return srv.ConnectionCount() == 0 })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, base+"/events", nil)
+	req.Header.Set("Authorization", "Bearer member")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("accepted connection: status %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestRetryHintInPreamble(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	s.RegisterSSE("/events", newTestSseHandler).SetRetryHint(2500 * time.Millisecond)
+
+	req, _ := http.NewRequest(http.MethodGet, base+"/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var preamble []string
+	events := bufio.NewReader(resp.Body)
+	for {
+		line, err := events.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended in the preamble: %v", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		preamble = append(preamble, line)
+	}
+	if !slices.Contains(preamble, "retry: 2500") {
+		t.Fatalf("preamble %q has no retry: 2500", preamble)
+	}
+	if !strings.Contains(strings.Join(preamble, "\n"), `"retry_ms":2500`) {
+		t.Errorf("on_connect %q has no retry_ms", preamble)
+	}
+}
+
+// changeLogHandler replays the entries of its log after the client's last
+// event id on connect, with each entry's sequence number as its id.
+type changeLogHandler struct {
+	testSseHandler
+	log     []string
+	session *SseSession
+}
+
+func (h *changeLogHandler) OnInitialize(w http.ResponseWriter, r *http.Request, server *SseServer, session *SseSession) error {
+	h.session = session
+	return nil
+}
+
+func (h *changeLogHandler) OnConnect(w http.ResponseWriter, r *http.Request) error {
+	last, _ := strconv.Atoi(h.session.LastEventID())
+	for i, entry := range h.log {
+		if seq := (i + 1) * 10; seq > last {
+			h.session.DirectMessageWithID(strconv.Itoa(seq), SseMessage{"event": "change", "entry": entry})
+		}
+	}
+	return nil
+}
+
+// readSseIDs reads n events and returns their id: fields and entries.
+func readSseIDs(t *testing.T, r *bufio.Reader, n int) []string {
+	t.Helper()
+	var got []string
+	var id string
+	for len(got) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event stream: %v", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if value, ok := strings.CutPrefix(line, "id: "); ok {
+			id = value
+		} else if value, ok := strings.CutPrefix(line, "data: "); ok {
+			msg := SseMessage{}
+			json.Unmarshal([]byte(value), &msg)
+			got = append(got, id+"="+fmt.Sprint(msg["entry"]))
+			id = ""
+		}
+	}
+	return got
+}
+
+func TestReconnectResumesFromApplicationID(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	s.RegisterSSE("/events", func() SseEventHandler {
+		return &changeLogHandler{log: []string{"a", "b", "c"}}
+	})
+
+	events, _ := connectSse(t, base+"/events")
+	if got, want := readSseIDs(t, events, 3), []string{"10=a", "20=b", "30=c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("first connection = %v, want %v", got, want)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, base+"/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Last-Event-ID", "20")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	resumed := bufio.NewReader(resp.Body)
+	if msg := readSseMessage(t, resumed); msg.Event() != "on_connect" {
+		t.Fatalf("first event = %q, want on_connect", msg.Event())
+	}
+	if got, want := readSseIDs(t, resumed, 1), []string{"30=c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("resumed with Last-Event-ID = %v, want %v", got, want)
+	}
+
+	events, _ = connectSse(t, base+"/events?last_event_id=10")
+	if got, want := readSseIDs(t, events, 2), []string{"20=b", "30=c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("resumed with last_event_id = %v, want %v", got, want)
+	}
+}
+
+func TestLinkedServerReceivesBroadcast(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	a := s.RegisterSSE("/a", newTestSseHandler)
+	b := s.RegisterSSE("/b", newTestSseHandler)
+	a.Link(b).Link(b).Link(a)
+
+	clientA, _ := connectSse(t, base+"/a")
+	clientB, _ := connectSse(t, base+"/b")
+
+	a.Broadcast(SseMessage{"event": "alert", "n": 1})
+	for name, client := range map[string]*bufio.Reader{"a": clientA, "b": clientB} {
+		if msg := readSseMessage(t, client); msg.Event() != "alert" {
+			t.Fatalf("client %s got %q, want alert", name, msg.Event())
+		}
+	}
+
+	// b is not linked to a, so its broadcast stays on b
+	b.Broadcast(SseMessage{"event": "local"})
+	a.Broadcast(SseMessage{"event": "alert", "n": 2})
+	if msg := readSseMessage(t, clientA); msg.Event() != "alert" || msg["n"] != float64(2) {
+		t.Fatalf("client a got %v, want the second alert", msg)
+	}
+	for _, want := range []string{"local", "alert"} {
+		if msg := readSseMessage(t, clientB); msg.Event() != want {
+			t.Fatalf("client b got %q, want %s", msg.Event(), want)
+		}
+	}
+}
+
+func TestMutualLinksDoNotLoop(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	a := s.RegisterSSE("/a", newTestSseHandler)
+	b := s.RegisterSSE("/b", newTestSseHandler)
+	a.Link(b)
+	b.Link(a)
+
+	clientA, _ := connectSse(t, base+"/a")
+	clientB, _ := connectSse(t, base+"/b")
+
+	a.Broadcast(SseMessage{"event": "first"})
+	b.Broadcast(SseMessage{"event": "second"})
+	for name, client := range map[string]*bufio.Reader{"a": clientA, "b": clientB} {
+		var got []string
+		for range 2 {
+			msg := readSseMessage(t, client)
+			got = append(got, msg.Event())
+		}
+		slices.Sort(got)
+		if !reflect.DeepEqual(got, []string{"first", "second"}) {
+			t.Fatalf("client %s got %v, want first and second once each", name, got)
+		}
+	}
+
+	// a repeat of either message would arrive ahead of this marker
+	a.Broadcast(SseMessage{"event": "marker"})
+	if msg := readSseMessage(t, clientB); msg.Event() != "marker" {
+		t.Fatalf("client b got %q after the pair, want marker", msg.Event())
+	}
+}
+
+func TestDirectMessageManyReportsFailures(t *testing.T) {
+	srv := NewServiceBuilder().Build().RegisterSSE("/events", newTestSseHandler)
+	for id, buffer := range map[ClientID]int{"ready": 4, "full": 1, "closed": 4} {
+		session := &SseSession{
+			ctx:                context.Background(),
+			client_id:          id,
+			done:               make(chan struct{}),
+			direct_messages:    make(chan sseEnvelope, buffer),
+			broadcast_messages: srv.fanout.CreateConsumer(context.Background()),
+		}
+		srv.add(session, "")
+		switch id {
+		case "full":
+			session.DirectMessage(SseMessage{"event": "filler"})
+		case "closed":
+			session.closed = true
+		}
+	}
+
+	delivered, failures := srv.DirectMessageMany([]ClientID{"ready", "full", "missing", "closed"}, SseMessage{"event": "note"})
+	if delivered != 1 {
+		t.Errorf("delivered = %d, want 1", delivered)
+	}
+	want := map[ClientID]error{
+		"full":    ErrSseBufferFull,
+		"missing": ErrSseSessionNotFound,
+		"closed":  ErrSseSessionClosed,
+	}
+	if len(failures) != len(want) {
+		t.Fatalf("failures = %v, want %v", failures, want)
+	}
+	for id, err := range want {
+		if !errors.Is(failures[id], err) {
+			t.Errorf("failures[%s] = %v, want %v", id, failures[id], err)
+		}
+	}
+
+	if delivered, failures := srv.DirectMessageMany([]ClientID{"ready"}, SseMessage{"event": "note"}); delivered != 1 || failures != nil {
+		t.Errorf("all delivered = %d, %v, want 1 and nil failures", delivered, failures)
+	}
+}
+
+func TestBroadcastSyncWaitsForWrites(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	sse := s.RegisterSSE("/events", newTestSseHandler)
+
+	if n, err := sse.BroadcastSync(SseMessage{"event": "nobody"}, time.Second); n != 0 || err != nil {
+		t.Fatalf("BroadcastSync without clients = %d, %v, want 0, nil", n, err)
+	}
+
+	first, _ := connectSse(t, base+"/events")
+	second, _ := connectSse(t, base+"/events")
+	start := time.Now()
+	n, err := sse.BroadcastSync(SseMessage{"event": "synced"}, 5*time.Second)
+	if n != 2 || err != nil {
+		t.Fatalf("BroadcastSync = %d, %v, want 2, nil", n, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("BroadcastSync took %v", elapsed)
+	}
+	for _, events := range []*bufio.Reader{first, second} {
+		if msg := readSseMessage(t, events); msg.Event() != "synced" {
+			t.Errorf("event = %q, want synced", msg.Event())
+		}
+	}
+}
+
+func TestBroadcastSyncTimesOutOnUnwrittenSession(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	seen := make(chan SseMessage, 16)
+	sse := s.RegisterSSE("/events", func() SseEventHandler { return &messageRecorder{seen: seen, drop: "secret"} })
+	connectSse(t, base+"/events")
+	<-seen // on_connect
+
+	n, err := sse.BroadcastSync(SseMessage{"event": "secret"}, 100*time.Millisecond)
+	if n != 0 || !errors.Is(err, ErrSseBroadcastTimeout) {
+		t.Fatalf("BroadcastSync = %d, %v, want 0, ErrSseBroadcastTimeout", n, err)
+	}
+}
+
+func TestTopicSubscriptionsNeedAuthorizeTopic(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	factory, called := callbackRecorder()
+	closed := s.RegisterSSE("/closed", factory)
+	open := s.RegisterSSEWithConfig("/open", factory, SseConfig{
+		AuthorizeTopic: func(r *http.Request, session *SseSession, topic string) bool {
+			return topic == "public"
+		},
+	})
+
+	closedEvents, closedConnect := connectSse(t, base+"/closed")
+	if resp := postCallback(t, base+"/closed/callback", closedConnect["client_id"], nil, SseMessage{"event": "__subscribe", "topic": "public"}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("subscribe without AuthorizeTopic = %d, want 403", resp.StatusCode)
+	}
+
+	openEvents, openConnect := connectSse(t, base+"/open")
+	for topic, want := range map[string]int{"public": http.StatusNoContent, "private": http.StatusForbidden} {
+		if resp := postCallback(t, base+"/open/callback", openConnect["client_id"], nil, SseMessage{"event": "__subscribe", "topic": topic}); resp.StatusCode != want {
+			t.Errorf("subscribe to %s = %d, want %d", topic, resp.StatusCode, want)
+		}
+	}
+
+	open.BroadcastTopic("private", SseMessage{"event": "secret"})
+	open.BroadcastTopic("public", SseMessage{"event": "news"})
+	closed.BroadcastTopic("public", SseMessage{"event": "news"})
+	closed.Broadcast(SseMessage{"event": "marker"})
+	if msg := readSseMessage(t, openEvents); msg.Event() != "news" || msg["topic"] != "public" {
+		t.Errorf("open client got %v, want the public news", msg)
+	}
+	if msg := readSseMessage(t, closedEvents); msg.Event() != "marker" {
+		t.Errorf("closed client got %q, want only the marker", msg.Event())
+	}
+
+	if resp := postCallback(t, base+"/open/callback", openConnect["client_id"], nil, SseMessage{"event": "__unsubscribe", "topic": "public"}); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("unsubscribe = %d, want 204", resp.StatusCode)
+	}
+	open.BroadcastTopic("public", SseMessage{"event": "news"})
+	open.Broadcast(SseMessage{"event": "marker"})
+	if msg := readSseMessage(t, openEvents); msg.Event() != "marker" {
+		t.Errorf("unsubscribed client got %q, want only the marker", msg.Event())
+	}
+
+	select {
+	case event := <-called:
+		t.Errorf("control event %q reached OnCallback", event)
+	default:
+	}
+}
+
+func TestPlainSubscribeEventReachesOnCallback(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	factory, called := callbackRecorder()
+	s.RegisterSSEWithConfig("/events", factory, SseConfig{
+		AuthorizeTopic: func(r *http.Request, session *SseSession, topic string) bool { return true },
+	})
+
+	_, connect := connectSse(t, base+"/events")
+	resp := postCallback(t, base+"/events/callback", connect["client_id"], nil, SseMessage{"event": "subscribe", "topic": "newsletter"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200 from OnCallback", resp.StatusCode)
+	}
+	if event := <-called; event != "subscribe" {
+		t.Errorf("OnCallback got %q, want subscribe", event)
+	}
+}
+
+func TestSseClientSubscribesToTopics(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	sse := s.RegisterSSEWithConfig("/events", newTestSseHandler, SseConfig{
+		AuthorizeTopic: func(r *http.Request, session *SseSession, topic string) bool { return true },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	client := NewSseClient(base+"/events", SseClientOptions{CallbackPath: "/callback"})
+	events := client.Connect(ctx)
+	if ev := <-events; ev.Event != "on_connect" {
+		t.Fatalf("first event = %q, want on_connect", ev.Event)
+	}
+	if err := client.Subscribe(ctx, "prices"); err != nil {
+		t.Fatal(err)
+	}
+
+	sse.BroadcastTopic("prices", SseMessage{"event": "tick"})
+	select {
+	case ev := <-events:
+		if ev.Event != "tick" || ev.Message["topic"] != "prices" {
+			t.Fatalf("event = %+v, want the prices tick", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("topic message not received")
+	}
+
+	if err := client.Unsubscribe(ctx, "prices"); err != nil {
+		t.Fatal(err)
+	}
+	sse.BroadcastTopic("prices", SseMessage{"event": "tick"})
+	sse.Broadcast(SseMessage{"event": "marker"})
+	if ev := <-events; ev.Event != "marker" {
+		t.Fatalf("event after unsubscribe = %q, want marker", ev.Event)
+	}
+}
+
+func TestEventsQueryFiltersBroadcasts(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	sse := s.RegisterSSEWithConfig("/events", newTestSseHandler, SseConfig{EventsQuery: "events"})
+
+	chat, _ := connectSse(t, base+"/events?events=chat_message,typing")
+	everything, _ := connectSse(t, base+"/events")
+
+	sse.Broadcast(SseMessage{"event": "user_join"})
+	sse.Broadcast(SseMessage{"event": "chat_message", "text": "hi"})
+	if msg := readSseMessage(t, chat); msg.Event() != "chat_message" {
+		t.Errorf("filtered client got %q, want chat_message only", msg.Event())
+	}
+	for _, want := range []string{"user_join", "chat_message"} {
+		if msg := readSseMessage(t, everything); msg.Event() != want {
+			t.Errorf("unfiltered client got %q, want %s", msg.Event(), want)
+		}
+	}
+
+	// BroadcastSync does not wait for the client that filters the event out
+	n, err := sse.BroadcastSync(SseMessage{"event": "user_join"}, 2*time.Second)
+	if n != 1 || err != nil {
+		t.Fatalf("BroadcastSync = %d, %v, want 1, nil", n, err)
+	}
+	n, err = sse.BroadcastBlocking(SseMessage{"event": "user_join"}, time.Second)
+	if n != 1 || err != nil {
+		t.Fatalf("BroadcastBlocking = %d, %v, want 1, nil", n, err)
+	}
+}
+
+func TestSseOverHTTP2(t *testing.T) {
+	s := NewServiceBuilder().Build()
+	sse := s.RegisterSSE("/events", newTestSseHandler)
+	ts := httptest.NewUnstartedServer(s)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("protocol = %s, want HTTP/2", resp.Proto)
+	}
+	if got := resp.Header.Get("Connection"); got != "" {
+		t.Errorf("Connection = %q over HTTP/2", got)
+	}
+
+	events := bufio.NewReader(resp.Body)
+	if msg := readSseMessage(t, events); msg.Event() != "on_connect" {
+		t.Fatalf("first event = %q, want on_connect", msg.Event())
+	}
+	// each event is flushed as its own frame while the stream stays open
+	for _, name := range []string{"first", "second"} {
+		sse.Broadcast(SseMessage{"event": name})
+		if msg := readSseMessage(t, events); msg.Event() != name {
+			t.Fatalf("event = %q, want %s", msg.Event(), name)
+		}
+	}
+}
+
+func TestBroadcastBlockingUnderOverload(t *testing.T) {
+	srv := NewServiceBuilder().Build().RegisterSSE("/events", newTestSseHandler)
+	sessions := map[ClientID]*SseSession{}
+	for _, id := range []ClientID{"slow", "stuck"} {
+		session := &SseSession{
+			ctx:                context.Background(),
+			client_id:          id,
+			done:               make(chan struct{}),
+			direct_messages:    make(chan sseEnvelope, 1),
+			broadcast_messages: srv.fanout.CreateConsumer(context.Background()),
+		}
+		srv.add(session, "")
+		// both queues are full, as for a client that fell behind
+		session.DirectMessage(SseMessage{"event": "filler"})
+		sessions[id] = session
+	}
+
+	// the slow client catches up after a while, the stuck one never does
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		<-sessions["slow"].direct_messages
+	}()
+
+	start := time.Now()
+	n, err := srv.BroadcastBlocking(SseMessage{"event": "config_changed"}, 300*time.Millisecond)
+	if n != 1 || !errors.Is(err, ErrSseBroadcastTimeout) {
+		t.Fatalf("BroadcastBlocking = %d, %v, want 1, ErrSseBroadcastTimeout", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("BroadcastBlocking returned after %v, want about the timeout", elapsed)
+	}
+	if env := <-sessions["slow"].direct_messages; env.msg.Event() != "config_changed" {
+		t.Errorf("slow session got %q, want config_changed", env.msg.Event())
+	}
+
+	// the plain lossy broadcast does not wait
+	start = time.Now()
+	srv.Broadcast(SseMessage{"event": "tick"})
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Broadcast blocked for %v", elapsed)
+	}
+}
+
+func TestSlowConsumerIsReportedWhenQueueFills(t *testing.T) {
+	srv := NewServiceBuilder().Build().RegisterSSE("/events", newTestSseHandler)
+	session := &SseSession{
+		ctx:                context.Background(),
+		client_id:          "slow",
+		done:               make(chan struct{}),
+		direct_messages:    make(chan sseEnvelope, 8),
+		broadcast_messages: srv.fanout.CreateConsumer(context.Background()),
+	}
+	srv.add(session, "")
+
+	var reports []int
+	srv.SetSlowConsumerThreshold(3).OnSlowConsumer(func(s *SseSession, depth int) {
+		if s != session {
+			t.Errorf("reported session %s", s.ClientID())
+		}
+		reports = append(reports, depth)
+	})
+
+	fill := func(n int) {
+		for range n {
+			if err := session.DirectMessage(SseMessage{"event": "backlog"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	fill(2)
+	srv.checkSlowConsumers()
+	if len(reports) != 0 {
+		t.Fatalf("reported below the threshold: %v", reports)
+	}
+
+	fill(2)
+	srv.checkSlowConsumers()
+	srv.checkSlowConsumers()
+	if !slices.Equal(reports, []int{4}) {
+		t.Fatalf("reports = %v, want one at depth 4", reports)
+	}
+
+	// catching up rearms the callback for the next time it falls behind
+	for range 4 {
+		<-session.direct_messages
+	}
+	srv.checkSlowConsumers()
+	fill(3)
+	srv.checkSlowConsumers()
+	if !slices.Equal(reports, []int{4, 3}) {
+		t.Errorf("reports = %v, want [4 3]", reports)
+	}
+}
+
+func TestSessionMissingPongsIsClosed(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	s.RegisterSSE("/events", newTestSseHandler).
+		SetPingInterval(50 * time.Millisecond).
+		SetMaxMissedPongs(2)
+
+	alive, connect := connectSse(t, base+"/events")
+	silent, _ := connectSse(t, base+"/events")
+	silentClosed := make(chan struct{})
+	go func() {
+		defer close(silentClosed)
+		for {
+			if _, err := silent.ReadString('\n'); err != nil {
+				return
+			}
+		}
+	}()
+
+	// the live client answers every ping, as sse.js does, and outlasts
+	// the silent one by several pings
+	pings := 0
+	for after := 0; after < 4; {
+		msg := readSseMessage(t, alive)
+		if msg.Event() != "ping" {
+			continue
+		}
+		pings++
+		if pings > 20 {
+			t.Fatal("silent client was never closed")
+		}
+		resp := postCallback(t, base+"/events/callback", connect["client_id"], nil, SseMessage{"event": "pong"})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("pong: status = %d", resp.StatusCode)
+		}
+		select {
+		case <-silentClosed:
+			after++
+		default:
+		}
+	}
+}
+
+// lastEventIDRecorder reports the Last-Event-ID header of every connect.
+type lastEventIDRecorder struct {
+	testSseHandler
+	ids chan string
+}
+
+func (h *lastEventIDRecorder) OnConnect(w http.ResponseWriter, r *http.Request) error {
+	h.ids <- r.Header.Get("Last-Event-ID")
+	return nil
+}
+
+func TestSseClientPublishesAndReconnects(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	ids := make(chan string, 4)
+	published := make(chan string, 4)
+	sse := s.RegisterSSE("/events", func() SseEventHandler {
+		return &lastEventIDRecorder{
+			testSseHandler: testSseHandler{onCallback: func(w http.ResponseWriter, r *http.Request) {
+				event, _ := HttpParameterT[string](r, "event")
+				published <- event
+			}},
+			ids: ids,
+		}
+	}).SetRetryHint(50 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	client := NewSseClient(base+"/events", SseClientOptions{CallbackPath: "/callback"})
+	if err := client.Publish(ctx, SseMessage{"event": "early"}); !errors.Is(err, ErrSseClientNotConnected) {
+		t.Fatalf("Publish before connect = %v, want ErrSseClientNotConnected", err)
+	}
+	events := client.Connect(ctx)
+	next := func(event string) SseEvent {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case ev := <-events:
+				if ev.Event == event {
+					return ev
+				}
+			case <-timeout:
+				t.Fatalf("no %s event received", event)
+				return SseEvent{}
+			}
+		}
+	}
+	receive := func(ch chan string, what string) string {
+		t.Helper()
+		select {
+		case v := <-ch:
+			return v
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s", what)
+			return ""
+		}
+	}
+
+	next("on_connect")
+	if id := receive(ids, "connect"); id != "" {
+		t.Fatalf("first connect sent Last-Event-ID %q", id)
+	}
+	if err := client.Publish(ctx, SseMessage{"event": "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if event := receive(published, "callback"); event != "hello" {
+		t.Fatalf("OnCallback got %q, want hello", event)
+	}
+
+	sse.BroadcastWithID("7", SseMessage{"event": "numbered"})
+	next("numbered")
+	first := client.ClientID()
+	session, ok := sse.Find(first)
+	if !ok {
+		t.Fatalf("session %s not found", first)
+	}
+	session.Close()
+
+	next("on_connect")
+	if id := receive(ids, "reconnect"); id != "7" {
+		t.Fatalf("reconnect sent Last-Event-ID %q, want 7", id)
+	}
+	if client.ClientID() == "" {
+		t.Fatal("no client id after reconnect")
+	}
+	if err := client.Publish(ctx, SseMessage{"event": "again"}); err != nil {
+		t.Fatal(err)
+	}
+	if event := receive(published, "callback after reconnect"); event != "again" {
+		t.Fatalf("OnCallback got %q, want again", event)
+	}
+}
+
+func TestSseClientLogsThroughOptionsLogger(t *testing.T) {
+	logged := captureLog(t)
+	s, base := startTestService(t, NewServiceBuilder())
+	s.RegisterRouteGET("/down", func(w http.ResponseWriter, r *http.Request) {
+		WriteRaw(w, "text/plain", "down", http.StatusServiceUnavailable)
+	})
+
+	rec := &recordingLogger{}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	NewSseClient(base+"/down", SseClientOptions{ReconnectDelay: 10 * time.Millisecond, Logger: rec}).Connect(ctx)
+	silent, cancelSilent := context.WithCancel(context.Background())
+	t.Cleanup(cancelSilent)
+	NewSseClient(base+"/down", SseClientOptions{ReconnectDelay: 10 * time.Millisecond}).Connect(silent)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !rec.contains("503") {
+		if time.Now().After(deadline) {
+			t.Fatalf("failed connection not logged, got %q", rec.lines)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if logged.Len() != 0 {
+		t.Errorf("standard logger got %q", logged)
+	}
+}
+
+func TestDisableCallbacksRegistersNoCallbackRoute(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	factory, called := callbackRecorder()
+	s.RegisterSSEWithConfig("/events", factory, SseConfig{DisableCallbacks: true})
+
+	_, connect := connectSse(t, base+"/events")
+	if connect["callbacks"] != false {
+		t.Errorf("on_connect = %v, want callbacks false", connect)
+	}
+	clientID := connect["client_id"]
+	for _, url := range []string{base + "/events/callback", base + "/events"} {
+		resp := postCallback(t, url, clientID, nil, SseMessage{"event": "hello"})
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("POST %s: status = %d, want 404", url, resp.StatusCode)
+		}
+	}
+	select {
+	case event := <-called:
+		t.Fatalf("OnCallback ran for %q", event)
+	default:
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	client := NewSseClient(base+"/events", SseClientOptions{CallbackPath: "/callback"})
+	clientEvents := client.Connect(ctx)
+	select {
+	case <-clientEvents:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+	}
+	if err := client.Publish(ctx, SseMessage{"event": "hello"}); !errors.Is(err, ErrSseCallbacksDisabled) {
+		t.Errorf("Publish = %v, want ErrSseCallbacksDisabled", err)
+	}
+}
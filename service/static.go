@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
@@ -43,37 +45,206 @@ type FnReplaceMacros func(r *http.Request, content []byte) []byte
 
 var StaticReplaceMacrosFn FnReplaceMacros
 
-func (s *Service) static(w http.ResponseWriter, r *http.Request) (bool, error) {
-	relativePath := "/"
+// writeStaticContent writes contents with the headers shared by all static
+// responses: Content-Type, Cache-Control, ETag, and Content-Length. A request
+// whose If-None-Match matches the ETag gets a 304 with no body.
+func writeStaticContent(w http.ResponseWriter, r *http.Request, contentType, cacheControl string, contents []byte) error {
+	etag := ETag(contents)
 
-	if s.serviceName != "" {
-		prefix := "/service/" + s.serviceName
-		relativePath = strings.TrimPrefix(r.URL.Path, prefix)
-	} else {
-                relativePath = r.URL.Path
-        }
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", etag)
 
-	if relativePath == "" || relativePath == "/" {
-		relativePath = "/index.html"
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(contents)))
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	_, err := w.Write(contents)
+	return err
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// StaticCacheImmutable is the Cache-Control for fingerprinted assets whose
+// content never changes under the same name, such as app.abc123.js.
+const StaticCacheImmutable = "public, max-age=31536000, immutable"
+
+// SetStaticCacheControl sets a function choosing the Cache-Control header
+// of static files from their slash-rooted path within the static directory,
+// such as "/assets/app.abc123.js", for example StaticCacheImmutable for
+// hashed assets. Returning "" keeps the
+// default "no-cache", which revalidates with the ETag on every use.
+func (s *Service) SetStaticCacheControl(fn func(path string) string) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.staticCacheControl = fn
+	return s
+}
+
+// precompressedEncodings are the sibling files static looks for, in order
+// of preference, with the Content-Encoding each is served with.
+var precompressedEncodings = []struct {
+	coding    string
+	extension string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// precompressedVariant reads the precompressed sibling of filePath that r
+// accepts, setting Content-Encoding for it, or else filePath itself. Vary
+// is set whenever a sibling exists, so caches keep the variants apart. A
+// sibling that cannot be read is skipped, leaving the headers as they were.
+func precompressedVariant(w http.ResponseWriter, r *http.Request, filePath string) ([]byte, error) {
+	var contents []byte
+	chosen := false
+	for _, encoding := range precompressedEncodings {
+		variant := filePath + encoding.extension
+		if info, err := os.Stat(variant); err != nil || info.IsDir() {
+			continue
+		}
+		if chosen || !acceptsEncoding(r, encoding.coding) {
+			w.Header().Set("Vary", "Accept-Encoding")
+			continue
+		}
+		variantContents, err := os.ReadFile(variant)
+		if err != nil {
+			continue
+		}
+		w.Header().Set("Vary", "Accept-Encoding")
+		w.Header().Set("Content-Encoding", encoding.coding)
+		contents = variantContents
+		chosen = true
+	}
+	if chosen {
+		return contents, nil
 	}
+	return os.ReadFile(filePath)
+}
 
-	var StaticPath string = "./static"
+// SetStaticPrefix sets the path prefix stripped from requests before they
+// are looked up in the static directory. It defaults to the load balancer
+// prefix "/service/<name>" when the service has a name, and to none
+// otherwise. Requests without the prefix are not served from the static
+// directory, unless SetStaticPrefixOptional allows it.
+func (s *Service) SetStaticPrefix(prefix string) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prefix = strings.TrimSuffix(prefix, "/")
+	s.staticPrefix = &prefix
+	return s
+}
+
+// SetStaticPrefixOptional serves static files for requests that lack the
+// static prefix as well, resolving their path from the root of the static
+// directory. This suits reaching the service directly, without the load
+// balancer in front.
+func (s *Service) SetStaticPrefixOptional(optional bool) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.staticPrefixOptional = optional
+	return s
+}
+
+// AddStaticDir adds a directory searched for static files after the
+// static path and any directory added before it, so files in earlier
+// directories take priority, for example app assets over vendored ones.
+// Without SetStaticPath only the added directories are searched, not the
+// default ./static.
+func (s *Service) AddStaticDir(path string) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.staticDirs = append(slices.Clone(s.staticDirs), path)
+	return s
+}
+
+// staticRoots returns the directories searched for static files, in
+// order.
+func (s *Service) staticRoots() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	roots := s.staticDirs
 	if s.staticPath != "" {
-		StaticPath = s.staticPath
+		roots = append([]string{s.staticPath}, roots...)
+	}
+	if len(roots) == 0 {
+		roots = []string{"./static"}
 	}
+	return roots
+}
 
-	if _, err := os.Stat(StaticPath); err != nil {
-		if os.IsNotExist(err) {
-			return false, nil
+// findStaticFile returns the first file at relativePath under the static
+// roots, or "" if none has it. relativePath is already cleaned, so joining
+// it keeps the result inside its root.
+func (s *Service) findStaticFile(relativePath string) (string, error) {
+	for _, root := range s.staticRoots() {
+		filePath := filepath.Join(root, relativePath)
+		if _, err := os.Stat(filePath); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", err
 		}
-		return false, err
+		return filePath, nil
 	}
+	return "", nil
+}
 
-	filePath := filepath.Join(StaticPath, relativePath)
-	if _, err := os.Stat(filePath); err != nil {
-		if os.IsNotExist(err) {
-			return false, nil
+// staticRelativePath returns the path of the request relative to the
+// static directory, cleaned so it cannot step outside of it. It reports
+// false when the request lacks a required prefix. The prefix only matches
+// whole segments, so "/service/app" does not match "/service/apple".
+func (s *Service) staticRelativePath(urlPath string) (string, bool) {
+	s.mu.RLock()
+	prefix := ""
+	if s.staticPrefix != nil {
+		prefix = *s.staticPrefix
+	} else if s.serviceName != "" {
+		prefix = "/service/" + s.serviceName
+	}
+	optional := s.staticPrefixOptional
+	s.mu.RUnlock()
+
+	relativePath := urlPath
+	if prefix != "" {
+		rest, ok := strings.CutPrefix(urlPath, prefix)
+		switch {
+		case ok && (rest == "" || strings.HasPrefix(rest, "/")):
+			relativePath = rest
+		case !optional:
+			return "", false
 		}
+	}
+	return path.Clean("/" + relativePath), true
+}
+
+func (s *Service) static(w http.ResponseWriter, r *http.Request) (bool, error) {
+	relativePath, ok := s.staticRelativePath(r.URL.Path)
+	if !ok {
+		return false, nil
+	}
+
+	if relativePath == "/" {
+		relativePath = "/index.html"
+	}
+
+	filePath, err := s.findStaticFile(relativePath)
+	if filePath == "" {
 		return false, err
 	}
 
@@ -88,11 +259,14 @@ func (s *Service) static(w http.ResponseWriter, r *http.Request) (bool, error) {
 	}
 
 	if shouldIntercept {
-		contentType := getContentType(matchingSuffix)
-		w.Header().Set("Content-Type", contentType)
-		w.Header().Set("Cache-Control", "no-cache")
-
-		contents, err := os.ReadFile(filePath)
+		// Macros rewrite the plain file, so precompressed siblings are only
+		// used without them.
+		var contents []byte
+		if StaticReplaceMacrosFn == nil {
+			contents, err = precompressedVariant(w, r, filePath)
+		} else {
+			contents, err = os.ReadFile(filePath)
+		}
 		if err != nil {
 			return false, nil
 		}
@@ -101,8 +275,21 @@ func (s *Service) static(w http.ResponseWriter, r *http.Request) (bool, error) {
 			contents = StaticReplaceMacrosFn(r, contents)
 		}
 
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(contents)))
-		if _, err := w.Write(contents); err != nil {
+		cacheControl := "no-cache"
+		s.mu.RLock()
+		cacheControlFn := s.staticCacheControl
+		preloadLinks := s.staticPreloadLinks
+		s.mu.RUnlock()
+		if relativePath == "/index.html" {
+			addPreloadLinks(w, preloadLinks)
+		}
+		if cacheControlFn != nil {
+			if value := cacheControlFn(relativePath); value != "" {
+				cacheControl = value
+			}
+		}
+
+		if err := writeStaticContent(w, r, getContentType(matchingSuffix), cacheControl, contents); err != nil {
 			s.Logger.Errorln("http_sse_static_middleware", "failed to write", err)
 			return false, err
 		}
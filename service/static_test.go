@@ -0,0 +1,196 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// staticService serves files from a temporary static directory holding
+// files, a map of relative path to contents.
+func staticService(t *testing.T, files map[string]string) *Service {
+	t.Helper()
+	return NewServiceBuilder().Build().SetStaticPath(staticDir(t, files))
+}
+
+// staticDir writes files into a temporary directory and returns it.
+func staticDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func getStatic(s *Service, target, acceptEncoding string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", target, nil)
+	if acceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestStaticCacheControl(t *testing.T) {
+	s := staticService(t, map[string]string{
+		"index.html":            "<html></html>",
+		"assets/app.abc123.js":  "console.log(1)",
+		"assets/app.abc123.css": "body{}",
+	})
+	s.SetStaticCacheControl(func(path string) string {
+		if strings.HasPrefix(path, "/assets/") && strings.HasSuffix(path, ".js") {
+			return StaticCacheImmutable
+		}
+		return ""
+	})
+
+	tests := map[string]string{
+		"/assets/app.abc123.js":  StaticCacheImmutable,
+		"/assets/app.abc123.css": "no-cache",
+		"/index.html":            "no-cache",
+	}
+	for target, want := range tests {
+		rec := getStatic(s, target, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d", target, rec.Code)
+		}
+		if got := rec.Header().Get("Cache-Control"); got != want {
+			t.Errorf("%s: Cache-Control = %q, want %q", target, got, want)
+		}
+	}
+}
+
+func TestStaticPrecompressedVariants(t *testing.T) {
+	s := staticService(t, map[string]string{
+		"app.js":    "plain",
+		"app.js.br": "brotli bytes",
+		"app.js.gz": "gzip bytes",
+		"only.js":   "no siblings",
+	})
+
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+		body           string
+	}{
+		{"gzip, deflate, br", "br", "brotli bytes"},
+		{"gzip", "gzip", "gzip bytes"},
+		{"br;q=0, gzip", "gzip", "gzip bytes"},
+		{"", "", "plain"},
+		{"identity", "", "plain"},
+	}
+	for _, tt := range tests {
+		rec := getStatic(s, "/app.js", tt.acceptEncoding)
+		if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("Accept-Encoding %q: Content-Encoding = %q, want %q", tt.acceptEncoding, got, tt.encoding)
+		}
+		if rec.Body.String() != tt.body {
+			t.Errorf("Accept-Encoding %q: body = %q, want %q", tt.acceptEncoding, rec.Body, tt.body)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "application/javascript" {
+			t.Errorf("Accept-Encoding %q: Content-Type = %q, want the original file's", tt.acceptEncoding, got)
+		}
+		if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: Vary = %q", tt.acceptEncoding, got)
+		}
+	}
+
+	rec := getStatic(s, "/only.js", "br, gzip")
+	if rec.Header().Get("Content-Encoding") != "" || rec.Header().Get("Vary") != "" || rec.Body.String() != "no siblings" {
+		t.Errorf("file without siblings: %v %q", rec.Header(), rec.Body)
+	}
+}
+
+func TestStaticUnreadableVariantServesPlainFile(t *testing.T) {
+	dir := staticDir(t, map[string]string{
+		"app.js":    "plain",
+		"app.js.gz": "gzip bytes",
+	})
+	variant := filepath.Join(dir, "app.js.gz")
+	if err := os.Chmod(variant, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.ReadFile(variant); err == nil {
+		t.Skip("file permissions are not enforced for this user")
+	}
+	s := NewServiceBuilder().Build().SetStaticPath(dir)
+
+	rec := getStatic(s, "/app.js", "gzip")
+	if rec.Code != http.StatusOK || rec.Body.String() != "plain" {
+		t.Fatalf("status %d, body %q, want the plain file", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q for an unreadable variant", got)
+	}
+}
+
+func TestStaticPrefix(t *testing.T) {
+	dir := staticDir(t, map[string]string{"index.html": "home", "app.js": "js"})
+	for _, tc := range []struct {
+		name   string
+		build  func() *Service
+		target string
+		want   string
+	}{
+		{"named prefixed", func() *Service { return NewServiceBuilder().SetServiceName("app").Build() }, "/service/app/app.js", "js"},
+		{"named prefix root", func() *Service { return NewServiceBuilder().SetServiceName("app").Build() }, "/service/app", "home"},
+		{"named unprefixed", func() *Service { return NewServiceBuilder().SetServiceName("app").Build() }, "/app.js", ""},
+		{"named partial segment", func() *Service { return NewServiceBuilder().SetServiceName("app").Build() }, "/service/apple/app.js", ""},
+		{"named optional", func() *Service {
+			return NewServiceBuilder().SetServiceName("app").Build().SetStaticPrefixOptional(true)
+		}, "/app.js", "js"},
+		{"explicit prefix", func() *Service { return NewServiceBuilder().Build().SetStaticPrefix("/ui/") }, "/ui/app.js", "js"},
+		{"explicit unprefixed", func() *Service { return NewServiceBuilder().Build().SetStaticPrefix("/ui") }, "/app.js", ""},
+		{"unnamed", func() *Service { return NewServiceBuilder().Build() }, "/app.js", "js"},
+	} {
+		rec := getStatic(tc.build().SetStaticPath(dir), tc.target, "")
+		if tc.want == "" {
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("%s: %s = %d %q, want 404", tc.name, tc.target, rec.Code, rec.Body)
+			}
+		} else if rec.Code != http.StatusOK || rec.Body.String() != tc.want {
+			t.Errorf("%s: %s = %d %q, want %q", tc.name, tc.target, rec.Code, rec.Body, tc.want)
+		}
+	}
+}
+
+func TestAddStaticDirSearchesInOrder(t *testing.T) {
+	app := staticDir(t, map[string]string{
+		"index.html": "<html>app</html>",
+		"shared.js":  "app",
+	})
+	outside := staticDir(t, map[string]string{
+		"secret.txt":       "secret",
+		"vendor/lib.js":    "vendor lib",
+		"vendor/shared.js": "vendor",
+	})
+	s := NewServiceBuilder().Build().SetStaticPath(app).AddStaticDir(filepath.Join(outside, "vendor"))
+
+	for target, want := range map[string]string{
+		"/":          "<html>app</html>",
+		"/shared.js": "app",
+		"/lib.js":    "vendor lib",
+	} {
+		rec := getStatic(s, target, "")
+		if rec.Code != http.StatusOK || rec.Body.String() != want {
+			t.Errorf("%s: got %d %q, want %q", target, rec.Code, rec.Body, want)
+		}
+	}
+
+	for _, target := range []string{"/missing.js", "/../secret.txt", "/%2e%2e/secret.txt"} {
+		if rec := getStatic(s, target, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d %q, want 404", target, rec.Code, rec.Body)
+		}
+	}
+}
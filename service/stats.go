@@ -1,9 +1,15 @@
 package service
 
+import "sync/atomic"
+
 type HttpRouteStat struct {
 	URI    string
 	Method string
 	Hits   int32
+	// CacheHits and CacheMisses count GET requests answered from and
+	// passed through the cache set with SetCache.
+	CacheHits   int64
+	CacheMisses int64
 }
 
 func (s *Service) Stats() []HttpRouteStat {
@@ -15,8 +21,14 @@ func (s *Service) Stats() []HttpRouteStat {
 		stats[i] = HttpRouteStat{
 			URI:    route.URI,
 			Method: route.Method,
-			Hits:   route.Hits,
+			Hits:   atomic.LoadInt32(&route.Hits),
+		}
+		route.mu.RLock()
+		if route.cache != nil {
+			stats[i].CacheHits = route.cache.hits.Load()
+			stats[i].CacheMisses = route.cache.misses.Load()
 		}
+		route.mu.RUnlock()
 	}
 
 	return stats
@@ -27,6 +39,12 @@ func (s *Service) ClearStats() {
 	defer s.mu.Unlock()
 
 	for _, route := range s.routes {
-		route.Hits = 0
+		atomic.StoreInt32(&route.Hits, 0)
+		route.mu.RLock()
+		if route.cache != nil {
+			route.cache.hits.Store(0)
+			route.cache.misses.Store(0)
+		}
+		route.mu.RUnlock()
 	}
 }
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"slices"
+	"time"
+)
+
+// DefaultStatsFeedInterval is how often RegisterStatsFeed sends a snapshot
+// unless StatsFeedConfig.Interval is set.
+const DefaultStatsFeedInterval = 5 * time.Second
+
+// Metrics a stats feed can include for each route.
+const (
+	StatsMetricHits        = "hits"
+	StatsMetricCacheHits   = "cache_hits"
+	StatsMetricCacheMisses = "cache_misses"
+)
+
+// StatsFeedConfig customizes RegisterStatsFeed. The zero value sends the
+// hits of every route every DefaultStatsFeedInterval.
+type StatsFeedConfig struct {
+	Interval time.Duration
+	// Metrics lists the StatsMetric values sent per route. Empty means
+	// StatsMetricHits only.
+	Metrics []string
+	// Routes, when set, selects the routes that are included.
+	Routes func(stat HttpRouteStat) bool
+}
+
+// RegisterStatsFeed serves live route statistics as an SSE stream at uri,
+// for an operations dashboard. Every interval, while any client is
+// connected, it broadcasts a "stats" event holding a snapshot of Stats:
+//
+//	{"event": "stats", "time": 1700000000, "routes": [{"uri": "*/add", "method": "GET", "hits": 12}]}
+//
+// The feed stops when the service shuts down. Route statistics reveal the
+// API surface, so protect uri, for example with Use.
+func (s *Service) RegisterStatsFeed(uri string, config StatsFeedConfig) *SseServer {
+	if config.Interval <= 0 {
+		config.Interval = DefaultStatsFeedInterval
+	}
+	if len(config.Metrics) == 0 {
+		config.Metrics = []string{StatsMetricHits}
+	}
+
+	feed := s.RegisterSSE(uri, nil)
+	go func() {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				if feed.ConnectionCount() > 0 {
+					feed.Broadcast(s.statsSnapshot(config))
+				}
+			}
+		}
+	}()
+	return feed
+}
+
+func (s *Service) statsSnapshot(config StatsFeedConfig) SseMessage {
+	routes := []map[string]interface{}{}
+	for _, stat := range s.Stats() {
+		if config.Routes != nil && !config.Routes(stat) {
+			continue
+		}
+		route := map[string]interface{}{
+			"uri":    stat.URI,
+			"method": stat.Method,
+		}
+		if slices.Contains(config.Metrics, StatsMetricHits) {
+			route[StatsMetricHits] = stat.Hits
+		}
+		if slices.Contains(config.Metrics, StatsMetricCacheHits) {
+			route[StatsMetricCacheHits] = stat.CacheHits
+		}
+		if slices.Contains(config.Metrics, StatsMetricCacheMisses) {
+			route[StatsMetricCacheMisses] = stat.CacheMisses
+		}
+		routes = append(routes, route)
+	}
+	return SseMessage{
+		"event":  "stats",
+		"time":   time.Now().Unix(),
+		"routes": routes,
+	}
+}
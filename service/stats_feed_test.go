@@ -0,0 +1,56 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStatsFeedSendsSnapshots(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder())
+	s.RegisterRouteGET("/ping", noopHandler)
+	s.RegisterRouteGET("/internal", noopHandler)
+	s.RegisterStatsFeed("/stats", StatsFeedConfig{
+		Interval: 20 * time.Millisecond,
+		Metrics:  []string{StatsMetricHits, StatsMetricCacheMisses},
+		Routes:   func(stat HttpRouteStat) bool { return stat.URI == "/ping" },
+	})
+
+	for range 2 {
+		resp, err := http.Get(base + "/ping")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	events, _ := connectSse(t, base+"/stats")
+	msg := readSseMessage(t, events)
+	if msg.Event() != "stats" {
+		t.Fatalf("event = %q, want stats", msg.Event())
+	}
+	if _, ok := msg["time"].(float64); !ok {
+		t.Errorf("snapshot has no time: %v", msg)
+	}
+	routes, _ := msg["routes"].([]interface{})
+	if len(routes) != 1 {
+		t.Fatalf("routes = %v, want only /ping", msg["routes"])
+	}
+	route := routes[0].(map[string]interface{})
+	if route["uri"] != "/ping" || route["method"] != "GET" || route["hits"] != float64(2) {
+		t.Errorf("route = %v, want /ping GET with 2 hits", route)
+	}
+	if _, ok := route["cache_misses"]; !ok {
+		t.Errorf("route = %v, want cache_misses", route)
+	}
+	if _, ok := route["cache_hits"]; ok {
+		t.Errorf("route = %v includes cache_hits, which was not configured", route)
+	}
+
+	// the feed ends with the service
+	s.Close()
+	if _, err := io.Copy(io.Discard, events); err != nil {
+		t.Errorf("stream ended with %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"net/http"
+)
+
+// HeaderInjector returns headers to add to an outbound call made with ctx,
+// for example a W3C traceparent taken from the span in ctx.
+type HeaderInjector func(ctx context.Context) http.Header
+
+// traceHeaders are the W3C Trace Context headers forwarded by
+// PropagateTraceHeaders.
+var traceHeaders = []string{"Traceparent", "Tracestate"}
+
+// withTraceHeaders stores the incoming request's trace context headers in
+// ctx, so calls made with the request context can forward them.
+func withTraceHeaders(ctx context.Context, r *http.Request) context.Context {
+	var header http.Header
+	for _, key := range traceHeaders {
+		if value := r.Header.Get(key); value != "" {
+			if header == nil {
+				header = make(http.Header)
+			}
+			header.Set(key, value)
+		}
+	}
+	if header == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, parameter_trace_headers, header)
+}
+
+// PropagateTraceHeaders is a HeaderInjector that forwards the traceparent
+// and tracestate headers of the incoming request whose context is ctx. Use
+// it when no tracing library is installed to inject its own span context.
+func PropagateTraceHeaders(ctx context.Context) http.Header {
+	header, _ := ctx.Value(parameter_trace_headers).(http.Header)
+	return header.Clone()
+}
+
+// SetHeaderInjector sets a function whose headers are added to every call,
+// so trace context passed to InvokeCtx reaches the called service.
+func (i *HttpInvoker) SetHeaderInjector(fn HeaderInjector) *HttpInvoker {
+	i.HeaderInjector = fn
+	return i
+}
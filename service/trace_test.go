@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInvokeCtxPropagatesIncomingTrace(t *testing.T) {
+	restoreToken(t)
+	SetToken("test-token")
+	outbound := make(chan http.Header, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outbound <- r.Header.Clone()
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(upstream.Close)
+
+	saved := DefaultInvoker
+	t.Cleanup(func() { DefaultInvoker = saved })
+	DefaultInvoker = (&HttpInvoker{BaseURL: upstream.URL + "/", Client: upstream.Client()}).
+		SetHeaderInjector(PropagateTraceHeaders)
+
+	s := NewServiceBuilder().Build()
+	s.RegisterRouteGET("/proxy", func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := InvokeCtx[map[string]any](r.Context(), "call", map[string]interface{}{}); err != nil {
+			t.Error(err)
+		}
+	})
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	r := httptest.NewRequest("GET", "/proxy", nil)
+	r.Header.Set("traceparent", traceparent)
+	r.Header.Set("tracestate", "vendor=1")
+	s.ServeHTTP(httptest.NewRecorder(), r)
+
+	header := <-outbound
+	if got := header.Get("traceparent"); got != traceparent {
+		t.Errorf("traceparent = %q, want %q", got, traceparent)
+	}
+	if got := header.Get("tracestate"); got != "vendor=1" {
+		t.Errorf("tracestate = %q, want vendor=1", got)
+	}
+}
+
+func TestPropagateTraceHeadersWithoutIncomingTrace(t *testing.T) {
+	if header := PropagateTraceHeaders(context.Background()); len(header) != 0 {
+		t.Fatalf("PropagateTraceHeaders = %v, want none", header)
+	}
+}
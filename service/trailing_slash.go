@@ -0,0 +1,71 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+)
+
+// TrailingSlashMode controls how a request path that only differs from a
+// route by a trailing slash is handled.
+type TrailingSlashMode int
+
+const (
+	// TrailingSlashStrict treats "/add" and "/add/" as different paths.
+	TrailingSlashStrict TrailingSlashMode = iota
+	// TrailingSlashRedirect redirects to the form that has a route.
+	TrailingSlashRedirect
+	// TrailingSlashLenient serves the route for either form.
+	TrailingSlashLenient
+)
+
+// SetTrailingSlashMode sets how trailing slashes are matched. The default is
+// TrailingSlashStrict.
+func (s *Service) SetTrailingSlashMode(mode TrailingSlashMode) *Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trailingSlash = mode
+	return s
+}
+
+// toggleTrailingSlash adds or removes the trailing slash of path. The root
+// path has no alternate form.
+func toggleTrailingSlash(path string) (string, bool) {
+	if path == "" || path == "/" {
+		return "", false
+	}
+	if strings.HasSuffix(path, "/") {
+		return strings.TrimSuffix(path, "/"), true
+	}
+	return path + "/", true
+}
+
+// redirectTrailingSlash redirects r to its alternate trailing slash form
+// when in redirect mode and that form has a route. GET and HEAD use 301,
+// other methods 308 so the method and body are preserved.
+func (s *Service) redirectTrailingSlash(w http.ResponseWriter, r *http.Request) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.trailingSlash != TrailingSlashRedirect {
+		return false
+	}
+
+	alternate, ok := toggleTrailingSlash(r.URL.Path)
+	if !ok {
+		return false
+	}
+	if _, _, found := s.resolvePath(r.Method, alternate); !found {
+		return false
+	}
+
+	location := *r.URL
+	location.Path = alternate
+	location.RawPath = ""
+
+	code := http.StatusMovedPermanently
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		code = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, location.RequestURI(), code)
+	return true
+}
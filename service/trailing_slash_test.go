@@ -0,0 +1,40 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrailingSlashModes(t *testing.T) {
+	tests := []struct {
+		mode     TrailingSlashMode
+		method   string
+		path     string
+		status   int
+		location string
+	}{
+		{TrailingSlashStrict, "GET", "/add", http.StatusOK, ""},
+		{TrailingSlashStrict, "GET", "/add/", http.StatusNotFound, ""},
+		{TrailingSlashRedirect, "GET", "/add/?x=1", http.StatusMovedPermanently, "/add?x=1"},
+		{TrailingSlashRedirect, "POST", "/add/", http.StatusPermanentRedirect, "/add"},
+		{TrailingSlashRedirect, "GET", "/list", http.StatusMovedPermanently, "/list/"},
+		{TrailingSlashRedirect, "GET", "/missing/", http.StatusNotFound, ""},
+		{TrailingSlashLenient, "GET", "/add/", http.StatusOK, ""},
+		{TrailingSlashLenient, "GET", "/list", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		s := NewServiceBuilder().Build().SetTrailingSlashMode(tt.mode)
+		s.RegisterRouteALL("/add", noopHandler)
+		s.RegisterRouteALL("/list/", noopHandler)
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("mode %d %s %s: status = %d, want %d", tt.mode, tt.method, tt.path, w.Code, tt.status)
+		}
+		if got := w.Header().Get("Location"); got != tt.location {
+			t.Errorf("mode %d %s %s: Location = %q, want %q", tt.mode, tt.method, tt.path, got, tt.location)
+		}
+	}
+}
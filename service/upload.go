@@ -0,0 +1,96 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrUploadNotFound is returned by HttpSaveUpload when the request has no
+// file part with the requested field name.
+var ErrUploadNotFound = errors.New("upload not found")
+
+// uploadStallTimeout is how long HttpSaveUpload waits for more of the
+// upload before giving up.
+const uploadStallTimeout = DefaultReadTimeout
+
+// HttpSaveUpload streams the file part named field of a multipart request
+// to destPath and returns the number of bytes written. The part is copied
+// straight from the connection, so uploads of any size use a fixed amount
+// of memory. It is written to a temporary file next to destPath and renamed
+// into place once complete, so a failed upload never leaves a partial file.
+//
+// The server's read timeout would cut off a long upload, so while copying
+// the read deadline is moved forward on every read: the upload may take as
+// long as it needs, but fails once the client sends nothing for a minute.
+//
+// The body can only be read once: use it instead of r.ParseMultipartForm,
+// and send other form fields ahead of the file or in the query string.
+// Multipart bodies are never read by the service's parameter parsing, so
+// form fields do not appear in HttpParameters either way. Marking the route
+// with SkipBodyParsing is optional; it also leaves a Content-Encoding
+// undecoded, which multipart uploads rarely use.
+func HttpSaveUpload(w http.ResponseWriter, r *http.Request, field, destPath string) (int64, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return 0, err
+	}
+
+	rc := http.NewResponseController(w)
+	extend := func() {}
+	if rc.SetReadDeadline(time.Now().Add(uploadStallTimeout)) == nil {
+		defer rc.SetReadDeadline(time.Time{})
+		extend = func() { rc.SetReadDeadline(time.Now().Add(uploadStallTimeout)) }
+	}
+
+	for {
+		extend()
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return 0, fmt.Errorf("%w: %s", ErrUploadNotFound, field)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if part.FormName() != field || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		written, err := saveUpload(&deadlineReader{source: part, extend: extend}, destPath)
+		part.Close()
+		return written, err
+	}
+}
+
+// deadlineReader calls extend before every read from source.
+type deadlineReader struct {
+	source io.Reader
+	extend func()
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	d.extend()
+	return d.source.Read(p)
+}
+
+func saveUpload(src io.Reader, destPath string) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(destPath), "."+filepath.Base(destPath)+".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return written, err
+	}
+	return written, os.Rename(tmp.Name(), destPath)
+}
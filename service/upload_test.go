@@ -0,0 +1,103 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowUpload returns a multipart body that sends a text field and then
+// chunks as the file part "file", pausing before each chunk.
+func slowUpload(chunks [][]byte, pause time.Duration) (io.Reader, string) {
+	body, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		mw.WriteField("note", "ignored")
+		part, err := mw.CreateFormFile("file", "data.bin")
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		for _, chunk := range chunks {
+			time.Sleep(pause)
+			if _, err := part.Write(chunk); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(mw.Close())
+	}()
+	return body, mw.FormDataContentType()
+}
+
+func TestHttpSaveUploadOutlivesReadTimeout(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder().SetReadTimeout(200*time.Millisecond))
+	dest := filepath.Join(t.TempDir(), "upload.bin")
+	type result struct {
+		n   int64
+		err error
+	}
+	saved := make(chan result, 1)
+	s.RegisterRoutePOST("/upload", func(w http.ResponseWriter, r *http.Request) {
+		n, err := HttpSaveUpload(w, r, "file", dest)
+		saved <- result{n, err}
+		if err != nil {
+			WriteErrorCode(w, http.StatusBadRequest, err)
+		}
+	})
+
+	var chunks [][]byte
+	var want []byte
+	for i := range 8 {
+		chunk := bytes.Repeat([]byte{byte('a' + i)}, 512*1024)
+		chunks = append(chunks, chunk)
+		want = append(want, chunk...)
+	}
+	body, contentType := slowUpload(chunks, 80*time.Millisecond)
+	resp, err := http.Post(base+"/upload", contentType, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	got := <-saved
+	if got.err != nil || got.n != int64(len(want)) {
+		t.Fatalf("HttpSaveUpload = %d, %v, want %d bytes", got.n, got.err, len(want))
+	}
+	contents, err := os.ReadFile(dest)
+	if err != nil || !bytes.Equal(contents, want) {
+		t.Fatalf("saved file has %d bytes, err %v; contents differ from upload", len(contents), err)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(dest), ".upload.bin.upload-*"))
+	if len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestHttpSaveUploadMissingField(t *testing.T) {
+	body, contentType := slowUpload([][]byte{[]byte("data")}, 0)
+	r := httptest.NewRequest("POST", "/upload", body)
+	r.Header.Set("Content-Type", contentType)
+	dest := filepath.Join(t.TempDir(), "upload.bin")
+
+	if _, err := HttpSaveUpload(httptest.NewRecorder(), r, "other", dest); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("err = %v, want ErrUploadNotFound", err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("destination exists after a failed upload: %v", err)
+	}
+
+	r = httptest.NewRequest("POST", "/upload", strings.NewReader("{}"))
+	r.Header.Set("Content-Type", "application/json")
+	if _, err := HttpSaveUpload(httptest.NewRecorder(), r, "file", dest); err == nil {
+		t.Error("HttpSaveUpload accepted a JSON body")
+	}
+}
@@ -2,16 +2,51 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"time"
 )
 
+var (
+	// ErrResponseMarshal is returned by WriteT when msg cannot be encoded.
+	// Nothing of msg was written and the client received a 500.
+	ErrResponseMarshal = errors.New("failed to marshal response")
+	// ErrResponseWrite is returned when writing the body failed, typically
+	// because the client went away. The status was already sent, so it is
+	// too late to answer with an error.
+	ErrResponseWrite = errors.New("failed to write response")
+)
+
+// prettyJSONWriter is implemented by the service's response writers to
+// tell WriteT the response was asked to be indented.
+type prettyJSONWriter interface {
+	prettyJSON() bool
+}
+
+func wantsPrettyJSON(w http.ResponseWriter) bool {
+	pw, ok := w.(prettyJSONWriter)
+	return ok && pw.prettyJSON()
+}
+
+// WriteT writes msg as JSON. The output is indented when the service has
+// pretty printing enabled, see SetPrettyJSON and SetPrettyJSONQuery.
+// If msg cannot be marshalled the client receives a 500 and the error wraps
+// ErrResponseMarshal; a failed write wraps ErrResponseWrite.
 func WriteT[T any](w http.ResponseWriter, msg T) error {
-	encoded, err := json.Marshal(msg)
+	var encoded []byte
+	var err error
+	if wantsPrettyJSON(w) {
+		encoded, err = json.MarshalIndent(msg, "", "  ")
+	} else {
+		encoded, err = json.Marshal(msg)
+	}
 	if err != nil {
 		log.Println("WriteT failed to marshal", "error", err)
-		return err
+		WriteErrorCode(w, http.StatusInternalServerError, ErrResponseMarshal)
+		return fmt.Errorf("%w: %w", ErrResponseMarshal, err)
 	}
 
 	return WriteRaw(w, "application/json", encoded)
@@ -52,14 +87,123 @@ func WriteRaw[T ~string | ~[]byte](w http.ResponseWriter, contentType string, da
 
 	// Write the response
 	if _, err := w.Write(responseData); err != nil {
-		return fmt.Errorf("failed to write response: %w", err)
+		return fmt.Errorf("%w: %w", ErrResponseWrite, err)
 	}
 
 	return nil
 }
 
+// WriteHTML writes html as a text/html page with status 200, or the status
+// given in opts.
+func WriteHTML[T ~string | ~[]byte](w http.ResponseWriter, html T, opts ...int) error {
+	return WriteRaw(w, "text/html; charset=utf-8", html, opts...)
+}
+
+// streamBufferSize is the size of the reads WriteStream flushes to the
+// client one at a time.
+const streamBufferSize = 32 * 1024
+
+// WriteStream copies r to the response with status 200, or the status
+// given in opts, flushing after every read so the client receives a large
+// file or proxied body progressively while memory stays bounded. The
+// status is sent before r is read, so a read error can only cut the
+// response short; it is returned for the caller to log. A failed write wraps
+// ErrResponseWrite. Like WriteChunked, the copy is meant to outlive the
+// server's write timeout, so its write deadline is cleared.
+func WriteStream(w http.ResponseWriter, contentType string, r io.Reader, opts ...int) error {
+	statusCode := http.StatusOK
+	if len(opts) > 0 {
+		statusCode = opts[0]
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.WriteHeader(statusCode)
+
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, streamBufferSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return fmt.Errorf("%w: %w", ErrResponseWrite, werr)
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// WriteRedirect redirects the client to location, which may be relative to
+// the request path. A code of 0 uses 303 See Other, the status for sending
+// the browser to a page with GET after a form POST.
+func WriteRedirect(w http.ResponseWriter, r *http.Request, location string, code int) {
+	if code == 0 {
+		code = http.StatusSeeOther
+	}
+	http.Redirect(w, r, location, code)
+}
+
 func WriteError(w http.ResponseWriter, err error) {
+	WriteErrorCode(w, http.StatusBadRequest, err)
+}
+
+// WriteErrorCode writes err in the same shape as WriteError with the given
+// status code.
+//
+// Within a service handler the error goes through the service's error
+// renderer, see SetErrorRenderer, so it may be presented differently.
+func WriteErrorCode(w http.ResponseWriter, statusCode int, err error) {
+	if errorRenderingOf(w).renderError(w, statusCode, err) {
+		return
+	}
+	writeErrorJSON(w, statusCode, err)
+}
+
+// writeErrorJSON writes the {"error": ...} body of WriteError.
+func writeErrorJSON(w http.ResponseWriter, statusCode int, err error) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusBadRequest)
+	w.WriteHeader(statusCode)
 	w.Write([]byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
 }
+
+// Problem is an RFC 7807 problem details object. Type is a URI naming the
+// kind of problem, "about:blank" when empty; Title summarises that kind
+// and Detail this occurrence; Instance is a URI identifying the occurrence.
+type Problem struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// WriteProblem writes problem as application/problem+json with status, for
+// APIs following RFC 7807 instead of the {"error": ...} shape of
+// WriteError. problem.Status is set to status and an empty Title to the
+// status text.
+func WriteProblem(w http.ResponseWriter, status int, problem Problem) error {
+	problem.Status = status
+	if problem.Title == "" {
+		problem.Title = http.StatusText(status)
+	}
+
+	var encoded []byte
+	var err error
+	if wantsPrettyJSON(w) {
+		encoded, err = json.MarshalIndent(problem, "", "  ")
+	} else {
+		encoded, err = json.Marshal(problem)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrResponseMarshal, err)
+	}
+	return WriteRaw(w, "application/problem+json", encoded, status)
+}
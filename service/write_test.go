@@ -0,0 +1,206 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestWriteTMarshalFailureAnswers500(t *testing.T) {
+	captureLog(t)
+	s := NewServiceBuilder().Build()
+	errs := make(chan error, 1)
+	s.RegisterRouteGET("/bad", func(w http.ResponseWriter, r *http.Request) {
+		errs <- WriteT(w, map[string]interface{}{"ch": make(chan int)})
+	})
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/bad", nil))
+	if err := <-errs; !errors.Is(err, ErrResponseMarshal) || errors.Is(err, ErrResponseWrite) {
+		t.Errorf("WriteT = %v, want ErrResponseMarshal", err)
+	}
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+}
+
+// brokenPipeWriter accepts the status but fails every write.
+type brokenPipeWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w brokenPipeWriter) Write(b []byte) (int, error) {
+	return 0, syscall.EPIPE
+}
+
+func TestWriteFailureIsReportedOnce(t *testing.T) {
+	rec := &recordingLogger{}
+	s := NewServiceBuilder().SetLogger(rec).Build()
+	errs := make(chan error, 2)
+	s.RegisterRouteGET("/gone", func(w http.ResponseWriter, r *http.Request) {
+		errs <- WriteT(w, "first")
+		errs <- WriteRaw(w, "text/plain", "second")
+	})
+	var hooked []error
+	s.OnWriteError(func(r *http.Request, err error) {
+		hooked = append(hooked, err)
+	})
+
+	w := brokenPipeWriter{httptest.NewRecorder()}
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/gone", nil))
+	for range 2 {
+		if err := <-errs; !errors.Is(err, ErrResponseWrite) || !errors.Is(err, syscall.EPIPE) {
+			t.Errorf("write = %v, want ErrResponseWrite wrapping EPIPE", err)
+		}
+	}
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want the 200 sent before the failure", w.Code)
+	}
+	if len(hooked) != 1 || !errors.Is(hooked[0], syscall.EPIPE) {
+		t.Errorf("OnWriteError saw %v, want one EPIPE", hooked)
+	}
+	if !rec.contains("response write failed") {
+		t.Errorf("write failure was not logged: %q", rec.lines)
+	}
+}
+
+func TestWriteRedirectAfterPost(t *testing.T) {
+	for _, tc := range []struct {
+		code, want int
+		location   string
+		wantHeader string
+	}{
+		{0, http.StatusSeeOther, "/done", "/done"},
+		{http.StatusFound, http.StatusFound, "https://example.com/x", "https://example.com/x"},
+		{http.StatusPermanentRedirect, http.StatusPermanentRedirect, "thanks", "/forms/thanks"},
+	} {
+		rec := httptest.NewRecorder()
+		WriteRedirect(rec, httptest.NewRequest("POST", "/forms/submit", nil), tc.location, tc.code)
+		if rec.Code != tc.want || rec.Header().Get("Location") != tc.wantHeader {
+			t.Errorf("WriteRedirect(%q, %d) = %d to %q, want %d to %q",
+				tc.location, tc.code, rec.Code, rec.Header().Get("Location"), tc.want, tc.wantHeader)
+		}
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if err := WriteHTML(rec, "<p>created</p>", http.StatusCreated); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusCreated || rec.Body.String() != "<p>created</p>" ||
+		rec.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("got %d %q as %q", rec.Code, rec.Body, rec.Header().Get("Content-Type"))
+	}
+}
+
+// slowReader yields bytes of a repeating pattern, pausing before each
+// read, like a large file read from a slow disk or upstream.
+type slowReader struct {
+	remaining int
+	pause     time.Duration
+	offset    int
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.pause)
+	n := min(len(p), r.remaining)
+	for i := range n {
+		p[i] = byte((r.offset + i) % 251)
+	}
+	r.offset += n
+	r.remaining -= n
+	return n, nil
+}
+
+func TestWriteStreamOutlivesWriteTimeout(t *testing.T) {
+	s, base := startTestService(t, NewServiceBuilder().SetWriteTimeout(200*time.Millisecond))
+	const size = 4 << 20
+	s.RegisterRouteGET("/large", func(w http.ResponseWriter, r *http.Request) {
+		WriteStream(w, "application/octet-stream", &slowReader{remaining: size, pause: 5 * time.Millisecond})
+	})
+
+	resp, err := http.Get(base + "/large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read %d of %d bytes: %v", len(body), size, err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/octet-stream" {
+		t.Fatalf("got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if len(body) != size {
+		t.Fatalf("read %d bytes, want %d", len(body), size)
+	}
+	for i, b := range body {
+		if b != byte(i%251) {
+			t.Fatalf("byte %d = %d, want %d", i, b, i%251)
+		}
+	}
+}
+
+func TestWriteStreamReturnsReadErrorWithoutLogging(t *testing.T) {
+	logged := captureLog(t)
+	readErr := errors.New("disk gone")
+	rec := httptest.NewRecorder()
+	err := WriteStream(rec, "text/plain", io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(readErr)))
+	if !errors.Is(err, readErr) {
+		t.Fatalf("WriteStream = %v, want the read error", err)
+	}
+	if rec.Body.String() != "partial" {
+		t.Errorf("body = %q, want what was read before the error", rec.Body)
+	}
+	if logged.Len() != 0 {
+		t.Errorf("standard logger got %q", logged)
+	}
+}
+
+func TestWriteProblem(t *testing.T) {
+	rec := httptest.NewRecorder()
+	err := WriteProblem(rec, http.StatusConflict, Problem{
+		Type:     "https://example.com/probs/taken",
+		Detail:   "name ann is taken",
+		Instance: "/users/ann",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusConflict || rec.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"type":     "https://example.com/probs/taken",
+		"title":    "Conflict",
+		"status":   float64(http.StatusConflict),
+		"detail":   "name ann is taken",
+		"instance": "/users/ann",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("problem = %v, want %v", got, want)
+	}
+
+	// only status and the default title are required
+	rec = httptest.NewRecorder()
+	WriteProblem(rec, http.StatusNotFound, Problem{})
+	if body := strings.TrimSpace(rec.Body.String()); body != `{"title":"Not Found","status":404}` {
+		t.Errorf("minimal problem = %s", body)
+	}
+}